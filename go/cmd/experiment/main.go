@@ -20,6 +20,7 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 
+	"mp-htlc-lgp/experiment/internal/apiauth"
 	"mp-htlc-lgp/experiment/internal/config"
 	"mp-htlc-lgp/experiment/internal/eth"
 	"mp-htlc-lgp/experiment/internal/tssnet"
@@ -55,7 +56,13 @@ func main() {
 	receiverKey, receiverAddr, err := eth.PrivKeyFromHex(env.ReceiverPK)
 	if err != nil { log.Fatalf("receiver pk: %v", err) }
 
-	signerAPI := tssapi.New(env.SignerURL)
+	signerAPI := tssnet.New(env.SignerURL)
+	signerAPI.Creds = apiauth.Credentials{KeyID: env.SignerKeyID, Secret: env.SignerSecret}
+	signerAPI.KeyID = env.SigningKeyID
+	signerAPI.Path = env.SigningPath
+	if env.SignerTLSCert != "" || env.SignerTLSCA != "" {
+		if err := signerAPI.UseTLS(env.SignerTLSCert, env.SignerTLSKey, env.SignerTLSCA); err != nil { log.Fatalf("signer tls: %v", err) }
+	}
 	signerAddrHex, _, err := signerAPI.GetAddress()
 	if err != nil { log.Fatalf("signer /address: %v", err) }
 	signerAddr := eth.MustAddress(signerAddrHex)
@@ -69,8 +76,8 @@ func main() {
 	depositWindow := big.NewInt(env.DepositWindowSec)
 	penaltyWindow := big.NewInt(env.PenaltyWindowSec)
 
-	log.Printf("scenario=%s\nchainID=%d\nHTLC=%s\nToken=%s\nADDR_TSS=%s\nReceiver=%s\n",
-		*scenario, env.ChainID, htlc.Hex(), token.Hex(), signerAddr.Hex(), receiverAddr.Hex())
+	log.Printf("scenario=%s\nchainID=%d\nHTLC=%s\nToken=%s\nADDR_TSS=%s (path=%q)\nReceiver=%s\n",
+		*scenario, env.ChainID, htlc.Hex(), token.Hex(), signerAddr.Hex(), env.SigningPath, receiverAddr.Hex())
 
 	// Fund ADDR_TSS with some ETH for gas (testnet). You can set FUND_TSS_WEI=0 to skip.
 	if fundTSS.Sign() > 0 {
@@ -254,28 +261,21 @@ func claimWithSig(ctx context.Context, rpc *ethclient.Client, chainID *big.Int,
 	return sendEOATx(ctx, rpc, chainID, key, from, &htlc, data, big.NewInt(0))
 }
 
-func buildClaimSig(ctx context.Context, signerAPI *tssapi.Client, chainID *big.Int, verifyingContract common.Address, lockId common.Hash, receiver common.Address, expectedSigner common.Address) []byte {
+func buildClaimSig(ctx context.Context, signerAPI *tssnet.Client, chainID *big.Int, verifyingContract common.Address, lockId common.Hash, receiver common.Address, expectedSigner common.Address) []byte {
 	lid := [32]byte{}
 	copy(lid[:], lockId.Bytes())
+	// signer checks the lock on chain, then returns 65 bytes with v=27/28 for OZ ECDSA.recover
+	sig65, err := signerAPI.AuthorizeClaim(verifyingContract, lid)
+	if err != nil { panic(err) }
 	digest := eth.ClaimDigest(chainID, verifyingContract, lid, receiver)
-	r, s, err := signerAPI.SignHash(digest.Bytes())
+	check := append([]byte{}, sig65...)
+	check[64] -= 27
+	pub, err := crypto.SigToPub(digest.Bytes(), check)
 	if err != nil { panic(err) }
-	// derive v (0/1) then return 65 bytes with v=27/28 for OZ ECDSA.recover
-	var sig65 [65]byte
-	copy(sig65[0:32], r)
-	copy(sig65[32:64], s)
-	for v := byte(0); v < 2; v++ {
-		sig65[64] = v
-		pub, err := crypto.SigToPub(digest.Bytes(), sig65[:])
-		if err != nil { continue }
-		addr := crypto.PubkeyToAddress(*pub)
-		if addr != expectedSigner {
-			continue
-		}
-		sig65[64] = v + 27
-		return sig65[:]
+	if addr := crypto.PubkeyToAddress(*pub); addr != expectedSigner {
+		panic(fmt.Sprintf("claim signature recovers to %s, expected %s", addr.Hex(), expectedSigner.Hex()))
 	}
-	panic("cannot compute v for claim signature")
+	return sig65
 }
 
 func writeLog(path string, scenario string, step string, tx *types.Transaction, r *types.Receipt) {
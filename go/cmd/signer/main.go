@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"log"
+	"math/big"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+
+	"mp-htlc-lgp/experiment/internal/apiauth"
+	"mp-htlc-lgp/experiment/internal/eth"
 )
 
 type signReq struct {
@@ -23,6 +31,38 @@ type signResp struct {
 	S string `json:"s"`
 }
 
+type signTxReq struct {
+	ChainID *big.Int        `json:"chain_id,omitempty"`
+	TxRLP   string          `json:"tx_rlp,omitempty"`
+	Tx      *eth.UnsignedTx `json:"tx,omitempty"`
+}
+
+type signTxResp struct {
+	Raw     string `json:"raw"`
+	Hash    string `json:"hash"`
+	Sighash string `json:"sighash"`
+	From    string `json:"from"`
+	V       string `json:"v"`
+	R       string `json:"r"`
+	S       string `json:"s"`
+}
+
+type authorizeClaimReq struct {
+	HTLC   string `json:"htlc"`
+	LockID string `json:"lockId"`
+}
+
+type personalSignReq struct {
+	Message    string `json:"message"`
+	MessageHex string `json:"message_hex"`
+}
+
+type sig65Resp struct {
+	Signature string `json:"signature"`
+	Digest    string `json:"digest"`
+	Address   string `json:"address"`
+}
+
 type addrResp struct {
 	Address string `json:"address"`
 	Pubkey  string `json:"pubkey_uncompressed"`
@@ -51,20 +91,31 @@ func main() {
 	k, addr := mustPK()
 	pubUncompressed := crypto.FromECDSAPub(&k.PublicKey)
 
+	// optional: API keys / HMAC (AUTH_KEYS) and TLS / mutual TLS (TLS_CERT, TLS_KEY, TLS_CLIENT_CA)
+	var auth *apiauth.Authenticator
+	if p := strings.TrimSpace(os.Getenv("AUTH_KEYS")); p != "" {
+		a, err := apiauth.Load(p)
+		if err != nil {
+			log.Fatalf("AUTH_KEYS: %v", err)
+		}
+		auth = a
+	}
+	tlsCert := strings.TrimSpace(os.Getenv("TLS_CERT"))
+
 	h := http.NewServeMux()
 	h.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	h.HandleFunc("/address", func(w http.ResponseWriter, r *http.Request) {
+	h.HandleFunc("/address", auth.Require(apiauth.ScopeAny, func(w http.ResponseWriter, r *http.Request) {
 		out := addrResp{Address: addr.Hex(), Pubkey: "0x" + hex.EncodeToString(pubUncompressed)}
 		b, _ := json.Marshal(out)
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = w.Write(b)
-	})
+	}))
 
-	h.HandleFunc("/signHash", func(w http.ResponseWriter, r *http.Request) {
+	h.HandleFunc("/signHash", auth.Require(apiauth.ScopeSign, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(405)
 			return
@@ -91,14 +142,203 @@ func main() {
 			_, _ = w.Write([]byte("sign fail"))
 			return
 		}
-		r := sig[0:32]
-		s := sig[32:64]
-		out := signResp{R: "0x" + hex.EncodeToString(r), S: "0x" + hex.EncodeToString(s)}
+		rb := sig[0:32]
+		sb := sig[32:64]
+		out := signResp{R: "0x" + hex.EncodeToString(rb), S: "0x" + hex.EncodeToString(sb)}
 		b, _ := json.Marshal(out)
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = w.Write(b)
-	})
+	}))
+
+	h.HandleFunc("/signTx", auth.Require(apiauth.ScopeSign, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(405)
+			return
+		}
+		var req signTxReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("bad json"))
+			return
+		}
+		var tx *types.Transaction
+		var err error
+		switch {
+		case req.TxRLP != "":
+			var raw []byte
+			if raw, err = hexutil.Decode(strings.TrimSpace(req.TxRLP)); err == nil {
+				tx, err = eth.DecodeUnsignedTx(raw, req.ChainID)
+			}
+		case req.Tx != nil:
+			tx, err = req.Tx.Transaction(req.ChainID)
+		default:
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("missing tx_rlp or tx"))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("bad tx: " + err.Error()))
+			return
+		}
+		chainID, err := eth.SigningChainID(tx, req.ChainID)
+		if err != nil {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		signer := types.LatestSignerForChainID(chainID)
+		signed, err := types.SignTx(tx, signer, k)
+		if err != nil {
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte("sign fail"))
+			return
+		}
+		raw, _ := signed.MarshalBinary()
+		v, rr, ss := signed.RawSignatureValues()
+		out := signTxResp{
+			Raw:     hexutil.Encode(raw),
+			Hash:    signed.Hash().Hex(),
+			Sighash: signer.Hash(tx).Hex(),
+			From:    addr.Hex(),
+			V:       hexutil.EncodeBig(v),
+			R:       hexutil.EncodeBig(rr),
+			S:       hexutil.EncodeBig(ss),
+		}
+		b, _ := json.Marshal(out)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	}))
+
+	// sign65 answers with r||s||v, v = 27/28.
+	sign65 := func(w http.ResponseWriter, digest common.Hash) {
+		sig, err := crypto.Sign(digest.Bytes(), k)
+		if err != nil {
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte("sign fail"))
+			return
+		}
+		sig[64] += 27
+		b, _ := json.Marshal(sig65Resp{Signature: hexutil.Encode(sig), Digest: digest.Hex(), Address: addr.Hex()})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	}
+
+	h.HandleFunc("/signTypedData", auth.Require(apiauth.ScopeSign, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(405)
+			return
+		}
+		var td apitypes.TypedData
+		if err := json.NewDecoder(r.Body).Decode(&td); err != nil {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("bad json"))
+			return
+		}
+		digest, err := eth.TypedDataHash(td)
+		if err != nil {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("bad typed data: " + err.Error()))
+			return
+		}
+		log.Printf("signTypedData primaryType=%s contract=%s digest=%s", td.PrimaryType, td.Domain.VerifyingContract, digest.Hex())
+		sign65(w, digest)
+	}))
+
+	h.HandleFunc("/personalSign", auth.Require(apiauth.ScopeSign, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(405)
+			return
+		}
+		var req personalSignReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("bad json"))
+			return
+		}
+		msg := []byte(req.Message)
+		if req.MessageHex != "" {
+			b, err := hexutil.Decode(strings.TrimSpace(req.MessageHex))
+			if err != nil {
+				w.WriteHeader(400)
+				_, _ = w.Write([]byte("bad message_hex"))
+				return
+			}
+			msg = b
+		}
+		if len(msg) == 0 {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("missing message"))
+			return
+		}
+		sign65(w, eth.PersonalHash(msg))
+	}))
+
+	// optional: on-chain checks for /authorizeClaim
+	var chain *eth.Client
+	if rpcURL := strings.TrimSpace(os.Getenv("SEPOLIA_RPC_URL")); rpcURL != "" {
+		c, err := eth.Dial(rpcURL)
+		if err != nil {
+			log.Fatalf("dial rpc: %v", err)
+		}
+		chain = c
+	}
+
+	h.HandleFunc("/authorizeClaim", auth.Require(apiauth.ScopeSign, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(405)
+			return
+		}
+		if chain == nil {
+			w.WriteHeader(503)
+			_, _ = w.Write([]byte("SEPOLIA_RPC_URL not set"))
+			return
+		}
+		var req authorizeClaimReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !common.IsHexAddress(req.HTLC) {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("bad json"))
+			return
+		}
+		lid, err := hexutil.Decode(strings.TrimSpace(req.LockID))
+		if err != nil || len(lid) != 32 {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("lockId must be 32 bytes"))
+			return
+		}
+		var lockID [32]byte
+		copy(lockID[:], lid)
+		htlc := common.HexToAddress(req.HTLC)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		lock, err := eth.CallLock(ctx, chain.RPC, htlc, lockID)
+		if err != nil {
+			w.WriteHeader(502)
+			_, _ = w.Write([]byte("read locks(): " + err.Error()))
+			return
+		}
+		head, err := chain.RPC.HeaderByNumber(ctx, nil)
+		if err != nil {
+			w.WriteHeader(502)
+			_, _ = w.Write([]byte("read head: " + err.Error()))
+			return
+		}
+		chainID, err := chain.RPC.ChainID(ctx)
+		if err != nil {
+			w.WriteHeader(502)
+			_, _ = w.Write([]byte("read chainId: " + err.Error()))
+			return
+		}
+		if err := eth.CheckClaimable(lock, head.Time, addr); err != nil {
+			w.WriteHeader(403)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		sign65(w, eth.ClaimDigest(chainID, htlc, lockID, lock.Receiver))
+	}))
 
-	log.Printf("tss-signer(mock) listening on %s\naddress=%s", listen, addr.Hex())
-	log.Fatal(http.ListenAndServe(listen, h))
+	log.Printf("tss-signer(mock) listening on %s (auth=%v tls=%v)\naddress=%s", listen, auth != nil, tlsCert != "", addr.Hex())
+	log.Fatal(apiauth.ListenAndServe(listen, h, tlsCert, strings.TrimSpace(os.Getenv("TLS_KEY")), strings.TrimSpace(os.Getenv("TLS_CLIENT_CA"))))
 }
@@ -0,0 +1,393 @@
+// Command tracestat analyses a coordinator trace (tss-coordinator -trace):
+// per job it rebuilds the protocol rounds, walks the critical path from the
+// gateway's cmd to the last result and reports bytes and fan-out per party.
+//
+//	tracestat [-job id] [-session s] [-json] trace.jsonl...
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+var (
+	jobFilter     = flag.String("job", "", "only this job")
+	sessionFilter = flag.String("session", "", "only this session")
+	asJSON        = flag.Bool("json", false, "print the analysis as JSON")
+)
+
+// startCmds open a job on the gateway side.
+var startCmds = map[string]bool{"keygen": true, "sign": true, "presign": true, "reshare": true}
+
+type roundStat struct {
+	Round      int     `json:"round"` // 0 = not tagged by the node
+	StartMs    float64 `json:"start_ms"`
+	EndMs      float64 `json:"end_ms"`
+	Msgs       int     `json:"msgs"`
+	Deliveries int     `json:"deliveries"`
+	Bytes      int     `json:"bytes"`
+	FanOut     float64 `json:"fan_out"`
+	Last       string  `json:"last_link"` // delivery that ended the round
+}
+
+type partyStat struct {
+	Party     string  `json:"party"`
+	SentMsgs  int     `json:"sent_msgs"`
+	SentBytes int     `json:"sent_bytes"` // every recipient counts
+	RecvMsgs  int     `json:"recv_msgs"`
+	RecvBytes int     `json:"recv_bytes"`
+	FanOut    float64 `json:"fan_out"`
+}
+
+// step is one hop of the critical path: the sender computed for ComputeMs
+// after its last input, then the message spent TransitMs on the link.
+type step struct {
+	Type      string  `json:"type"`
+	Cmd       string  `json:"cmd,omitempty"`
+	Round     int     `json:"round,omitempty"`
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	ComputeMs float64 `json:"compute_ms"`
+	TransitMs float64 `json:"transit_ms"`
+}
+
+type linkShare struct {
+	Link      string  `json:"link"`
+	Hops      int     `json:"hops"`
+	TransitMs float64 `json:"transit_ms"`
+	Share     float64 `json:"share"` // of T
+}
+
+type jobStat struct {
+	Session   string      `json:"session"`
+	Job       string      `json:"job"`
+	Cmd       string      `json:"cmd"`
+	Gateway   string      `json:"gateway"`
+	TMs       float64     `json:"t_ms"` // cmd received -> last result forwarded
+	Rounds    []roundStat `json:"rounds"`
+	Parties   []partyStat `json:"parties"`
+	Path      []step      `json:"critical_path"` // in time order
+	ComputeMs float64     `json:"critical_compute_ms"`
+	TransitMs float64     `json:"critical_transit_ms"`
+	Links     []linkShare `json:"critical_links"` // by transit, largest first
+	Queued    int         `json:"queued"`         // deliveries that went to a mailbox
+}
+
+func main() {
+	flag.Parse()
+	var recs []tssnet.TraceRecord
+	if flag.NArg() == 0 {
+		recs = read(os.Stdin, "stdin")
+	}
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		recs = append(recs, read(f, path)...)
+		f.Close()
+	}
+
+	byJob := map[[2]string][]tssnet.TraceRecord{}
+	var order [][2]string
+	for _, r := range recs {
+		if r.Job == "" || (*jobFilter != "" && r.Job != *jobFilter) || (*sessionFilter != "" && r.Session != *sessionFilter) {
+			continue
+		}
+		k := [2]string{r.Session, r.Job}
+		if _, ok := byJob[k]; !ok {
+			order = append(order, k)
+		}
+		byJob[k] = append(byJob[k], r)
+	}
+	var jobs []jobStat
+	for _, k := range order {
+		if js, ok := analyse(k[0], k[1], byJob[k]); ok {
+			jobs = append(jobs, js)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(jobs)
+		return
+	}
+	for _, js := range jobs {
+		report(js)
+	}
+	if len(jobs) == 0 {
+		fmt.Println("no jobs in trace")
+	}
+}
+
+func read(r io.Reader, name string) []tssnet.TraceRecord {
+	var out []tssnet.TraceRecord
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 1<<20), 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		var rec tssnet.TraceRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			log.Printf("%s:%d: %v", name, n, err)
+			continue
+		}
+		out = append(out, rec)
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return out
+}
+
+func ms(us int64) float64 { return float64(us) / 1000 }
+
+// analyse builds the stats of one job; jobs without the gateway's cmd (the
+// trace started mid-job) are skipped.
+func analyse(session, job string, recs []tssnet.TraceRecord) (jobStat, bool) {
+	js := jobStat{Session: session, Job: job}
+	var start *tssnet.TraceRecord
+	for i := range recs {
+		r := &recs[i]
+		if r.Type == "cmd" && startCmds[r.Cmd] && (start == nil || r.RecvUs < start.RecvUs) {
+			start = r
+		}
+	}
+	if start == nil {
+		return js, false
+	}
+	js.Cmd, js.Gateway = start.Cmd, start.From
+	t0 := start.RecvUs
+
+	// last result delivered to the gateway ends the job
+	var end *tssnet.TraceRecord
+	for i := range recs {
+		r := &recs[i]
+		if r.Queued {
+			js.Queued++
+			continue
+		}
+		if r.Type == "cmd" && strings.HasSuffix(r.Cmd, "_result") && r.To == js.Gateway && (end == nil || r.FwdUs > end.FwdUs) {
+			end = r
+		}
+	}
+	if end != nil {
+		js.TMs = ms(end.FwdUs - t0)
+	}
+
+	js.Rounds = rounds(recs, t0)
+	js.Parties = parties(recs)
+	if end != nil {
+		js.Path = criticalPath(recs, start, end)
+	}
+	links := map[string]*linkShare{}
+	for _, s := range js.Path {
+		js.ComputeMs += s.ComputeMs
+		js.TransitMs += s.TransitMs
+		k := s.From + "->" + s.To
+		if links[k] == nil {
+			links[k] = &linkShare{Link: k}
+		}
+		links[k].Hops++
+		links[k].TransitMs += s.TransitMs
+	}
+	for _, l := range links {
+		if js.TMs > 0 {
+			l.Share = l.TransitMs / js.TMs
+		}
+		js.Links = append(js.Links, *l)
+	}
+	sort.Slice(js.Links, func(i, j int) bool { return js.Links[i].TransitMs > js.Links[j].TransitMs })
+	return js, true
+}
+
+// rounds groups the wire messages by the round the node tagged them with.
+func rounds(recs []tssnet.TraceRecord, t0 int64) []roundStat {
+	type acc struct {
+		roundStat
+		start, end int64
+		ids        map[string]bool
+	}
+	by := map[int]*acc{}
+	for _, r := range recs {
+		if r.Type != "send" || r.Queued {
+			continue
+		}
+		a := by[r.Round]
+		if a == nil {
+			a = &acc{roundStat: roundStat{Round: r.Round}, start: r.RecvUs, ids: map[string]bool{}}
+			by[r.Round] = a
+		}
+		if r.RecvUs < a.start {
+			a.start = r.RecvUs
+		}
+		if r.FwdUs > a.end {
+			a.end = r.FwdUs
+			a.Last = r.From + "->" + r.To
+		}
+		a.ids[r.From+"/"+r.MsgID] = true
+		a.Deliveries++
+		a.Bytes += r.Size
+	}
+	out := make([]roundStat, 0, len(by))
+	for _, a := range by {
+		a.StartMs, a.EndMs = ms(a.start-t0), ms(a.end-t0)
+		a.Msgs = len(a.ids)
+		if a.Msgs > 0 {
+			a.FanOut = float64(a.Deliveries) / float64(a.Msgs)
+		}
+		out = append(out, a.roundStat)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Round < out[j].Round })
+	return out
+}
+
+func parties(recs []tssnet.TraceRecord) []partyStat {
+	by := map[string]*partyStat{}
+	get := func(p string) *partyStat {
+		if by[p] == nil {
+			by[p] = &partyStat{Party: p}
+		}
+		return by[p]
+	}
+	sent := map[string]map[string]bool{}
+	for _, r := range recs {
+		if r.Type != "send" {
+			continue
+		}
+		s, d := get(r.From), get(r.To)
+		s.SentBytes += r.Size
+		d.RecvMsgs++
+		d.RecvBytes += r.Size
+		if sent[r.From] == nil {
+			sent[r.From] = map[string]bool{}
+		}
+		sent[r.From][r.MsgID] = true
+		s.SentMsgs++
+	}
+	out := make([]partyStat, 0, len(by))
+	for p, s := range by {
+		if n := len(sent[p]); n > 0 {
+			s.FanOut = float64(s.SentMsgs) / float64(n)
+			s.SentMsgs = n
+		}
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Party < out[j].Party })
+	return out
+}
+
+// criticalPath walks back from end: each message was sent after the last
+// input its sender received, which was sent after its own sender's last
+// input, and so on back to the gateway's cmd. Inputs of a round-r message
+// are messages of earlier rounds (or the cmd, for the first round).
+func criticalPath(recs []tssnet.TraceRecord, start, end *tssnet.TraceRecord) []step {
+	first := 0
+	for _, r := range recs {
+		if r.Type == "send" && r.Round > 0 && (first == 0 || r.Round < first) {
+			first = r.Round
+		}
+	}
+	input := func(cur *tssnet.TraceRecord) *tssnet.TraceRecord {
+		var best *tssnet.TraceRecord
+		for i := range recs {
+			r := &recs[i]
+			if r.To != cur.From || r.Queued || r.FwdUs == 0 || r.FwdUs > cur.RecvUs || r.FwdUs < start.RecvUs {
+				continue
+			}
+			if cur.Type == "send" && cur.Round > 0 {
+				if r.Type == "send" && (r.Round == 0 || r.Round >= cur.Round) {
+					continue
+				}
+				if r.Type != "send" && cur.Round != first {
+					continue
+				}
+			}
+			if best == nil || r.FwdUs > best.FwdUs {
+				best = r
+			}
+		}
+		return best
+	}
+
+	var path []step
+	for cur := end; cur != nil && len(path) <= len(recs); {
+		s := step{Type: cur.Type, Cmd: cur.Cmd, From: cur.From, To: cur.To, TransitMs: ms(cur.FwdUs - cur.RecvUs)}
+		if cur.Type == "send" {
+			s.Round = cur.Round
+		}
+		prev := input(cur)
+		if prev != nil {
+			s.ComputeMs = ms(cur.RecvUs - prev.FwdUs)
+		}
+		path = append(path, s)
+		if cur.Type == "cmd" && cur.From == start.From && startCmds[cur.Cmd] {
+			break
+		}
+		cur = prev
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+func report(js jobStat) {
+	fmt.Printf("job %s  %s  session=%s  gateway=%s", js.Job, js.Cmd, js.Session, js.Gateway)
+	if js.TMs > 0 {
+		fmt.Printf("  T=%.1fms", js.TMs)
+	} else {
+		fmt.Printf("  (no result in trace)")
+	}
+	if js.Queued > 0 {
+		fmt.Printf("  queued=%d", js.Queued)
+	}
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "round\tstart_ms\tend_ms\tdur_ms\tmsgs\tdeliveries\tbytes\tfan_out\tlast delivery\t")
+	for _, r := range js.Rounds {
+		name := fmt.Sprint(r.Round)
+		if r.Round == 0 {
+			name = "?"
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.1f\t%d\t%d\t%d\t%.1f\t%s\t\n", name, r.StartMs, r.EndMs, r.EndMs-r.StartMs, r.Msgs, r.Deliveries, r.Bytes, r.FanOut, r.Last)
+	}
+	tw.Flush()
+
+	if len(js.Path) > 0 {
+		fmt.Printf("critical path: compute %.1fms + transit %.1fms\n", js.ComputeMs, js.TransitMs)
+		tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "msg\tlink\tcompute_ms\ttransit_ms\t")
+		for _, s := range js.Path {
+			name := s.Cmd
+			if s.Type == "send" {
+				name = fmt.Sprintf("round %d", s.Round)
+			}
+			fmt.Fprintf(tw, "%s\t%s->%s\t%.1f\t%.1f\t\n", name, s.From, s.To, s.ComputeMs, s.TransitMs)
+		}
+		tw.Flush()
+		for i, l := range js.Links {
+			if i == 3 || l.TransitMs == 0 {
+				break
+			}
+			fmt.Printf("  link %s: %d hops, %.1fms = %.1f%% of T\n", l.Link, l.Hops, l.TransitMs, 100*l.Share)
+		}
+	}
+
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "party\tsent_msgs\tsent_bytes\trecv_msgs\trecv_bytes\tfan_out\t")
+	for _, p := range js.Parties {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f\t\n", p.Party, p.SentMsgs, p.SentBytes, p.RecvMsgs, p.RecvBytes, p.FanOut)
+	}
+	tw.Flush()
+	fmt.Println()
+}
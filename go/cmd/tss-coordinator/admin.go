@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+
+	"mp-htlc-lgp/experiment/internal/apiauth"
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+var (
+	adminListen   = flag.String("admin-listen", "", "address of the admin HTTP listener, e.g. 127.0.0.1:9001 (empty = off)")
+	adminAuthKeys = flag.String("admin-auth-keys", "", "API keys file for the admin listener (empty = unauthenticated, PUT/DELETE /wan from loopback only)")
+	adminTLSCert  = flag.String("admin-tls-cert", "", "TLS certificate file for the admin listener (enables HTTPS)")
+	adminTLSKey   = flag.String("admin-tls-key", "", "TLS private key file for the admin listener")
+)
+
+// serveAdmin runs the -admin-listen listener until the process exits.
+func serveAdmin(h *hub) {
+	var auth *apiauth.Authenticator
+	if *adminAuthKeys != "" {
+		a, err := apiauth.Load(*adminAuthKeys)
+		if err != nil {
+			log.Fatalf("admin auth keys: %v", err)
+		}
+		auth = a
+	} else {
+		log.Printf("WARNING: no -admin-auth-keys; PUT/DELETE /wan accepted from loopback clients only")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200); w.Write([]byte("ok")) })
+	mux.HandleFunc("/wan", auth.Require(apiauth.ScopeAny, h.handleWAN))
+	log.Printf("admin listening on %s (tls=%v auth=%v)", *adminListen, *adminTLSCert != "", auth != nil)
+	log.Fatal(apiauth.ListenAndServe(*adminListen, mux, *adminTLSCert, *adminTLSKey, ""))
+}
+
+// handleWAN shows or changes the WAN emulation: GET /wan returns the topology
+// and per-link stats; PUT /wan (body: topology) replaces it; DELETE /wan
+// turns it off. Changing it needs the admin scope, or a loopback client when
+// the listener has no -admin-auth-keys.
+func (h *hub) handleWAN(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodDelete:
+		if *adminAuthKeys == "" && !loopback(r) {
+			writeJSON(w, http.StatusForbidden, map[string]any{"ok": false, "err": "changing /wan without -admin-auth-keys is allowed from loopback only"})
+			return
+		}
+		if !apiauth.Allowed(r, apiauth.ScopeAdmin) {
+			writeJSON(w, http.StatusForbidden, map[string]any{"ok": false, "err": "admin scope required"})
+			return
+		}
+		var t *topology
+		if r.Method == http.MethodPut {
+			b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+			if err == nil {
+				t, err = parseTopology(b)
+			}
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": err.Error()})
+				return
+			}
+		}
+		h.wan.set(t)
+		log.Printf("wan emulation %s by %s", wanState(t), apiauth.Caller(r))
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"ok": false, "err": "GET, PUT or DELETE"})
+		return
+	}
+	t, links := h.wan.status()
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "enabled": t != nil, "topology": t, "links": links})
+}
+
+// loopback reports whether the request comes from this host.
+func loopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// wanState describes a topology for the log.
+func wanState(t *topology) string {
+	if t == nil {
+		return "off"
+	}
+	return "on (" + string(tssnet.MustJSON(t)) + ")"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+var (
+	registryPath = flag.String("registry", "", "JSON file of allowed parties, their identity keys and roles per session (empty = any party may join)")
+	tlsCert      = flag.String("tls-cert", "", "TLS certificate file (serves wss://)")
+	tlsKey       = flag.String("tls-key", "", "TLS private key file")
+)
+
+// authenticate runs the challenge after hello: the party signs a fresh nonce
+// with its identity key (tssnet.HelloProof). With a registry the party must be
+// listed for the session and the signature must match its key; without one
+// the answer is read but not checked.
+func (h *hub) authenticate(c *websocket.Conn, hello tssnet.WSMessage) error {
+	nonce := make([]byte, tssnet.NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	_ = c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := c.WriteMessage(websocket.TextMessage, tssnet.MustJSON(tssnet.WSMessage{Type: "challenge", Session: hello.Session, Party: hello.Party, Role: "coordinator", Nonce: hex.EncodeToString(nonce)})); err != nil {
+		return err
+	}
+	var auth tssnet.WSMessage
+	if err := c.ReadJSON(&auth); err != nil {
+		return err
+	}
+	if auth.Type != "auth" {
+		return fmt.Errorf("expected auth, got %q", auth.Type)
+	}
+	if h.registry == nil {
+		return nil
+	}
+	return h.registry.Verify(hello.Session, hello.Party, nonce, auth.Sig)
+}
+
+// reject closes c with a policy-violation frame carrying why.
+func reject(c *websocket.Conn, why error) {
+	_ = c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, why.Error()), time.Now().Add(time.Second))
+}
+
+// role is the role a party acts in: the registered one with a registry (a
+// hello claiming another is rejected), else what the hello claims.
+func (h *hub) role(hello tssnet.WSMessage) (string, error) {
+	if h.registry == nil {
+		if hello.Role == tssnet.RoleGateway {
+			return tssnet.RoleGateway, nil
+		}
+		return tssnet.RoleNode, nil
+	}
+	role := h.registry.Role(hello.Session, hello.Party)
+	if hello.Role != "" && hello.Role != role {
+		return "", fmt.Errorf("party %s is registered as %s, not %s", hello.Party, role, hello.Role)
+	}
+	return role, nil
+}
+
+// checkSender rejects a message that claims to come from another session or
+// party than the connection it arrived on, or a cmd the party's role may not
+// send. A party's tss-lib ids (From of a wire message) are its name or its
+// name with a "/" suffix (reshare's new-committee instance). Only the gateway
+// sends control cmds; a node sends results and aborts its peers' session.
+func checkSender(session, party, role string, m tssnet.WSMessage) error {
+	if m.Session != session {
+		return fmt.Errorf("session %q on a %q connection", m.Session, session)
+	}
+	// pings carry no party
+	if m.Party != party && (m.Type != "ping" || m.Party != "") {
+		return fmt.Errorf("party %q on a %q connection", m.Party, party)
+	}
+	switch m.Type {
+	case "send":
+		if m.From != party && !strings.HasPrefix(m.From, party+"/") {
+			return errors.New("from " + m.From)
+		}
+	case "cmd":
+		if role != tssnet.RoleGateway && m.Cmd != "abort" && !strings.HasSuffix(m.Cmd, "_result") {
+			return fmt.Errorf("cmd %s from a %s", m.Cmd, role)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+func TestCheckSender(t *testing.T) {
+	cmd := func(party, c string) tssnet.WSMessage {
+		return tssnet.WSMessage{Type: "cmd", Session: "cluster", Party: party, Cmd: c}
+	}
+	cases := []struct {
+		name    string
+		party   string
+		role    string
+		m       tssnet.WSMessage
+		wantErr string
+	}{
+		{"gateway sign", "G", tssnet.RoleGateway, cmd("G", "sign"), ""},
+		{"gateway cancel", "G", tssnet.RoleGateway, cmd("G", "cancel"), ""},
+		{"node result", "P1", tssnet.RoleNode, cmd("P1", "sign_result"), ""},
+		{"node abort", "P1", tssnet.RoleNode, cmd("P1", "abort"), ""},
+		{"node sign", "P1", tssnet.RoleNode, cmd("P1", "sign"), "cmd sign from a node"},
+		{"node reshare_commit", "P1", tssnet.RoleNode, cmd("P1", "reshare_commit"), "from a node"},
+		{"node cancel", "P1", tssnet.RoleNode, cmd("P1", "cancel"), "from a node"},
+		{"empty party", "P1", tssnet.RoleNode, cmd("", "sign_result"), "party"},
+		{"other party", "P1", tssnet.RoleNode, cmd("P2", "sign_result"), "party"},
+		{"other session", "P1", tssnet.RoleNode, tssnet.WSMessage{Type: "cmd", Session: "x", Party: "P1", Cmd: "sign_result"}, "session"},
+		{"ping without party", "P1", tssnet.RoleNode, tssnet.WSMessage{Type: "ping", Session: "cluster"}, ""},
+		{"send as itself", "P1", tssnet.RoleNode, tssnet.WSMessage{Type: "send", Session: "cluster", Party: "P1", From: "P1"}, ""},
+		{"send as new instance", "P1", tssnet.RoleNode, tssnet.WSMessage{Type: "send", Session: "cluster", Party: "P1", From: "P1/new"}, ""},
+		{"send as a peer", "P1", tssnet.RoleNode, tssnet.WSMessage{Type: "send", Session: "cluster", Party: "P1", From: "P2"}, "from P2"},
+		{"send as a prefix", "P1", tssnet.RoleNode, tssnet.WSMessage{Type: "send", Session: "cluster", Party: "P1", From: "P10"}, "from P10"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkSender("cluster", tc.party, tc.role, tc.m)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestHelloRole(t *testing.T) {
+	reg := &tssnet.Registry{Roles: map[string]map[string]string{"cluster": {"G": tssnet.RoleGateway, "P1": tssnet.RoleNode}}}
+	cases := []struct {
+		name     string
+		registry *tssnet.Registry
+		party    string
+		claimed  string
+		want     string
+		wantErr  bool
+	}{
+		{"registered gateway", reg, "G", tssnet.RoleGateway, tssnet.RoleGateway, false},
+		{"registered node", reg, "P1", tssnet.RoleNode, tssnet.RoleNode, false},
+		{"no role claimed", reg, "P1", "", tssnet.RoleNode, false},
+		{"node claims gateway", reg, "P1", tssnet.RoleGateway, "", true},
+		{"no registry, gateway", nil, "G", tssnet.RoleGateway, tssnet.RoleGateway, false},
+		{"no registry, anything else", nil, "P1", "admin", tssnet.RoleNode, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &hub{registry: tc.registry}
+			got, err := h.role(tssnet.WSMessage{Type: "hello", Session: "cluster", Party: tc.party, Role: tc.claimed})
+			if (err != nil) != tc.wantErr || got != tc.want {
+				t.Fatalf("role = %q, %v; want %q, err=%v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}
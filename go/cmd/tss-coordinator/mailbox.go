@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+)
+
+var (
+	mailboxTTL   = flag.Duration("mailbox-ttl", 60*time.Second, "keep messages for a disconnected party this long (0 = no mailbox)")
+	mailboxMax   = flag.Int("mailbox-max", 1024, "max queued messages per party")
+	mailboxBytes = flag.Int("mailbox-bytes", 16<<20, "max queued bytes per party")
+)
+
+// mailbox holds the messages addressed to a party while it is disconnected,
+// in the order the coordinator received them.
+type mailbox struct {
+	msgs    []queued
+	bytes   int
+	dropped int // over -mailbox-max / -mailbox-bytes
+}
+
+type queued struct {
+	b  []byte
+	at time.Time
+}
+
+// offlineLocked reports whether party's messages go to its mailbox: it left
+// less than -mailbox-ttl ago, or its connection broke and it has not been
+// removed yet. h.mu must be held.
+func (h *hub) offlineLocked(session, party string) bool {
+	if *mailboxTTL <= 0 {
+		return false
+	}
+	left, ok := h.left[session][party]
+	return ok && time.Since(left) <= *mailboxTTL
+}
+
+// queueLocked stores b for party; h.mu must be held (read or write).
+func (h *hub) queueLocked(session, party string, b []byte) {
+	h.mailMu.Lock()
+	defer h.mailMu.Unlock()
+	if h.mail[session] == nil {
+		h.mail[session] = map[string]*mailbox{}
+	}
+	mb := h.mail[session][party]
+	if mb == nil {
+		mb = &mailbox{}
+		h.mail[session][party] = mb
+	}
+	if len(mb.msgs) >= *mailboxMax || mb.bytes+len(b) > *mailboxBytes {
+		mb.dropped++
+		return
+	}
+	mb.msgs = append(mb.msgs, queued{b: b, at: time.Now()})
+	mb.bytes += len(b)
+}
+
+// takeMailLocked removes and returns party's unexpired messages; h.mu must
+// be held for writing.
+func (h *hub) takeMailLocked(session, party string) [][]byte {
+	delete(h.left[session], party)
+	h.mailMu.Lock()
+	mb := h.mail[session][party]
+	delete(h.mail[session], party)
+	h.mailMu.Unlock()
+	if mb == nil {
+		return nil
+	}
+	var out [][]byte
+	expired := 0
+	for _, q := range mb.msgs {
+		if time.Since(q.at) > *mailboxTTL {
+			expired++
+			continue
+		}
+		out = append(out, q.b)
+	}
+	log.Printf("mailbox session=%s party=%s: delivering %d queued messages (expired %d, dropped %d)", session, party, len(out), expired, mb.dropped)
+	return out
+}
+
+// expireMail drops the mailboxes of parties that stayed away longer than
+// -mailbox-ttl.
+func (h *hub) expireMail() {
+	for range time.Tick(*mailboxTTL / 2) {
+		h.mu.Lock()
+		for session, parties := range h.left {
+			for party, left := range parties {
+				if time.Since(left) <= *mailboxTTL {
+					continue
+				}
+				delete(parties, party)
+				h.mailMu.Lock()
+				if mb := h.mail[session][party]; mb != nil {
+					log.Printf("mailbox session=%s party=%s: offline for more than %s, dropped %d messages", session, party, *mailboxTTL, len(mb.msgs)+mb.dropped)
+					delete(h.mail[session], party)
+				}
+				h.mailMu.Unlock()
+			}
+			if len(parties) == 0 {
+				delete(h.left, session)
+				delete(h.mail, session)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
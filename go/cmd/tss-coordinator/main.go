@@ -2,81 +2,179 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 
+	"mp-htlc-lgp/experiment/internal/apiauth"
 	"mp-htlc-lgp/experiment/internal/tssnet"
 )
 
 type hub struct {
 	mu       sync.RWMutex
-	sessions map[string]map[string]*websocket.Conn // session -> party -> conn
-	roles    map[string]map[string]string          // session -> party -> role
-	lastCmd  map[string]*tssnet.WSMessage          // session -> last cmd (best-effort)
+	sessions map[string]map[string]*peer     // session -> party -> conn
+	roles    map[string]map[string]string    // session -> party -> role
+	lastCmd  map[string]*tssnet.WSMessage    // session -> last cmd (best-effort)
+	left     map[string]map[string]time.Time // session -> party -> when it disconnected (see mailbox.go)
+
+	mailMu sync.Mutex                     // mail is filled under h.mu.RLock
+	mail   map[string]map[string]*mailbox // session -> party -> queued while offline
+
+	registry *tssnet.Registry // allowed parties and their roles per session (see auth.go); nil = anyone
+	wan      *wan             // link emulation between parties (see wan.go)
+	trace    *tracer          // -trace; nil = off
+}
+
+// peer is one party's connection; gorilla allows one writer at a time.
+type peer struct {
+	c  *websocket.Conn
+	mu sync.Mutex
+}
+
+func (p *peer) write(b []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeLocked(b)
+}
+
+func (p *peer) writeLocked(b []byte) error {
+	_ = p.c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return p.c.WriteMessage(websocket.TextMessage, b)
 }
 
 func newHub() *hub {
 	return &hub{
-		sessions: map[string]map[string]*websocket.Conn{},
+		sessions: map[string]map[string]*peer{},
 		roles:    map[string]map[string]string{},
 		lastCmd:  map[string]*tssnet.WSMessage{},
+		left:     map[string]map[string]time.Time{},
+		mail:     map[string]map[string]*mailbox{},
+		wan:      newWAN(),
 	}
 }
 
+// replayCmds are the cmds worth replaying to a node that joins late.
+var replayCmds = map[string]bool{"keygen": true, "sign": true, "reshare": true}
+
 var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
 
-func (h *hub) add(session, party, role string, c *websocket.Conn) {
+// add registers p for party, replacing (and closing) a stale connection of
+// the same party, and returns the parties now connected, the messages queued
+// while the party was away and the cmd to replay. p is returned locked, so
+// nothing is written to it before the queued messages.
+func (h *hub) add(session, party, role string, p *peer) ([]string, [][]byte, *tssnet.WSMessage) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	p.mu.Lock()
 	if _, ok := h.sessions[session]; !ok {
-		h.sessions[session] = map[string]*websocket.Conn{}
+		h.sessions[session] = map[string]*peer{}
 		h.roles[session] = map[string]string{}
 	}
-	h.sessions[session][party] = c
+	if old := h.sessions[session][party]; old != nil {
+		old.c.Close()
+	}
+	h.sessions[session][party] = p
 	h.roles[session][party] = role
-	if cmd := h.lastCmd[session]; cmd != nil {
-		_ = c.WriteMessage(websocket.TextMessage, tssnet.MustJSON(*cmd))
+	parties := make([]string, 0, len(h.sessions[session]))
+	for name := range h.sessions[session] {
+		parties = append(parties, name)
 	}
+	sort.Strings(parties)
+	return parties, h.takeMailLocked(session, party), h.lastCmd[session]
 }
 
-func (h *hub) remove(session, party string) {
+// remove drops party unless it has reconnected on a newer connection.
+func (h *hub) remove(session, party string, p *peer) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if m, ok := h.sessions[session]; ok {
+		if m[party] != p {
+			return
+		}
 		delete(m, party)
+		if h.left[session] == nil {
+			h.left[session] = map[string]time.Time{}
+		}
+		h.left[session][party] = time.Now()
 		delete(h.roles[session], party)
 		if len(m) == 0 {
 			delete(h.sessions, session)
 			delete(h.roles, session)
 			delete(h.lastCmd, session)
+			h.wan.endSession(session)
 		}
 	}
 }
 
+// send writes msg to the listed parties ("*" or none = everyone); parties
+// that are briefly offline get it in their mailbox. Writes happen outside
+// h.mu, so one slow party does not block the hub. With WAN emulation on, a
+// write waits for the link from the sender to that party.
 func (h *hub) send(session string, to []string, msg tssnet.WSMessage) {
+	recv := time.Now()
+	b := tssnet.MustJSON(msg)
 	h.mu.RLock()
-	defer h.mu.RUnlock()
 	m := h.sessions[session]
-	if len(m) == 0 {
-		return
-	}
-	b := tssnet.MustJSON(msg)
 	if len(to) == 0 || (len(to) == 1 && to[0] == "*") {
-		for _, c := range m {
-			_ = c.WriteMessage(websocket.TextMessage, b)
+		to = to[:0:0]
+		for name := range m {
+			to = append(to, name)
+		}
+		for name := range h.left[session] {
+			to = append(to, name)
 		}
-		return
 	}
-	for _, p := range to {
-		if c, ok := m[p]; ok {
-			_ = c.WriteMessage(websocket.TextMessage, b)
+	dst := map[string]*peer{}
+	for _, name := range to {
+		if p, ok := m[name]; ok {
+			dst[name] = p
+		} else if h.offlineLocked(session, name) {
+			h.queueLocked(session, name, b)
+			if h.trace != nil {
+				r := traceRecord(msg, name, len(b), recv)
+				r.Queued = true
+				h.trace.record(r)
+			}
+		}
+	}
+	h.mu.RUnlock()
+	for name, p := range dst {
+		deliver := func() {
+			written := p.write(b) == nil || h.redeliver(session, name, p, b)
+			if h.trace != nil {
+				r := traceRecord(msg, name, len(b), recv)
+				if written {
+					r.FwdUs = time.Now().UnixMicro()
+				} else {
+					r.Queued = true
+				}
+				h.trace.record(r)
+			}
+		}
+		if !h.wan.schedule(session, msg.Party, name, msg.Type, len(b), deliver) {
+			deliver()
 		}
 	}
 }
 
+// redeliver handles a failed write to p: the party may already be back on a
+// new connection, else the message waits in its mailbox. It reports whether
+// the message was written to the new connection.
+func (h *hub) redeliver(session, party string, p *peer, b []byte) bool {
+	h.mu.RLock()
+	cur := h.sessions[session][party]
+	if cur == p && *mailboxTTL > 0 {
+		h.queueLocked(session, party, b)
+	}
+	h.mu.RUnlock()
+	return cur != nil && cur != p && cur.write(b) == nil
+}
+
 func (h *hub) handleWS(w http.ResponseWriter, r *http.Request) {
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -85,7 +183,12 @@ func (h *hub) handleWS(w http.ResponseWriter, r *http.Request) {
 	}
 	defer c.Close()
 
-	// hello
+	// hello; clients ping every tssnet.PingPeriod, so silence means a dead link
+	_ = c.SetReadDeadline(time.Now().Add(tssnet.PongWait))
+	c.SetPingHandler(func(data string) error {
+		_ = c.SetReadDeadline(time.Now().Add(tssnet.PongWait))
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
 	_, hb, err := c.ReadMessage()
 	if err != nil {
 		return
@@ -99,18 +202,47 @@ func (h *hub) handleWS(w http.ResponseWriter, r *http.Request) {
 	}
 	session := hello.Session
 	party := hello.Party
-	h.add(session, party, hello.Role, c)
-	log.Printf("join session=%s party=%s role=%s", session, party, hello.Role)
+	if err := h.authenticate(c, hello); err != nil {
+		log.Printf("reject session=%s party=%s role=%s from %s: %v", session, party, hello.Role, r.RemoteAddr, err)
+		reject(c, err)
+		return
+	}
+	role, err := h.role(hello)
+	if err != nil {
+		log.Printf("reject session=%s party=%s role=%s from %s: %v", session, party, hello.Role, r.RemoteAddr, err)
+		reject(c, err)
+		return
+	}
+	p := &peer{c: c}
+	parties, mail, replay := h.add(session, party, role, p)
+	if hello.Resume {
+		log.Printf("resume session=%s party=%s role=%s job=%s", session, party, hello.Role, hello.Job)
+	} else {
+		log.Printf("join session=%s party=%s role=%s", session, party, hello.Role)
+	}
 	defer func() {
-		h.remove(session, party)
+		h.remove(session, party, p)
 		log.Printf("leave session=%s party=%s", session, party)
 	}()
+	_ = p.writeLocked(tssnet.MustJSON(tssnet.WSMessage{Type: "welcome", Session: session, Party: party, Role: "coordinator", Resume: hello.Resume, Parties: parties}))
+	for _, b := range mail {
+		_ = p.writeLocked(b)
+	}
+	p.mu.Unlock()
+	if hello.Resume && hello.Job != "" {
+		// peers of the job send again what the party may have missed
+		h.send(session, []string{"*"}, tssnet.WSMessage{Type: "resume", Session: session, Party: party, Job: hello.Job, KeyID: hello.KeyID, Resume: true})
+	}
+	if replay != nil {
+		_ = p.write(tssnet.MustJSON(*replay))
+	}
 
 	for {
 		_, b, err := c.ReadMessage()
 		if err != nil {
 			return
 		}
+		_ = c.SetReadDeadline(time.Now().Add(tssnet.PongWait))
 		var m tssnet.WSMessage
 		if err := json.Unmarshal(b, &m); err != nil {
 			continue
@@ -118,28 +250,68 @@ func (h *hub) handleWS(w http.ResponseWriter, r *http.Request) {
 		if m.Session == "" {
 			m.Session = session
 		}
+		if err := checkSender(session, party, role, m); err != nil {
+			log.Printf("dropping %s/%s from session=%s party=%s: spoofed %v", m.Type, m.Cmd, session, party, err)
+			continue
+		}
 		switch m.Type {
 		case "send":
 			h.send(m.Session, m.To, m)
 		case "cmd":
-			// best-effort: remember last cmd for late joiners
-			h.mu.Lock()
-			cpy := m
-			h.lastCmd[m.Session] = &cpy
-			h.mu.Unlock()
+			// best-effort: remember the last session-starting cmd for late joiners
+			// (never results, queries or reshare commit/retire)
+			if replayCmds[m.Cmd] {
+				h.mu.Lock()
+				cpy := m
+				h.lastCmd[m.Session] = &cpy
+				h.mu.Unlock()
+			}
 			h.send(m.Session, m.Parties, m) // if Parties empty => nothing, nodes should join first
 			if len(m.Parties) == 0 {
 				h.send(m.Session, []string{"*"}, m)
 			}
 		case "ping":
-			_ = c.WriteMessage(websocket.TextMessage, tssnet.MustJSON(tssnet.WSMessage{Type: "pong", Session: session, Party: party}))
+			_ = p.write(tssnet.MustJSON(tssnet.WSMessage{Type: "pong", Session: session, Party: party, TsMs: tssnet.NowMs(), EchoMs: m.TsMs}))
 		}
 	}
 }
 
 func main() {
+	flag.Parse()
 	h := newHub()
+	if *registryPath != "" {
+		reg, err := tssnet.LoadRegistry(*registryPath)
+		if err != nil {
+			log.Fatalf("registry: %v", err)
+		}
+		h.registry = reg
+		log.Printf("party registry loaded from %s (%d sessions)", *registryPath, len(reg.Keys))
+	} else {
+		log.Printf("WARNING: no -registry; any client can join as any party")
+	}
+	if *wanFile != "" {
+		t, err := loadTopology(*wanFile)
+		if err != nil {
+			log.Fatalf("wan: %v", err)
+		}
+		h.wan.set(t)
+		log.Printf("wan emulation on from %s (%d regions, %d links)", *wanFile, len(t.Regions), len(t.Links))
+	}
+	if *traceFile != "" {
+		t, err := openTrace(*traceFile)
+		if err != nil {
+			log.Fatalf("trace: %v", err)
+		}
+		h.trace = t
+		log.Printf("tracing relayed messages to %s (payloads=%v)", *traceFile, *tracePayload)
+	}
+	if *mailboxTTL > 0 {
+		go h.expireMail()
+	}
+	if *adminListen != "" {
+		go serveAdmin(h)
+	}
 	http.HandleFunc("/ws", h.handleWS)
-	log.Printf("tss coordinator listening on :9000/ws")
-	log.Fatal(http.ListenAndServe(":9000", nil))
+	log.Printf("tss coordinator listening on :9000/ws (tls=%v mailbox ttl=%s max=%d bytes=%d)", *tlsCert != "", *mailboxTTL, *mailboxMax, *mailboxBytes)
+	log.Fatal(apiauth.ListenAndServe(":9000", nil, *tlsCert, *tlsKey, ""))
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+var (
+	traceFile    = flag.String("trace", "", "append every relayed message (one JSON line per recipient) to this file; analyse with tracestat (empty = off)")
+	tracePayload = flag.Bool("trace-payload", false, "also store the whole relayed message, payload included, so a node can replay the session (tss-node -replay)")
+)
+
+// traceBuffer bounds the records waiting for the writer; beyond it records
+// are dropped (and counted) rather than slowing the relay down.
+const traceBuffer = 8192
+
+// tracer writes tssnet.TraceRecord lines from one goroutine.
+type tracer struct {
+	ch      chan tssnet.TraceRecord
+	dropped atomic.Uint64
+}
+
+func openTrace(path string) (*tracer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	t := &tracer{ch: make(chan tssnet.TraceRecord, traceBuffer)}
+	go t.run(f)
+	return t, nil
+}
+
+func (t *tracer) run(f *os.File) {
+	enc := json.NewEncoder(f)
+	for r := range t.ch {
+		if err := enc.Encode(r); err != nil {
+			log.Printf("trace: %v", err)
+		}
+	}
+}
+
+// record queues r; a nil tracer records nothing.
+func (t *tracer) record(r tssnet.TraceRecord) {
+	if t == nil {
+		return
+	}
+	select {
+	case t.ch <- r:
+	default:
+		if t.dropped.Add(1)%1000 == 1 {
+			log.Printf("trace: writer behind, %d records dropped", t.dropped.Load())
+		}
+	}
+}
+
+// traceRecord is the record of msg relayed to party to, received at recv.
+func traceRecord(msg tssnet.WSMessage, to string, size int, recv time.Time) tssnet.TraceRecord {
+	r := tssnet.TraceRecord{
+		Session:   msg.Session,
+		Job:       msg.Job,
+		Type:      msg.Type,
+		Cmd:       msg.Cmd,
+		MsgID:     msg.MsgID,
+		Round:     msg.Round,
+		From:      msg.Party,
+		To:        to,
+		Bcast:     msg.Bcast,
+		Committee: msg.Committee,
+		Size:      size,
+		RecvUs:    recv.UnixMicro(),
+	}
+	if *tracePayload {
+		r.Msg = &msg
+	}
+	return r
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var wanFile = flag.String("wan", "", "JSON topology file: per-link delay/jitter/loss/reorder/duplicate/bandwidth applied to relayed messages (empty = off; see PUT /wan)")
+
+// Loss is modelled the way TCP under netem sees it: the websocket does not
+// lose messages, a lost segment is sent again after a retransmission timeout
+// that doubles on each further loss.
+const (
+	minRTO    = 200 * time.Millisecond // Linux TCP_RTO_MIN
+	maxLosses = 6
+)
+
+// linkProfile is what one direction of a link does to each message.
+type linkProfile struct {
+	DelayMs       float64 `json:"delay_ms,omitempty"`
+	JitterMs      float64 `json:"jitter_ms,omitempty"`      // uniform in ±jitter, never below 0
+	LossPct       float64 `json:"loss_pct,omitempty"`       // each loss adds one RTO
+	ReorderPct    float64 `json:"reorder_pct,omitempty"`    // send: delivered at once, overtaking the queue (like netem reorder)
+	DuplicatePct  float64 `json:"duplicate_pct,omitempty"`  // send: delivered twice (nodes drop the copy by msg_id)
+	BandwidthKbps float64 `json:"bandwidth_kbps,omitempty"` // serialisation per link direction (0 = unlimited)
+}
+
+// topoLink sets the profile between a and b, each a region or a party name;
+// a == b is traffic inside a region.
+type topoLink struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	linkProfile
+}
+
+// topology is the -wan file and the body of PUT /wan.
+type topology struct {
+	Seed    int64               `json:"seed,omitempty"` // 0 = random
+	Regions map[string][]string `json:"regions,omitempty"`
+	Default linkProfile         `json:"default"`
+	Links   []topoLink          `json:"links,omitempty"`
+
+	regionOf map[string]string
+	profiles map[[2]string]linkProfile
+}
+
+func loadTopology(path string) (*topology, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTopology(b)
+}
+
+func parseTopology(b []byte) (*topology, error) {
+	var t topology
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	t.regionOf = map[string]string{}
+	for region, parties := range t.Regions {
+		for _, p := range parties {
+			if prev, ok := t.regionOf[p]; ok {
+				return nil, fmt.Errorf("party %s is in regions %s and %s", p, prev, region)
+			}
+			t.regionOf[p] = region
+		}
+	}
+	if err := t.Default.check(); err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+	t.profiles = map[[2]string]linkProfile{}
+	for _, l := range t.Links {
+		if l.A == "" || l.B == "" {
+			return nil, errors.New("link needs a and b")
+		}
+		if err := l.check(); err != nil {
+			return nil, fmt.Errorf("link %s-%s: %w", l.A, l.B, err)
+		}
+		t.profiles[[2]string{l.A, l.B}] = l.linkProfile
+		t.profiles[[2]string{l.B, l.A}] = l.linkProfile
+	}
+	return &t, nil
+}
+
+func (p linkProfile) check() error {
+	for _, v := range []float64{p.DelayMs, p.JitterMs, p.BandwidthKbps} {
+		if v < 0 || math.IsNaN(v) {
+			return errors.New("negative delay, jitter or bandwidth")
+		}
+	}
+	for _, v := range []float64{p.LossPct, p.ReorderPct, p.DuplicatePct} {
+		if v < 0 || v > 100 || math.IsNaN(v) {
+			return errors.New("percentages must be in [0,100]")
+		}
+	}
+	return nil
+}
+
+// profile is the link from src to dst: party to party, then party to
+// region, region to party, region to region, else the default.
+func (t *topology) profile(src, dst string) linkProfile {
+	rs, rd := t.regionOf[src], t.regionOf[dst]
+	for _, k := range [][2]string{{src, dst}, {src, rd}, {rs, dst}, {rs, rd}} {
+		if p, ok := t.profiles[k]; ok {
+			return p
+		}
+	}
+	return t.Default
+}
+
+// linkStats counts what one link direction did since the topology was set.
+type linkStats struct {
+	Msgs       uint64  `json:"msgs"`
+	Bytes      uint64  `json:"bytes"`
+	Lost       uint64  `json:"lost"` // retransmissions added
+	Reordered  uint64  `json:"reordered"`
+	Duplicated uint64  `json:"duplicated"`
+	AddedMs    float64 `json:"added_ms_avg"` // mean time a message spent on the link
+	addedSum   time.Duration
+}
+
+func (s *linkStats) added(d time.Duration) {
+	s.addedSum += d
+	s.AddedMs = float64(s.addedSum.Microseconds()) / 1000 / float64(s.Msgs)
+}
+
+// link is one direction src -> dst of a session. Messages leave in order
+// (like one TCP stream) from a single goroutine, unless reordered. The
+// goroutine and the map entry go away once the queue is empty and emulation
+// is off or the session has ended.
+type link struct {
+	mu        sync.Mutex
+	q         []delivery
+	wake      chan struct{}
+	ended     bool      // the session had no party left (see endSession)
+	busyUntil time.Time // end of the last serialisation (bandwidth)
+	lastAt    time.Time // arrival of the last queued message
+	stats     linkStats
+}
+
+type delivery struct {
+	at time.Time
+	fn func()
+}
+
+// wan delays the messages the hub relays between parties.
+type wan struct {
+	mu    sync.Mutex
+	topo  *topology // nil = off
+	rng   *rand.Rand
+	links map[[3]string]*link // session, src, dst
+}
+
+func newWAN() *wan {
+	return &wan{links: map[[3]string]*link{}}
+}
+
+// set replaces the topology (nil turns emulation off) and resets the stats.
+// Messages already on a link are still delivered at their time; with
+// emulation off the links are freed once they drain.
+func (w *wan) set(t *topology) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.topo = t
+	if t != nil {
+		seed := t.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		w.rng = rand.New(rand.NewSource(seed))
+	}
+	for _, l := range w.links {
+		l.mu.Lock()
+		l.stats = linkStats{}
+		l.mu.Unlock()
+		if t == nil {
+			l.signal()
+		}
+	}
+}
+
+// endSession frees the links of session once they drain; the hub calls it
+// when the last party of the session leaves.
+func (w *wan) endSession(session string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for k, l := range w.links {
+		if k[0] != session {
+			continue
+		}
+		l.mu.Lock()
+		l.ended = true
+		l.mu.Unlock()
+		l.signal()
+	}
+}
+
+// schedule runs fn when a message of size bytes from src would reach dst,
+// and reports false (fn not called) when emulation is off.
+func (w *wan) schedule(session, src, dst, typ string, size int, fn func()) bool {
+	w.mu.Lock()
+	if w.topo == nil || src == "" {
+		w.mu.Unlock()
+		return false
+	}
+	p := w.topo.profile(src, dst)
+	key := [3]string{session, src, dst}
+	l := w.links[key]
+	if l == nil {
+		l = &link{wake: make(chan struct{}, 1)}
+		w.links[key] = l
+		go w.run(key, l)
+	}
+	// one draw per decision, under w.mu: a fixed seed gives the same draws
+	// for the same sequence of messages
+	delay := time.Duration((p.DelayMs + p.JitterMs*(2*w.rng.Float64()-1)) * float64(time.Millisecond))
+	if delay < 0 {
+		delay = 0
+	}
+	losses := 0
+	for losses < maxLosses && p.LossPct > 0 && w.rng.Float64()*100 < p.LossPct {
+		losses++
+	}
+	wire := typ == "send"
+	reorder := wire && p.ReorderPct > 0 && w.rng.Float64()*100 < p.ReorderPct
+	dup := wire && p.DuplicatePct > 0 && w.rng.Float64()*100 < p.DuplicatePct
+	// l.mu before w.mu is released: release cannot drop l in between
+	l.mu.Lock()
+	w.mu.Unlock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.ended = false // the session is back
+	l.stats.Msgs++
+	l.stats.Bytes += uint64(size)
+	l.stats.Lost += uint64(losses)
+	if reorder {
+		l.stats.Reordered++
+		l.stats.added(0)
+		go fn()
+		return true
+	}
+	depart := now
+	if p.BandwidthKbps > 0 {
+		if l.busyUntil.After(depart) {
+			depart = l.busyUntil
+		}
+		depart = depart.Add(time.Duration(float64(size*8) / (p.BandwidthKbps * 1000) * float64(time.Second)))
+		l.busyUntil = depart
+	}
+	at := depart.Add(delay)
+	rto := 2 * (time.Duration(p.DelayMs*float64(time.Millisecond)) + time.Duration(p.JitterMs*float64(time.Millisecond)))
+	if rto < minRTO {
+		rto = minRTO
+	}
+	for i := 0; i < losses; i++ {
+		at = at.Add(rto << i)
+	}
+	if at.Before(l.lastAt) {
+		at = l.lastAt // in order: no overtaking inside the stream
+	}
+	l.lastAt = at
+	l.q = append(l.q, delivery{at: at, fn: fn})
+	if dup {
+		l.stats.Duplicated++
+		l.q = append(l.q, delivery{at: at, fn: fn})
+	}
+	l.stats.added(at.Sub(now))
+	l.signal()
+	return true
+}
+
+// signal wakes the goroutine of l without blocking.
+func (l *link) signal() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the queued messages of l at their arrival time, in order,
+// and returns once release has dropped the link.
+func (w *wan) run(key [3]string, l *link) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		l.mu.Lock()
+		if len(l.q) == 0 {
+			l.mu.Unlock()
+			if w.release(key, l) {
+				return
+			}
+			<-l.wake
+			continue
+		}
+		d := l.q[0]
+		l.mu.Unlock()
+		if wait := time.Until(d.at); wait > 0 {
+			timer.Reset(wait)
+			<-timer.C
+		}
+		l.mu.Lock()
+		l.q = l.q[1:]
+		l.mu.Unlock()
+		d.fn()
+	}
+}
+
+// release drops l from the links if its queue is empty and emulation is off
+// or its session has ended. schedule creates a new link for the next message.
+func (w *wan) release(key [3]string, l *link) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.q) > 0 || (w.topo != nil && !l.ended) {
+		return false
+	}
+	if w.links[key] == l {
+		delete(w.links, key)
+	}
+	return true
+}
+
+// wanLinkStatus is one link direction in GET /wan.
+type wanLinkStatus struct {
+	Session string      `json:"session"`
+	From    string      `json:"from"`
+	To      string      `json:"to"`
+	Profile linkProfile `json:"profile"`
+	Queued  int         `json:"queued"`
+	linkStats
+}
+
+// status returns the topology (nil when off) and every link used so far.
+func (w *wan) status() (*topology, []wanLinkStatus) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]wanLinkStatus, 0, len(w.links))
+	for k, l := range w.links {
+		s := wanLinkStatus{Session: k[0], From: k[1], To: k[2]}
+		if w.topo != nil {
+			s.Profile = w.topo.profile(k[1], k[2])
+		}
+		l.mu.Lock()
+		s.Queued, s.linkStats = len(l.q), l.stats
+		l.mu.Unlock()
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Session != b.Session {
+			return a.Session < b.Session
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return w.topo, out
+}
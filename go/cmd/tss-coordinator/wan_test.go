@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTopology(t *testing.T) {
+	cases := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{"valid", `{"regions":{"us":["P1"],"eu":["P2"]},"default":{"delay_ms":5},"links":[{"a":"us","b":"eu","delay_ms":40,"loss_pct":100}]}`, ""},
+		{"empty", `{}`, ""},
+		{"not json", `{"regions":`, "unexpected end"},
+		{"party in two regions", `{"regions":{"us":["P1"],"eu":["P1"]}}`, "party P1 is in regions"},
+		{"negative default delay", `{"default":{"delay_ms":-1}}`, "default: negative"},
+		{"negative bandwidth", `{"links":[{"a":"us","b":"eu","bandwidth_kbps":-1}]}`, "link us-eu: negative"},
+		{"loss above 100", `{"links":[{"a":"us","b":"eu","loss_pct":101}]}`, "link us-eu: percentages"},
+		{"negative reorder", `{"default":{"reorder_pct":-0.5}}`, "percentages"},
+		{"link without b", `{"links":[{"a":"us","delay_ms":1}]}`, "link needs a and b"},
+		{"link without a", `{"links":[{"b":"us","delay_ms":1}]}`, "link needs a and b"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseTopology([]byte(tc.json))
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTopologyProfile(t *testing.T) {
+	topo, err := parseTopology([]byte(`{
+		"regions": {"r1": ["P1", "P2"], "r2": ["P3", "P4", "P5"]},
+		"default": {"delay_ms": 100},
+		"links": [
+			{"a": "r1", "b": "r1", "delay_ms": 1},
+			{"a": "r1", "b": "r2", "delay_ms": 40},
+			{"a": "r1", "b": "P3", "delay_ms": 25},
+			{"a": "P1", "b": "r2", "delay_ms": 30},
+			{"a": "r1", "b": "P4", "delay_ms": 20},
+			{"a": "P1", "b": "P4", "delay_ms": 10}
+		]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		src, dst string
+		want     float64
+	}{
+		{"party-party over everything", "P1", "P4", 10},
+		{"party-party, reverse direction", "P4", "P1", 10},
+		{"party-region over region-party", "P1", "P3", 30},
+		{"party-region over region-region", "P1", "P5", 30},
+		{"party-region of the reverse link", "P4", "P2", 20},
+		{"region-party over region-region", "P2", "P3", 25},
+		{"party-region of the reverse link over region-party", "P3", "P1", 25},
+		{"region-party of the reverse link", "P5", "P1", 30},
+		{"region-region", "P2", "P5", 40},
+		{"inside a region", "P2", "P1", 1},
+		{"region without a link to itself", "P3", "P4", 100},
+		{"party outside every region", "G", "P3", 100},
+		{"unknown destination", "P1", "P9", 100},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := topo.profile(tc.src, tc.dst).DelayMs; got != tc.want {
+				t.Fatalf("profile(%s, %s) delay = %v, want %v", tc.src, tc.dst, got, tc.want)
+			}
+		})
+	}
+}
+
+// waitLinks polls until the wan has n links.
+func waitLinks(t *testing.T, w *wan, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		w.mu.Lock()
+		got := len(w.links)
+		w.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d links, want %d", got, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWANReleasesLinks(t *testing.T) {
+	topo, err := parseTopology([]byte(`{"default":{"delay_ms":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWAN()
+	w.set(topo)
+	send := func() {
+		t.Helper()
+		done := make(chan struct{})
+		if !w.schedule("s", "P1", "P2", "send", 10, func() { close(done) }) {
+			t.Fatal("emulation on but message not scheduled")
+		}
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+
+	send()
+	time.Sleep(20 * time.Millisecond)
+	waitLinks(t, w, 1) // drained, but the session and emulation are still on
+
+	w.endSession("s")
+	waitLinks(t, w, 0)
+
+	send() // the session is back: a new link
+	waitLinks(t, w, 1)
+	w.set(nil)
+	waitLinks(t, w, 0)
+	if w.schedule("s", "P1", "P2", "send", 10, func() {}) {
+		t.Fatal("scheduled with emulation off")
+	}
+}
+
+func TestHandleWANLoopback(t *testing.T) {
+	cases := []struct {
+		method string
+		remote string
+		want   int
+	}{
+		{http.MethodGet, "10.0.0.5:4000", http.StatusOK},
+		{http.MethodDelete, "10.0.0.5:4000", http.StatusForbidden},
+		{http.MethodPut, "172.17.0.1:4000", http.StatusForbidden},
+		{http.MethodDelete, "127.0.0.1:4000", http.StatusOK},
+		{http.MethodDelete, "[::1]:4000", http.StatusOK},
+		{http.MethodPut, "127.0.0.1:4000", http.StatusOK},
+	}
+	h := newHub()
+	for _, tc := range cases {
+		t.Run(tc.method+" from "+tc.remote, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/wan", strings.NewReader(`{"default":{}}`))
+			r.RemoteAddr = tc.remote
+			rec := httptest.NewRecorder()
+			h.handleWAN(rec, r)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"mp-htlc-lgp/experiment/internal/eth"
+)
+
+// chainView is the read-only chain access used for on-chain checks.
+type chainView struct {
+	rpc     *ethclient.Client
+	chainID *big.Int
+}
+
+func dialChain(url string) (*chainView, error) {
+	c, err := eth.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	id, err := c.RPC.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &chainView{rpc: c.RPC, chainID: id}, nil
+}
+
+func (s *server) handleAuthorizeClaim(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.chain == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"ok": false, "err": "gateway started without -rpc"})
+		return
+	}
+	var req struct {
+		HTLC   string `json:"htlc"`
+		LockID string `json:"lockId"`
+		KeyID  string `json:"key_id"`
+		Path   string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad json: " + err.Error()})
+		return
+	}
+	if !common.IsHexAddress(req.HTLC) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad htlc address"})
+		return
+	}
+	htlc := common.HexToAddress(req.HTLC)
+	lid, err := decode32(req.LockID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad lockId: " + err.Error()})
+		return
+	}
+	var lockID [32]byte
+	copy(lockID[:], lid)
+	if s.policy != nil && s.policy.to != nil && !s.policy.to[htlc] {
+		writeJSON(w, http.StatusForbidden, map[string]any{"ok": false, "err": "policy violation", "violations": []string{"htlc " + htlc.Hex() + " not allowed"}})
+		return
+	}
+	keyID, err := requestKeyID(r, req.KeyID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	path, err := requestPath(r, req.Path)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	signer, err := s.keyAddress(keyID, path)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	lock, err := eth.CallLock(ctx, s.chain.rpc, htlc, lockID)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]any{"ok": false, "err": "read locks(): " + err.Error()})
+		return
+	}
+	head, err := s.chain.rpc.HeaderByNumber(ctx, nil)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]any{"ok": false, "err": "read head: " + err.Error()})
+		return
+	}
+	if err := eth.CheckClaimable(lock, head.Time, signer); err != nil {
+		log.Printf("authorizeClaim refused htlc=%s lockId=0x%x: %v", htlc.Hex(), lockID, err)
+		writeJSON(w, http.StatusForbidden, map[string]any{"ok": false, "err": err.Error(), "lockId": hex0x(lockID[:])})
+		return
+	}
+
+	digest := eth.ClaimDigest(s.chain.chainID, htlc, lockID, lock.Receiver)
+	log.Printf("authorizeClaim key=%s htlc=%s lockId=0x%x receiver=%s block=%d digest=%s", keyID, htlc.Hex(), lockID, lock.Receiver.Hex(), head.Number, digest.Hex())
+	sig65, sig, from, err := s.signEthereum(r, keyID, path, digest.Bytes())
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	out := append([]byte{}, sig65...)
+	out[64] += 27
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":        true,
+		"key_id":    keyID,
+		"path":      sig.Path,
+		"signature": hex0x(out),
+		"digest":    digest.Hex(),
+		"address":   from.Hex(),
+		"receiver":  lock.Receiver.Hex(),
+		"chain_id":  s.chain.chainID.String(),
+		"party":     sig.Party,
+		"t_sign_ms": sig.Took.Milliseconds(),
+		"presig":    sig.Presig,
+		"telemetry": sig.Telemetry,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"mp-htlc-lgp/experiment/internal/hdkey"
+)
+
+// requestPath picks the derivation path for a request: body field, then
+// ?path=. Empty means the key itself.
+func requestPath(r *http.Request, fromBody string) (hdkey.Path, error) {
+	s := strings.TrimSpace(fromBody)
+	if s == "" {
+		s = r.URL.Query().Get("path")
+	}
+	p, err := hdkey.ParsePath(s)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "bad path: "+err.Error(), "path", s)
+	}
+	return p, nil
+}
+
+// keyPub returns the uncompressed public key and address of keyID's child at
+// path (the key itself for an empty path). Children are derived here from the
+// stored public key; the nodes derive the same delta for their shares.
+func (s *server) keyPub(keyID string, path hdkey.Path) ([]byte, common.Address, error) {
+	k, ok := s.getKey(keyID)
+	if !ok || !common.IsHexAddress(k.Address) {
+		return nil, common.Address{}, newAPIError(http.StatusNotFound, "unknown key; run /keygen first", "key_id", keyID)
+	}
+	raw, err := hexutil.Decode(k.PubKey)
+	if err != nil {
+		return nil, common.Address{}, newAPIError(http.StatusInternalServerError, "bad stored pubkey: "+err.Error(), "key_id", keyID)
+	}
+	if len(path) == 0 {
+		return raw, common.HexToAddress(k.Address), nil
+	}
+	pub, err := crypto.UnmarshalPubkey(raw)
+	if err != nil {
+		return nil, common.Address{}, newAPIError(http.StatusInternalServerError, "bad stored pubkey: "+err.Error(), "key_id", keyID)
+	}
+	_, child, err := hdkey.Derive(pub, path)
+	if err != nil {
+		return nil, common.Address{}, newAPIError(http.StatusBadRequest, err.Error(), "key_id", keyID, "path", path.String())
+	}
+	child.Curve = crypto.S256()
+	return crypto.FromECDSAPub(child), crypto.PubkeyToAddress(*child), nil
+}
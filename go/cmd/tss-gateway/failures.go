@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// partyFailures counts how often a party was involved in a failed session
+// since the gateway started.
+type partyFailures struct {
+	Culprit int        `json:"culprit"` // named as culprit by tss-lib (identifiable abort)
+	Failed  int        `json:"failed"`  // reported a failure without culprits (e.g. missing share)
+	Timeout int        `json:"timeout"` // did not answer before the deadline
+	LastCmd string     `json:"last_cmd,omitempty"`
+	LastErr string     `json:"last_err,omitempty"`
+	LastAt  *time.Time `json:"last_at,omitempty"`
+}
+
+func (s *server) countFailure(party, cmd, errMsg string, bump func(*partyFailures)) {
+	s.failMu.Lock()
+	defer s.failMu.Unlock()
+	f := s.failures[party]
+	if f == nil {
+		f = &partyFailures{}
+		s.failures[party] = f
+	}
+	bump(f)
+	now := time.Now().UTC()
+	f.LastCmd, f.LastErr, f.LastAt = cmd, errMsg, &now
+}
+
+// sessionFailed records a failed *_result (the first one fails the request)
+// and returns the fields that identify who broke the session: the reporting
+// party, the tss-lib round and the culprits, if any.
+func (s *server) sessionFailed(cmd, keyID string, m tssnet.WSMessage) []any {
+	log.Printf("%s key %s failed: reported by %s, round %d, culprits %v: %s", cmd, keyID, m.Party, m.Round, m.Culprits, m.Err)
+	if len(m.Culprits) > 0 {
+		for _, p := range m.Culprits {
+			s.countFailure(p, cmd, m.Err, func(f *partyFailures) { f.Culprit++ })
+		}
+	} else {
+		s.countFailure(m.Party, cmd, m.Err, func(f *partyFailures) { f.Failed++ })
+	}
+	kv := []any{"party", m.Party}
+	if m.Round > 0 {
+		kv = append(kv, "round", m.Round)
+	}
+	if len(m.Culprits) > 0 {
+		kv = append(kv, "culprits", m.Culprits)
+	}
+	return kv
+}
+
+// sessionTimedOut records the parties that never answered.
+func (s *server) sessionTimedOut(cmd, keyID string, missing []string) {
+	log.Printf("%s key %s timed out waiting for %v", cmd, keyID, missing)
+	for _, p := range missing {
+		s.countFailure(p, cmd, "timeout", func(f *partyFailures) { f.Timeout++ })
+	}
+}
+
+// handleParties reports the failure counters of every known party.
+func (s *server) handleParties(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.failMu.Lock()
+	out := map[string]partyFailures{}
+	for _, p := range partiesFromFlag() {
+		out[p] = partyFailures{}
+	}
+	for p, f := range s.failures {
+		out[p] = *f
+	}
+	s.failMu.Unlock()
+	names := make([]string, 0, len(out))
+	for p := range out {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "parties": names, "failures": out})
+}
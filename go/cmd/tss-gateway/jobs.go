@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/apiauth"
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// cancelWait bounds how long a stopped job waits for its parties to confirm
+// they tore the session down.
+const cancelWait = 5 * time.Second
+
+// statusClientClosed answers a request whose client went away (never seen).
+const statusClientClosed = 499
+
+var errJobCancelled = errors.New("cancelled via DELETE /jobs")
+
+// job is one running TSS session (keygen, sign or reshare). It ends when all
+// parties answered, at its deadline, on DELETE /jobs/{id} or when the HTTP
+// client goes away; nodes get the deadline with the cmd and a "cancel" cmd
+// for the other cases.
+type job struct {
+	ID       string    `json:"id"`
+	Cmd      string    `json:"cmd"`
+	KeyID    string    `json:"key_id"`
+	Parties  []string  `json:"parties"`
+	Caller   string    `json:"caller,omitempty"`
+	Started  time.Time `json:"started"`
+	Deadline time.Time `json:"deadline"`
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   context.CancelFunc
+	ended  chan struct{}
+}
+
+func (s *server) startJob(r *http.Request, cmd, keyID string, parties []string, timeout time.Duration) *job {
+	return s.startJobCtx(r.Context(), apiauth.Caller(r), cmd, keyID, parties, timeout)
+}
+
+// startJobCtx starts a job that is not tied to an HTTP request (e.g. the
+// presignature filler); it ends with ctx.
+func (s *server) startJobCtx(parent context.Context, caller, cmd, keyID string, parties []string, timeout time.Duration) *job {
+	now := time.Now().UTC()
+	j := &job{ID: newJobID(), Cmd: cmd, KeyID: keyID, Parties: parties, Caller: caller, Started: now, Deadline: now.Add(timeout), ended: make(chan struct{})}
+	ctx, stop := context.WithDeadline(parent, j.Deadline)
+	j.ctx, j.cancel = context.WithCancelCause(ctx)
+	j.stop = stop
+	s.jobsMu.Lock()
+	s.jobs[j.ID] = j
+	s.jobsMu.Unlock()
+	return j
+}
+
+// endJob removes j from the running jobs; it can no longer be cancelled.
+func (s *server) endJob(j *job) {
+	s.jobsMu.Lock()
+	if _, ok := s.jobs[j.ID]; ok {
+		delete(s.jobs, j.ID)
+		close(j.ended)
+	}
+	s.jobsMu.Unlock()
+	j.cancel(nil)
+	j.stop()
+}
+
+// newJobID names one TSS session; nodes echo it in their results so a late
+// answer to an earlier (failed or timed out) session is never taken for the
+// current one.
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// msg is the cmd that starts j on the nodes.
+func (j *job) msg() tssnet.WSMessage {
+	return tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *gatewayParty, Cmd: j.Cmd, Job: j.ID, KeyID: j.KeyID, Parties: j.Parties, Deadline: j.Deadline.UnixMilli()}
+}
+
+// stopJob tears j down on the parties that have not answered yet, after it
+// was cancelled, timed out or lost its HTTP client, and waits up to
+// cancelWait for them to report. answered holds the results already in.
+func (s *server) stopJob(j *job, answered map[string]tssnet.WSMessage) error {
+	cause := context.Cause(j.ctx)
+	var pending []string
+	for _, p := range j.Parties {
+		if _, ok := answered[p]; !ok {
+			pending = append(pending, p)
+		}
+	}
+	if errors.Is(cause, context.DeadlineExceeded) {
+		s.sessionTimedOut(j.Cmd, j.KeyID, pending)
+	}
+	stopped, noAnswer := s.cancelParties(j, pending)
+	log.Printf("%s job %s (key %s) stopped: %v; stopped=%v no_answer=%v", j.Cmd, j.ID, j.KeyID, cause, stopped, noAnswer)
+
+	kv := []any{"job", j.ID, "stopped", stopped, "no_answer", noAnswer}
+	switch {
+	case errors.Is(cause, errJobCancelled):
+		return newAPIError(http.StatusConflict, "cancelled", kv...)
+	case errors.Is(cause, context.DeadlineExceeded):
+		return newAPIError(http.StatusGatewayTimeout, "timeout", kv...)
+	default:
+		return newAPIError(statusClientClosed, "client went away", kv...)
+	}
+}
+
+// cancelParties sends "cancel" for j to pending and waits up to cancelWait
+// for their *_result.
+func (s *server) cancelParties(j *job, pending []string) (stopped, noAnswer []string) {
+	_ = s.sendCmd(tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *gatewayParty, Cmd: "cancel", Job: j.ID, KeyID: j.KeyID, Parties: pending})
+
+	got := map[string]bool{}
+	deadline := time.After(cancelWait)
+wait:
+	for len(got) < len(pending) {
+		select {
+		case m := <-s.in:
+			if m.Cmd == j.Cmd+"_result" && m.Job == j.ID && containsStr(pending, m.Party) && !got[m.Party] {
+				got[m.Party] = true
+				stopped = append(stopped, m.Party)
+			}
+		case <-deadline:
+			break wait
+		}
+	}
+	for _, p := range pending {
+		if !got[p] {
+			noAnswer = append(noAnswer, p)
+		}
+	}
+	return stopped, noAnswer
+}
+
+// handleJobs serves GET /jobs, GET /jobs/{id} and DELETE /jobs/{id}. Only
+// the caller that started a job or an admin may cancel it.
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+	s.jobsMu.Lock()
+	j := s.jobs[id]
+	list := make([]*job, 0, len(s.jobs))
+	for _, x := range s.jobs {
+		list = append(list, x)
+	}
+	s.jobsMu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		sort.Slice(list, func(a, b int) bool { return list[a].Started.Before(list[b].Started) })
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "jobs": list})
+	case id == "" || (r.Method != http.MethodGet && r.Method != http.MethodDelete):
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case j == nil:
+		writeJSON(w, http.StatusNotFound, map[string]any{"ok": false, "err": "no such running job", "job": id})
+	case r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "job": j})
+	case j.Caller != apiauth.Caller(r) && !apiauth.Allowed(r, apiauth.ScopeAdmin):
+		writeJSON(w, http.StatusForbidden, map[string]any{"ok": false, "err": "job belongs to another caller", "job": id})
+	default:
+		log.Printf("%s job %s (key %s): cancel requested by %q", j.Cmd, j.ID, j.KeyID, apiauth.Caller(r))
+		j.cancel(errJobCancelled)
+		select {
+		case <-j.ended:
+		case <-time.After(cancelWait + time.Second):
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "job": j.ID, "cmd": j.Cmd, "key_id": j.KeyID})
+	}
+}
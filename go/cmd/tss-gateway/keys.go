@@ -0,0 +1,589 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"mp-htlc-lgp/experiment/internal/hdkey"
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// keyInfo is what the gateway knows about one cluster key.
+type keyInfo struct {
+	KeyID     string    `json:"key_id"`
+	Address   string    `json:"address"`
+	PubKey    string    `json:"pubkey"`
+	Parties   []string  `json:"parties"`
+	Threshold int       `json:"threshold"`
+	PartyKeys []string  `json:"party_keys,omitempty"` // tss-lib keys after a reshare; empty = 1..n
+	CreatedAt time.Time `json:"created_at"`
+
+	ConfirmedBy  []string `json:"confirmed_by,omitempty"`  // nodes that reported this share at startup
+	StaleHolders []string `json:"stale_holders,omitempty"` // nodes that still hold a share of an older committee
+}
+
+func (s *server) getKey(keyID string) (keyInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[keyID]
+	return k, ok
+}
+
+func (s *server) putKey(k keyInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.KeyID] = k
+	delete(s.keyConflicts, k.KeyID)
+	s.saveKeysLocked()
+}
+
+// requestKeyID picks the key for a request: body field, then ?key_id=, then
+// tssnet.DefaultKeyID.
+func requestKeyID(r *http.Request, fromBody string) (string, error) {
+	id := strings.TrimSpace(fromBody)
+	if id == "" {
+		id = strings.TrimSpace(r.URL.Query().Get("key_id"))
+	}
+	if id == "" {
+		id = tssnet.DefaultKeyID
+	}
+	if err := tssnet.ValidKeyID(id); err != nil {
+		return "", newAPIError(http.StatusBadRequest, err.Error())
+	}
+	return id, nil
+}
+
+// keyAddress returns the address of a key, as reported by keygen, or of its
+// child at path.
+func (s *server) keyAddress(keyID string, path hdkey.Path) (common.Address, error) {
+	_, addr, err := s.keyPub(keyID, path)
+	return addr, err
+}
+
+// signingSet returns the parties and threshold a key was generated with,
+// falling back to the command-line defaults for keys the gateway has not seen.
+func (s *server) signingSet(keyID string) ([]string, int) {
+	if k, ok := s.getKey(keyID); ok && len(k.Parties) > 0 {
+		return k.Parties, k.Threshold
+	}
+	return partiesFromFlag(), *defaultThreshold
+}
+
+func (s *server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.RLock()
+	out := make([]keyInfo, 0, len(s.keys))
+	for _, k := range s.keys {
+		out = append(out, k)
+	}
+	conflicts := make(map[string]string, len(s.keyConflicts))
+	for id, c := range s.keyConflicts {
+		conflicts[id] = c
+	}
+	reshares := make(map[string]pendingReshare, len(s.reshares))
+	for id, p := range s.reshares {
+		reshares[id] = p
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].KeyID < out[j].KeyID })
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "keys": out, "conflicts": conflicts, "reshares": reshares})
+}
+
+func (s *server) handleAddress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	keyID, err := requestKeyID(r, "")
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	path, err := requestPath(r, "")
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if len(path) == 0 {
+		k, ok := s.getKey(keyID)
+		if !ok {
+			writeErr(w, newAPIError(http.StatusNotFound, "unknown key; run /keygen first", "key_id", keyID))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"key_id": keyID, "address": k.Address, "pubkey": k.PubKey})
+		return
+	}
+	pub, addr, err := s.keyPub(keyID, path)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"key_id": keyID, "path": path.String(), "address": addr.Hex(), "pubkey": hex0x(pub)})
+}
+
+func (s *server) handleKeygen(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// body is optional: an empty POST generates the default key with flag parties/threshold
+	var req struct {
+		KeyID     string   `json:"key_id"`
+		Parties   []string `json:"parties"`
+		Threshold int      `json:"threshold"`
+		PreParams string   `json:"preparams"` // "warm" | "cold" | "" (warm where available)
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad json: " + err.Error()})
+			return
+		}
+	}
+	keyID, err := requestKeyID(r, req.KeyID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	parties := req.Parties
+	if len(parties) == 0 {
+		parties = partiesFromFlag()
+	}
+	if err := checkParties(parties); err != nil {
+		writeErr(w, err)
+		return
+	}
+	thr := req.Threshold
+	if thr == 0 {
+		thr = *defaultThreshold
+	}
+	if thr < 1 || thr >= len(parties) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "threshold must satisfy 1 <= t < n", "threshold": thr, "n": len(parties)})
+		return
+	}
+
+	switch req.PreParams {
+	case "", tssnet.PreParamsWarm, tssnet.PreParamsCold:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": `preparams must be "warm" or "cold"`})
+		return
+	}
+
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	s.mu.RLock()
+	_, conflicted := s.keyConflicts[keyID]
+	s.mu.RUnlock()
+	if _, exists := s.getKey(keyID); exists || conflicted {
+		writeJSON(w, http.StatusConflict, map[string]any{"ok": false, "err": "key already exists", "key_id": keyID})
+		return
+	}
+
+	// a warm keygen must not start unless every party can take from its pool:
+	// one cold party would otherwise fail and leave the rest waiting
+	if req.PreParams == tssnet.PreParamsWarm {
+		if notReady := s.poolsNotReady(parties); len(notReady) > 0 {
+			writeJSON(w, http.StatusConflict, map[string]any{"ok": false, "err": "pre-params not ready", "parties": notReady})
+			return
+		}
+	}
+
+	j := s.startJob(r, "keygen", keyID, parties, *keygenTimeout)
+	defer s.endJob(j)
+	start := time.Now()
+	cmd := j.msg()
+	cmd.Threshold, cmd.PreParams = thr, req.PreParams
+	_ = s.sendCmd(cmd)
+
+	byParty := map[string]tssnet.WSMessage{}
+	for len(byParty) < len(parties) {
+		select {
+		case m := <-s.in:
+			if m.Cmd != "keygen_result" || m.Job != j.ID || !containsStr(parties, m.Party) {
+				continue
+			}
+			// fail fast: the other parties have been told to abort too
+			if !m.Ok {
+				writeErr(w, newAPIError(http.StatusBadGateway, "party failed", append(s.sessionFailed("keygen", keyID, m), "detail", m.Err)...))
+				return
+			}
+			byParty[m.Party] = m
+		case <-j.ctx.Done():
+			writeErr(w, s.stopJob(j, byParty))
+			return
+		}
+	}
+
+	// sanity check: all ok, same addr
+	var addr, pub string
+	modes := map[string]string{}
+	for _, p := range parties {
+		m := byParty[p]
+		modes[p] = m.PreParams
+		if addr == "" {
+			addr, pub = m.AddrHex, m.PubKeyHex
+			continue
+		}
+		if m.AddrHex != "" && addr != "" && strings.ToLower(m.AddrHex) != strings.ToLower(addr) {
+			w.WriteHeader(http.StatusBadGateway)
+			_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": "address mismatch", "a": addr, "b": m.AddrHex, "party": p})
+			return
+		}
+	}
+
+	k := keyInfo{KeyID: keyID, Address: addr, PubKey: pub, Parties: parties, Threshold: thr, CreatedAt: time.Now().UTC()}
+	s.putKey(k)
+	// t_keygen_ms is not comparable across modes: say which one ran
+	log.Printf("keygen key=%s t_keygen_ms=%d preparams=%s (requested %q) by_party=%v",
+		keyID, time.Since(start).Milliseconds(), keygenMode(modes), req.PreParams, modes)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":                 true,
+		"key_id":             keyID,
+		"address":            addr,
+		"pubkey":             pub,
+		"threshold":          thr,
+		"parties":            parties,
+		"created_at":         k.CreatedAt,
+		"preparams":          keygenMode(modes),
+		"preparams_by_party": modes,
+		"t_keygen_ms":        time.Since(start).Milliseconds(),
+		"telemetry":          s.telemetryReport(j.Started, byParty),
+	})
+}
+
+// keysFile is the gateway's persisted key metadata (-keys-file).
+type keysFile struct {
+	Keys     []keyInfo        `json:"keys"`
+	Reshares []pendingReshare `json:"reshares,omitempty"`
+}
+
+func loadKeysFile(path string) (map[string]keyInfo, map[string]pendingReshare, error) {
+	out, reshares := map[string]keyInfo{}, map[string]pendingReshare{}
+	if path == "" {
+		return out, reshares, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, reshares, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var f keysFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, k := range f.Keys {
+		out[k.KeyID] = k
+	}
+	for _, p := range f.Reshares {
+		reshares[p.KeyID] = p
+	}
+	return out, reshares, nil
+}
+
+// saveKeysLocked writes s.keys and s.reshares to -keys-file atomically; s.mu must be held.
+func (s *server) saveKeysLocked() {
+	if *keysFilePath == "" {
+		return
+	}
+	f := keysFile{Keys: make([]keyInfo, 0, len(s.keys))}
+	for _, k := range s.keys {
+		f.Keys = append(f.Keys, k)
+	}
+	sort.Slice(f.Keys, func(i, j int) bool { return f.Keys[i].KeyID < f.Keys[j].KeyID })
+	for _, p := range s.reshares {
+		f.Reshares = append(f.Reshares, p)
+	}
+	sort.Slice(f.Reshares, func(i, j int) bool { return f.Reshares[i].KeyID < f.Reshares[j].KeyID })
+	b, _ := json.MarshalIndent(f, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(*keysFilePath), 0o755); err != nil {
+		log.Printf("persist keys: %v", err)
+		return
+	}
+	tmp := *keysFilePath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		log.Printf("persist keys: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, *keysFilePath); err != nil {
+		log.Printf("persist keys: %v", err)
+	}
+}
+
+// discoverKeys asks every node which shares it stores and rebuilds key
+// metadata from the answers. A key is served only if all reporting nodes
+// agree on public key, parties and threshold (and with the persisted entry).
+// It waits for every party of -parties and of any committee it knows of or
+// hears about, or until wait runs out.
+func (s *server) discoverKeys(wait time.Duration) {
+	_ = s.sendCmd(tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *gatewayParty, Cmd: "keys", Parties: []string{"*"}})
+
+	reports := map[string]map[string]tssnet.KeyReport{} // key_id -> party -> report
+	replied := map[string]bool{}
+	// expected grows with every committee the gateway or a reply names, so a
+	// party outside -parties (added by a reshare) is still waited for
+	expected := map[string]bool{}
+	addParties := func(ps []string) {
+		for _, p := range ps {
+			expected[p] = true
+		}
+	}
+	addParties(partiesFromFlag())
+	s.mu.RLock()
+	for _, k := range s.keys {
+		addParties(k.Parties)
+	}
+	for _, p := range s.reshares {
+		addParties(p.Spec.OldParties)
+		addParties(p.Spec.NewParties)
+	}
+	s.mu.RUnlock()
+	allReplied := func() bool {
+		for p := range expected {
+			if !replied[p] {
+				return false
+			}
+		}
+		return true
+	}
+
+	deadline := time.After(wait)
+collect:
+	for !allReplied() {
+		select {
+		case m := <-s.keysIn:
+			if !m.Ok {
+				log.Printf("discover keys: party %s: %s", m.Party, m.Err)
+				continue
+			}
+			var rs []tssnet.KeyReport
+			if err := json.Unmarshal(m.Payload, &rs); err != nil {
+				log.Printf("discover keys: party %s: bad payload: %v", m.Party, err)
+				continue
+			}
+			replied[m.Party] = true
+			for _, r := range rs {
+				addParties(r.Parties)
+				if r.Reshare != nil {
+					addParties(r.Reshare.Spec.OldParties)
+					addParties(r.Reshare.Spec.NewParties)
+				}
+				if reports[r.KeyID] == nil {
+					reports[r.KeyID] = map[string]tssnet.KeyReport{}
+				}
+				reports[r.KeyID][m.Party] = r
+			}
+		case <-deadline:
+			break collect
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncResharesLocked(reports, allReplied())
+	for keyID, byParty := range reports {
+		// a new-only party that has not committed reports only reshare state
+		for p, r := range byParty {
+			if r.PubKeyHex == "" {
+				delete(byParty, p)
+			}
+		}
+		if len(byParty) == 0 {
+			continue
+		}
+		k, conflict := mergeReports(keyID, byParty)
+		if prev, ok := s.keys[keyID]; ok && conflict == "" && !strings.EqualFold(prev.Address, k.Address) {
+			conflict = fmt.Sprintf("nodes report %s but persisted metadata says %s", k.Address, prev.Address)
+		}
+		if conflict != "" {
+			log.Printf("discover keys: key %s NOT served: %s", keyID, conflict)
+			delete(s.keys, keyID)
+			s.keyConflicts[keyID] = conflict
+			continue
+		}
+		if missing := missingParties(k.Parties, byParty); len(missing) > 0 {
+			log.Printf("discover keys: key %s: no answer from %v", keyID, missing)
+		}
+		delete(s.keyConflicts, keyID)
+		s.keys[keyID] = k
+		log.Printf("discover keys: key %s address=%s confirmed by %v", keyID, k.Address, k.ConfirmedBy)
+	}
+	s.saveKeysLocked()
+	log.Printf("discover keys: %d/%d nodes replied, %d keys served", len(replied), len(expected), len(s.keys))
+}
+
+// syncResharesLocked reconciles the pending reshares with the reshare state
+// the nodes report: a reshare the gateway lost (no -keys-file) is picked up
+// again, and one no node knows any more is dropped if every node replied.
+// s.mu must be held.
+func (s *server) syncResharesLocked(reports map[string]map[string]tssnet.KeyReport, allReplied bool) {
+	for keyID, byParty := range reports {
+		if _, ok := s.reshares[keyID]; ok {
+			continue
+		}
+		var p *pendingReshare
+		for party, r := range byParty {
+			st := r.Reshare
+			if st == nil {
+				continue
+			}
+			if p == nil {
+				p = &pendingReshare{KeyID: keyID, Job: st.Job, Phase: reshareCleanup, Spec: st.Spec}
+				p.Old = keyInfo{KeyID: keyID, PubKey: st.Spec.PubKeyHex, Parties: st.Spec.OldParties, Threshold: st.Spec.OldThreshold, PartyKeys: st.Spec.OldKeys}
+			}
+			if st.Job != p.Job {
+				log.Printf("discover keys: key %s: nodes report different reshares (%s, %s)", keyID, p.Job, st.Job)
+				continue
+			}
+			if r.AddrHex != "" {
+				p.Old.Address = r.AddrHex
+			}
+			if st.Phase == tssnet.ResharePhaseStaged && containsStr(st.Spec.NewParties, party) {
+				p.Phase = reshareCommit
+			}
+		}
+		if p != nil {
+			log.Printf("discover keys: key %s: reshare %s pending (phase %s)", keyID, p.Job, p.Phase)
+			s.reshares[keyID] = *p
+		}
+	}
+	if !allReplied {
+		return
+	}
+	for keyID, p := range s.reshares {
+		known := false
+		for _, r := range reports[keyID] {
+			if r.Reshare != nil && r.Reshare.Job == p.Job {
+				known = true
+			}
+		}
+		if !known {
+			log.Printf("discover keys: key %s: no node has reshare %s pending any more; dropped", keyID, p.Job)
+			delete(s.reshares, keyID)
+		}
+	}
+}
+
+// mergeReports cross-checks the reports for one key; conflict is non-empty if
+// the nodes disagree. Nodes that still hold a share from an older committee
+// (a retire that never reached them) do not withhold the key: when the
+// newest committee agrees, they are listed as stale holders.
+func mergeReports(keyID string, byParty map[string]tssnet.KeyReport) (keyInfo, string) {
+	reporters := make([]string, 0, len(byParty))
+	for p := range byParty {
+		reporters = append(reporters, p)
+	}
+	sort.Strings(reporters)
+	first := byParty[reporters[0]]
+	groups := map[string][]string{} // committee -> reporters
+	for _, p := range reporters {
+		r := byParty[p]
+		if !strings.EqualFold(r.PubKeyHex, first.PubKeyHex) {
+			return keyInfo{}, fmt.Sprintf("pubkey differs: %s has %s, %s has %s", reporters[0], first.PubKeyHex, p, r.PubKeyHex)
+		}
+		c := committeeOf(r)
+		groups[c] = append(groups[c], p)
+	}
+
+	// the newest committee uses the largest party keys (reshare continues
+	// after the old ones)
+	var newest string
+	newestKey := big.NewInt(-1)
+	tie := false
+	for c, ps := range groups {
+		switch n := maxPartyKey(byParty[ps[0]]); n.Cmp(newestKey) {
+		case 1:
+			newest, newestKey, tie = c, n, false
+		case 0:
+			tie = true
+		}
+	}
+	if tie {
+		return keyInfo{}, fmt.Sprintf("nodes report different committees with party keys up to %s", newestKey)
+	}
+	ref := byParty[groups[newest][0]]
+	k := keyInfo{KeyID: keyID, Address: ref.AddrHex, PubKey: ref.PubKeyHex, Parties: ref.Parties, Threshold: ref.Threshold, PartyKeys: ref.PartyKeys, CreatedAt: ref.CreatedAt, ConfirmedBy: groups[newest]}
+	if len(k.Parties) == 0 {
+		// legacy share without metadata: assume the reporters and the flag threshold
+		k.Parties = groups[newest]
+		k.Threshold = *defaultThreshold
+	}
+	for c, ps := range groups {
+		if c == newest {
+			continue
+		}
+		for _, p := range ps {
+			if containsStr(k.Parties, p) {
+				r := byParty[p]
+				return keyInfo{}, fmt.Sprintf("committees differ: %s has %v t=%d, %s has %v t=%d", p, r.Parties, r.Threshold, groups[newest][0], k.Parties, k.Threshold)
+			}
+			k.StaleHolders = append(k.StaleHolders, p)
+		}
+	}
+	for _, p := range k.ConfirmedBy {
+		if !containsStr(k.Parties, p) {
+			return keyInfo{}, fmt.Sprintf("party %s holds a share but is not in %v", p, k.Parties)
+		}
+	}
+	if len(k.StaleHolders) > 0 {
+		sort.Strings(k.StaleHolders)
+		log.Printf("WARNING: key %s: %v still hold a share from an older committee; retire it (or delete keys/%s.json there)", keyID, k.StaleHolders, keyID)
+	}
+	return k, ""
+}
+
+// committeeOf identifies the committee a report's share belongs to.
+func committeeOf(r tssnet.KeyReport) string {
+	return fmt.Sprintf("%s|%d|%s", strings.Join(r.Parties, ","), r.Threshold, strings.Join(r.PartyKeys, ","))
+}
+
+// maxPartyKey returns the largest tss-lib party key of a report's committee:
+// keygen uses 1..n, a reshare continues after the old keys. Legacy shares
+// without metadata count as the oldest.
+func maxPartyKey(r tssnet.KeyReport) *big.Int {
+	if len(r.PartyKeys) == 0 {
+		return big.NewInt(int64(len(r.Parties)))
+	}
+	out := new(big.Int)
+	for _, ks := range r.PartyKeys {
+		if v, ok := new(big.Int).SetString(ks, 10); ok && v.Cmp(out) > 0 {
+			out = v
+		}
+	}
+	return out
+}
+
+func missingParties(parties []string, byParty map[string]tssnet.KeyReport) []string {
+	var out []string
+	for _, p := range parties {
+		if _, ok := byParty[p]; !ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func containsStr(xs []string, x string) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
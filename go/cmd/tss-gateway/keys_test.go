@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+func TestMergeReports(t *testing.T) {
+	const pub, addr = "0x04aa", "0x00000000000000000000000000000000000000aa"
+	keygen := tssnet.KeyReport{KeyID: "k", PubKeyHex: pub, AddrHex: addr, Parties: []string{"P1", "P2", "P3"}, Threshold: 1}
+	reshared := tssnet.KeyReport{KeyID: "k", PubKeyHex: pub, AddrHex: addr, Parties: []string{"P1", "P2", "P4"}, Threshold: 1, PartyKeys: []string{"4", "5", "6"}}
+	legacy := tssnet.KeyReport{KeyID: "k", PubKeyHex: pub, AddrHex: addr}
+	other := keygen
+	other.PubKeyHex = "0x04bb"
+	thr2 := keygen
+	thr2.Threshold = 2
+
+	cases := []struct {
+		name      string
+		byParty   map[string]tssnet.KeyReport
+		parties   string
+		stale     string
+		wantConfl string
+	}{
+		{"all agree", map[string]tssnet.KeyReport{"P1": keygen, "P2": keygen, "P3": keygen}, "P1,P2,P3", "", ""},
+		{"legacy reporters", map[string]tssnet.KeyReport{"P1": legacy, "P2": legacy}, "P1,P2", "", ""},
+		{"old-only holder is stale", map[string]tssnet.KeyReport{"P1": reshared, "P2": reshared, "P3": keygen, "P4": reshared}, "P1,P2,P4", "P3", ""},
+		{"stale holder of a legacy share", map[string]tssnet.KeyReport{"P1": reshared, "P3": legacy}, "P1,P2,P4", "P3", ""},
+		{"new member with the old share", map[string]tssnet.KeyReport{"P1": reshared, "P2": keygen}, "", "", "committees differ"},
+		{"pubkey differs", map[string]tssnet.KeyReport{"P1": keygen, "P2": other}, "", "", "pubkey differs"},
+		{"same keys, other threshold", map[string]tssnet.KeyReport{"P1": keygen, "P2": thr2}, "", "", "different committees"},
+		{"holder outside the committee", map[string]tssnet.KeyReport{"P1": keygen, "P5": keygen}, "", "", "not in"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			k, conflict := mergeReports("k", tc.byParty)
+			if tc.wantConfl != "" {
+				if !strings.Contains(conflict, tc.wantConfl) {
+					t.Fatalf("conflict = %q, want containing %q", conflict, tc.wantConfl)
+				}
+				return
+			}
+			if conflict != "" {
+				t.Fatalf("unexpected conflict: %s", conflict)
+			}
+			if got := strings.Join(k.Parties, ","); got != tc.parties {
+				t.Fatalf("parties = %s, want %s", got, tc.parties)
+			}
+			if got := strings.Join(k.StaleHolders, ","); got != tc.stale {
+				t.Fatalf("stale holders = %s, want %s", got, tc.stale)
+			}
+			if k.Address != addr {
+				t.Fatalf("address = %s", k.Address)
+			}
+		})
+	}
+}
+
+func TestHandleAddress(t *testing.T) {
+	s := &server{keys: map[string]keyInfo{"k": {KeyID: "k", Address: "0x00000000000000000000000000000000000000aa"}}}
+	cases := []struct {
+		url  string
+		want int
+	}{
+		{"/address?key_id=k", http.StatusOK},
+		{"/address?key_id=missing", http.StatusNotFound},
+		{"/address?key_id=missing&path=m/0", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.url, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.handleAddress(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
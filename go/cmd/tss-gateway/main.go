@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
@@ -10,8 +14,9 @@ import (
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
-
+	"mp-htlc-lgp/experiment/internal/apiauth"
+	"mp-htlc-lgp/experiment/internal/eth"
+	"mp-htlc-lgp/experiment/internal/hdkey"
 	"mp-htlc-lgp/experiment/internal/tssnet"
 )
 
@@ -22,38 +27,131 @@ var (
 	defaultParties   = flag.String("parties", "P1,P2,P3", "comma-separated party IDs")
 	defaultThreshold = flag.Int("threshold", 1, "threshold t for {t,n}")
 	listenAddr       = flag.String("listen", ":9100", "http listen")
+	policyPath       = flag.String("policy", "", "signing policy JSON file (empty = no policy)")
+	rpcURL           = flag.String("rpc", "", "Ethereum JSON-RPC URL for on-chain checks (/authorizeClaim)")
+	authKeysPath     = flag.String("auth-keys", "", "API keys JSON file (empty = no authentication)")
+	tlsCert          = flag.String("tls-cert", "", "TLS certificate file (enables HTTPS)")
+	tlsKey           = flag.String("tls-key", "", "TLS private key file")
+	tlsClientCA      = flag.String("tls-client-ca", "", "CA file for client certificates (enables mutual TLS)")
+	keysFilePath     = flag.String("keys-file", "", "file to persist key metadata (empty = memory only)")
+	discoverWait     = flag.Duration("discover-timeout", 15*time.Second, "how long to wait for nodes to report stored keys at startup")
+	signTimeout      = flag.Duration("sign-timeout", 15*time.Minute, "deadline of one signing session")
+	keygenTimeout    = flag.Duration("keygen-timeout", 45*time.Minute, "deadline of one keygen or reshare session")
+	presigPool       = flag.Int("presig-pool", 0, "presignatures to keep ready per key (0 = off)")
+	presigTTL        = flag.Duration("presig-ttl", 30*time.Minute, "discard presignatures older than this (keep below the nodes' -presig-ttl)")
+	presigIdle       = flag.Duration("presig-idle", 2*time.Second, "refill the presignature pool only after no sign request for this long")
+	identityKey      = flag.String("identity-key", "", "file with the gateway's ed25519 identity key, answers the coordinator's challenge (empty = none)")
+	genIdentity      = flag.Bool("gen-identity", false, "print the public key of -identity-key (created if missing) for the coordinator registry, then exit")
+	coordinatorCA    = flag.String("coordinator-ca", "", "CA file trusted for a wss:// coordinator (empty = system roots)")
 )
 
 type server struct {
-	wsMu sync.Mutex
-	ws   *websocket.Conn
+	ws *tssnet.Conn // reconnects on its own; see readLoop
 
 	// serialize requests: simplest & safest for experiments
 	reqMu sync.Mutex
 
-	in chan tssnet.WSMessage
+	in     chan tssnet.WSMessage
+	keysIn chan tssnet.WSMessage // keys_result answers to discoverKeys
+	poolIn chan tssnet.WSMessage // preparams_result answers to queryPreParams
+	poolMu sync.Mutex            // one pre-params query at a time
+
+	mu           sync.RWMutex
+	keys         map[string]keyInfo        // key_id -> key
+	keyConflicts map[string]string         // key_id -> why nodes disagree (not served)
+	reshares     map[string]pendingReshare // key_id -> reshare not finished on every node
+
+	failMu   sync.Mutex
+	failures map[string]*partyFailures // party -> failed sessions it was involved in
+
+	jobsMu sync.Mutex
+	jobs   map[string]*job // running TSS sessions by id
+
+	presig presigStore // see presig.go
 
-	mu         sync.RWMutex
-	lastPubKey string
-	lastAddr   string
+	policy *txPolicy              // nil = sign anything
+	chain  *chainView             // nil = no on-chain checks
+	auth   *apiauth.Authenticator // nil = unauthenticated
 }
 
 func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	if *genIdentity {
+		pub, err := tssnet.EnsureIdentity(*identityKey)
+		if err != nil {
+			log.Fatalf("identity key: %v", err)
+		}
+		fmt.Println(hex.EncodeToString(pub))
+		return
+	}
 
-	s := &server{in: make(chan tssnet.WSMessage, 1024)}
+	if err := apiauth.CheckTLSFiles(*tlsCert, *tlsKey, *tlsClientCA); err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+
+	s := &server{in: make(chan tssnet.WSMessage, 1024), keysIn: make(chan tssnet.WSMessage, 64), poolIn: make(chan tssnet.WSMessage, 64), keyConflicts: map[string]string{}, failures: map[string]*partyFailures{}, jobs: map[string]*job{}}
+	keys, reshares, err := loadKeysFile(*keysFilePath)
+	if err != nil {
+		log.Fatalf("keys file: %v", err)
+	}
+	s.keys, s.reshares = keys, reshares
+	s.presig.ready, s.presig.failedAt = map[string][]presigEntry{}, map[string]time.Time{}
+	if *policyPath != "" {
+		p, err := loadPolicy(*policyPath)
+		if err != nil {
+			log.Fatalf("policy: %v", err)
+		}
+		s.policy = p
+		log.Printf("signing policy loaded from %s", *policyPath)
+	}
+	if *rpcURL != "" {
+		cv, err := dialChain(*rpcURL)
+		if err != nil {
+			log.Fatalf("rpc: %v", err)
+		}
+		s.chain = cv
+		log.Printf("rpc connected chainId=%s", cv.chainID)
+	}
+	if *authKeysPath != "" {
+		a, err := apiauth.Load(*authKeysPath)
+		if err != nil {
+			log.Fatalf("auth keys: %v", err)
+		}
+		s.auth = a
+		log.Printf("api authentication enabled (%s)", *authKeysPath)
+	} else {
+		log.Printf("WARNING: no -auth-keys; HTTP API is unauthenticated")
+	}
 	if err := s.connectWS(); err != nil {
 		log.Fatalf("ws connect: %v", err)
 	}
 	go s.readLoop()
+	go s.discoverKeys(*discoverWait)
+	if *presigPool > 0 {
+		log.Printf("presignature pool: %d per key (ttl %s, idle %s)", *presigPool, *presigTTL, *presigIdle)
+		go s.fillPresigs()
+	}
 
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200); w.Write([]byte("ok")) })
-	http.HandleFunc("/address", s.handleAddress)
-	http.HandleFunc("/keygen", s.handleKeygen)
-	http.HandleFunc("/signHash", s.handleSignHash)
-	log.Printf("tss gateway listening on %s", *listenAddr)
-	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+	http.HandleFunc("/address", s.auth.Require(apiauth.ScopeAny, s.handleAddress))
+	http.HandleFunc("/keys", s.auth.Require(apiauth.ScopeAny, s.handleKeys))
+	http.HandleFunc("/keygen", s.auth.Require(apiauth.ScopeKeygen, s.handleKeygen))
+	http.HandleFunc("/reshare", s.auth.Require(apiauth.ScopeAdmin, s.handleReshare))
+	http.HandleFunc("/reshare/finish", s.auth.Require(apiauth.ScopeAdmin, s.handleReshareFinish))
+	http.HandleFunc("/reshare/rollback", s.auth.Require(apiauth.ScopeAdmin, s.handleReshareRollback))
+	http.HandleFunc("/preparams", s.auth.Require(apiauth.ScopeAny, s.handlePreParams))
+	http.HandleFunc("/presigs", s.auth.Require(apiauth.ScopeAny, s.handlePresigs))
+	http.HandleFunc("/parties", s.auth.Require(apiauth.ScopeAny, s.handleParties))
+	http.HandleFunc("/jobs", s.auth.Require(apiauth.ScopeAny, s.handleJobs))
+	http.HandleFunc("/jobs/", s.auth.Require(apiauth.ScopeAny, s.handleJobs))
+	http.HandleFunc("/signHash", s.auth.Require(apiauth.ScopeSign, s.handleSignHash))
+	http.HandleFunc("/signTx", s.auth.Require(apiauth.ScopeSign, s.handleSignTx))
+	http.HandleFunc("/signTypedData", s.auth.Require(apiauth.ScopeSign, s.handleSignTypedData))
+	http.HandleFunc("/personalSign", s.auth.Require(apiauth.ScopeSign, s.handlePersonalSign))
+	http.HandleFunc("/authorizeClaim", s.auth.Require(apiauth.ScopeSign, s.handleAuthorizeClaim))
+	log.Printf("tss gateway listening on %s (tls=%v)", *listenAddr, *tlsCert != "")
+	log.Fatal(apiauth.ListenAndServe(*listenAddr, nil, *tlsCert, *tlsKey, *tlsClientCA))
 }
 
 func (s *server) connectWS() error {
@@ -61,13 +159,18 @@ func (s *server) connectWS() error {
 	if err != nil {
 		return err
 	}
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	opts, err := tssnet.ClientOptions(*identityKey, *coordinatorCA)
+	if err != nil {
+		return err
+	}
+	c, err := tssnet.Dial(u.String(), opts, func(resume bool) tssnet.WSMessage {
+		return tssnet.WSMessage{Type: "hello", Session: *clusterSession, Party: *gatewayParty, Role: "gateway", Resume: resume}
+	})
 	if err != nil {
 		return err
 	}
 	s.ws = c
-	// hello
-	return c.WriteMessage(websocket.TextMessage, tssnet.MustJSON(tssnet.WSMessage{Type: "hello", Session: *clusterSession, Party: *gatewayParty, Role: "gateway"}))
+	return nil
 }
 
 func partiesFromFlag() []string {
@@ -82,162 +185,295 @@ func partiesFromFlag() []string {
 	return out
 }
 
-func (s *server) readLoop() {
-	for {
-		_, b, err := s.ws.ReadMessage()
-		if err != nil {
-			log.Fatalf("ws read: %v", err)
+// checkParties rejects empty or duplicate party names and the gateway's own.
+func checkParties(parties []string) error {
+	seen := map[string]bool{}
+	for _, p := range parties {
+		if p == "" || p == *gatewayParty || seen[p] {
+			return newAPIError(http.StatusBadRequest, "bad or duplicate party", "party", p)
 		}
-		var m tssnet.WSMessage
-		if err := json.Unmarshal(b, &m); err != nil {
-			continue
+		seen[p] = true
+	}
+	return nil
+}
+
+// readLoop dispatches coordinator messages; a lost connection is retried
+// forever, and running jobs keep waiting for their results meanwhile (their
+// deadline still applies).
+func (s *server) readLoop() {
+	s.ws.Run(func(m tssnet.WSMessage) {
+		if m.Type == "welcome" && m.Resume {
+			log.Printf("reconnected to coordinator; connected parties %v", m.Parties)
+			return
 		}
 		if m.Type != "cmd" {
-			continue
+			return
 		}
-		// cache address/pubkey on successful keygen
-		if m.Cmd == "keygen_result" && m.Ok && m.AddrHex != "" {
-			s.mu.Lock()
-			s.lastPubKey = m.PubKeyHex
-			s.lastAddr = m.AddrHex
-			s.mu.Unlock()
+		if m.Cmd == "keys_result" || m.Cmd == "preparams_result" {
+			ch := s.keysIn
+			if m.Cmd == "preparams_result" {
+				ch = s.poolIn
+			}
+			select {
+			case ch <- m:
+			default:
+			}
+			return
 		}
 		select {
 		case s.in <- m:
 		default:
 			// drop if overwhelmed
 		}
-	}
+	})
 }
 
+// sendCmd sends m to the coordinator, queued while reconnecting.
 func (s *server) sendCmd(m tssnet.WSMessage) error {
-	s.wsMu.Lock()
-	defer s.wsMu.Unlock()
-	return s.ws.WriteMessage(websocket.TextMessage, tssnet.MustJSON(m))
+	return s.ws.Send(m)
 }
 
 // --- HTTP handlers ---
 
-func (s *server) handleAddress(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+func (s *server) handleSignHash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	s.mu.RLock()
-	addr := s.lastAddr
-	pub := s.lastPubKey
-	s.mu.RUnlock()
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{"address": addr, "pubkey": pub})
-}
 
-func (s *server) handleKeygen(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	if s.policy != nil && !s.policy.AllowSignHash {
+		writeJSON(w, http.StatusForbidden, map[string]any{"ok": false, "err": "policy: blind /signHash disabled; use /signTx"})
+		return
+	}
+
+	var req struct {
+		HashHex string `json:"hash_hex"`
+		KeyID   string `json:"key_id"`
+		Path    string `json:"path"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	if req.HashHex == "" {
+		req.HashHex = r.URL.Query().Get("hash_hex")
+	}
+	if req.HashHex == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": "missing hash_hex"})
+		return
+	}
+	digest, err := decode32(req.HashHex)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": err.Error()})
+		return
+	}
+	keyID, err := requestKeyID(r, req.KeyID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	path, err := requestPath(r, req.Path)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	sig, err := s.signDigest(r, keyID, path, digest)
+	if err != nil {
+		writeErr(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "key_id": keyID, "path": path.String(), "r": hex0x(sig.R), "s": hex0x(sig.S), "v": 27 + sig.V, "party": sig.Party, "parties": sig.Parties, "t_sign_ms": sig.Took.Milliseconds(), "presig": sig.Presig, "telemetry": sig.Telemetry})
+}
+
+// sigResult is a verified, low-s threshold signature over a 32-byte digest.
+type sigResult struct {
+	R, S    []byte // 32 bytes each
+	V       byte   // recovery id (0/1)
+	Party   string // first party to answer
+	Parties []string
+	Took    time.Duration
+	Presig  string // presignature used ("" = full protocol)
+	Path    string // derivation path signed for ("" = the key itself)
+	// Telemetry is the session's per-round timeline; nil when the nodes
+	// sent none.
+	Telemetry *telemetryReport
+}
+
+// sig65 returns r||s||v with v = 0/1.
+func (s *sigResult) sig65() []byte {
+	return append(append(append(make([]byte, 0, 65), s.R...), s.S...), s.V)
+}
+
+// apiError carries the HTTP status and JSON body a handler should return.
+type apiError struct {
+	status int
+	body   map[string]any
+}
+
+func (e *apiError) Error() string { return fmt.Sprint(e.body["err"]) }
+
+func newAPIError(status int, msg string, kv ...any) *apiError {
+	body := map[string]any{"ok": false, "err": msg}
+	for i := 0; i+1 < len(kv); i += 2 {
+		body[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	return &apiError{status: status, body: body}
+}
+
+// signDigest runs one signing session for keyID (or its child at path) across
+// the key's parties, waits for every party's result and checks that they
+// agree and verify against the signing public key. Requests are serialized via reqMu; the
+// session is a job bounded by -sign-timeout and r's context. A presignature
+// from the pool is used unless the request has ?presig=0 or a path; if a node
+// lost it, the digest is signed again with the full protocol.
+func (s *server) signDigest(r *http.Request, keyID string, path hdkey.Path, digest []byte) (*sigResult, error) {
+	pub, _, err := s.keyPub(keyID, path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.preemptPresign()
+	defer s.signDone()
 	s.reqMu.Lock()
 	defer s.reqMu.Unlock()
-
-	parties := partiesFromFlag()
-	thr := *defaultThreshold
+	if err := s.reshareBlocksSigning(keyID); err != nil {
+		return nil, err
+	}
 	start := time.Now()
-	_ = s.sendCmd(tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *gatewayParty, Cmd: "keygen", Parties: parties, Threshold: thr})
 
-	deadline := time.After(45 * time.Minute)
-	byParty := map[string]tssnet.WSMessage{}
-	for len(byParty) < len(parties) {
+	parties, thr := s.signingSet(keyID)
+	presig := ""
+	// presignatures are made for the key itself
+	if r.URL.Query().Get("presig") != "0" && len(path) == 0 {
+		presig = s.takePresig(keyID, parties, thr)
+	}
+	sig, err := s.signSession(r, keyID, path.String(), digest, pub, parties, thr, presig)
+	if errors.Is(err, errPresigLost) {
+		log.Printf("sign key %s: %v; signing again without", keyID, err)
+		s.presigLost(keyID)
+		sig, err = s.signSession(r, keyID, "", digest, pub, parties, thr, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	sig.Took = time.Since(start)
+	return sig, nil
+}
+
+// signSession runs one sign job; with presig set only the online rounds 5-9 run.
+func (s *server) signSession(r *http.Request, keyID, path string, digest, pub []byte, parties []string, thr int, presig string) (*sigResult, error) {
+	j := s.startJob(r, "sign", keyID, parties, *signTimeout)
+	defer s.endJob(j)
+	cmd := j.msg()
+	cmd.Threshold, cmd.HashHex, cmd.Presig, cmd.Path = thr, hex0x(digest), presig, path
+	_ = s.sendCmd(cmd)
+
+	results := map[string]tssnet.WSMessage{}
+	var order []string
+	for len(order) < len(parties) {
 		select {
 		case m := <-s.in:
-			if m.Cmd != "keygen_result" {
+			if m.Cmd != "sign_result" || m.Job != j.ID || !containsStr(parties, m.Party) {
 				continue
 			}
-			byParty[m.Party] = m
-		case <-deadline:
-			w.WriteHeader(http.StatusGatewayTimeout)
-			_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": "timeout", "received": len(byParty), "expected": len(parties)})
-			return
+			if !m.Ok && presig != "" && strings.HasPrefix(m.Err, tssnet.ErrNoPresig) {
+				s.cancelParties(j, pendingParties(parties, results, m.Party))
+				return nil, fmt.Errorf("%w: %s: %s", errPresigLost, m.Party, m.Err)
+			}
+			if !m.Ok {
+				return nil, newAPIError(http.StatusBadGateway, m.Err, s.sessionFailed("sign", keyID, m)...)
+			}
+			if _, dup := results[m.Party]; dup {
+				continue
+			}
+			results[m.Party] = m
+			order = append(order, m.Party)
+		case <-j.ctx.Done():
+			return nil, s.stopJob(j, results)
 		}
 	}
+	sig, err := checkSignResults(digest, pub, order, results)
+	if err != nil {
+		return nil, err
+	}
+	sig.Presig, sig.Path = presig, path
+	sig.Telemetry = s.telemetryReport(j.Started, results)
+	return sig, nil
+}
 
-	// sanity check: all ok, same addr
-	var addr, pub string
-	for _, p := range parties {
-		m := byParty[p]
-		if !m.Ok {
-			w.WriteHeader(http.StatusBadGateway)
-			_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": "party failed", "party": p, "detail": m.Err})
-			return
-		}
-		if addr == "" {
-			addr, pub = m.AddrHex, m.PubKeyHex
+// checkSignResults requires every party to report the same (r,s), verifies it
+// against pub and normalizes s to low-s.
+func checkSignResults(digest, pub []byte, order []string, results map[string]tssnet.WSMessage) (*sigResult, error) {
+	first := results[order[0]]
+	r, errR := decodeScalar(first.RHex)
+	sv, errS := decodeScalar(first.SHex)
+	if errR != nil || errS != nil {
+		return nil, newAPIError(http.StatusBadGateway, "bad r/s from party", "party", first.Party)
+	}
+	for _, p := range order[1:] {
+		m := results[p]
+		r2, errR := decodeScalar(m.RHex)
+		s2, errS := decodeScalar(m.SHex)
+		if errR == nil && errS == nil && bytes.Equal(r, r2) && bytes.Equal(sv, s2) {
 			continue
 		}
-		if m.AddrHex != "" && addr != "" && strings.ToLower(m.AddrHex) != strings.ToLower(addr) {
-			w.WriteHeader(http.StatusBadGateway)
-			_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": "address mismatch", "a": addr, "b": m.AddrHex, "party": p})
-			return
+		all := map[string]any{}
+		for _, q := range order {
+			all[q] = map[string]string{"r": results[q].RHex, "s": results[q].SHex}
 		}
+		log.Printf("sign: parties disagree on signature: %v", all)
+		return nil, newAPIError(http.StatusBadGateway, "parties returned different signatures", "results", all)
 	}
 
-	resp := map[string]any{
-		"ok": true,
-		"address": addr,
-		"pubkey": pub,
-		"threshold": thr,
-		"parties": parties,
-		"t_keygen_ms": time.Since(start).Milliseconds(),
+	sig65, highS, err := eth.NormalizeSignature(digest, r, sv, pub)
+	if err != nil {
+		log.Printf("sign: rejecting signature from %v: %v", order, err)
+		return nil, newAPIError(http.StatusBadGateway, "invalid threshold signature: "+err.Error(), "party", first.Party)
+	}
+	if highS {
+		log.Printf("sign: normalized high-s signature from %v", order)
 	}
+	return &sigResult{R: sig65[0:32], S: sig65[32:64], V: sig65[64], Party: first.Party, Parties: order}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
 }
 
-func (s *server) handleSignHash(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func writeErr(w http.ResponseWriter, err error) {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		writeJSON(w, ae.status, ae.body)
 		return
 	}
-	s.reqMu.Lock()
-	defer s.reqMu.Unlock()
+	writeJSON(w, http.StatusInternalServerError, map[string]any{"ok": false, "err": err.Error()})
+}
 
-	var req struct {
-		HashHex string `json:"hash_hex"`
+func hex0x(b []byte) string { return "0x" + hex.EncodeToString(b) }
+
+func decode32(h string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(h), "0x"))
+	if err != nil {
+		return nil, err
 	}
-	_ = json.NewDecoder(r.Body).Decode(&req)
-	if req.HashHex == "" {
-		req.HashHex = r.URL.Query().Get("hash_hex")
+	if len(b) != 32 {
+		return nil, fmt.Errorf("hash must be 32 bytes, got %d", len(b))
 	}
-	if req.HashHex == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": "missing hash_hex"})
-		return
-	}
-
-	parties := partiesFromFlag()
-	thr := *defaultThreshold
-	start := time.Now()
-	_ = s.sendCmd(tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *gatewayParty, Cmd: "sign", Parties: parties, Threshold: thr, HashHex: req.HashHex})
+	return b, nil
+}
 
-	deadline := time.After(15 * time.Minute)
-	for {
-		select {
-		case m := <-s.in:
-			if m.Cmd != "sign_result" {
-				continue
-			}
-			if !m.Ok {
-				w.WriteHeader(http.StatusBadGateway)
-				_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": m.Err, "party": m.Party})
-				return
-			}
-			resp := map[string]any{"ok": true, "r": m.RHex, "s": m.SHex, "party": m.Party, "t_sign_ms": time.Since(start).Milliseconds()}
-			w.Header().Set("Content-Type", "application/json")
-			_ = json.NewEncoder(w).Encode(resp)
-			return
-		case <-deadline:
-			w.WriteHeader(http.StatusGatewayTimeout)
-			_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": "timeout"})
-			return
-		}
+// decodeScalar parses a hex scalar (r or s) and left-pads it to 32 bytes.
+func decodeScalar(h string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(h), "0x"))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 32 {
+		return nil, fmt.Errorf("too long: %d", len(b))
 	}
+	out := make([]byte, 32)
+	copy(out[32-len(b):], b)
+	return out, nil
 }
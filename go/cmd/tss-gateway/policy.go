@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+
+	"mp-htlc-lgp/experiment/internal/eth"
+)
+
+// txPolicy is the declarative signing policy for transaction-shaped requests.
+// An empty list means "no restriction" for that field.
+//
+//	{
+//	  "chain_ids": [11155111],
+//	  "allow_to": ["0x<token>", "0x<htlc>"],
+//	  "allow_methods": ["approve", "lock", "refund(bytes32)", "0x095ea7b3"],
+//	  "max_value_wei": "0",
+//	  "max_amount": "100000000000000000000",
+//	  "allow_sign_hash": false,
+//	  "allow_typed_data": false,
+//	  "allow_personal_sign": false,
+//	  "htlc_contracts": ["0x<htlc>"]
+//	}
+//
+// allow_methods entries are a method name from the repo ABIs (ERC20, MPHTLC),
+// a full signature, or a 4-byte selector. max_amount caps every argument named
+// "amount" of a known method; with it set, a call is refused unless it is a
+// known method with an amount argument or one of amountFreeMethods.
+// allow_sign_hash keeps blind /signHash open, and
+// allow_typed_data / allow_personal_sign do the same for /signTypedData and
+// /personalSign. Even then, EIP-712 Claim data and any typed data whose
+// verifyingContract is in htlc_contracts are refused: claim signatures only
+// come from /authorizeClaim, which checks the lock on chain first.
+type txPolicy struct {
+	ChainIDs          []int64  `json:"chain_ids"`
+	AllowTo           []string `json:"allow_to"`
+	AllowMethods      []string `json:"allow_methods"`
+	MaxValueWei       string   `json:"max_value_wei"`
+	MaxAmount         string   `json:"max_amount"`
+	AllowSignHash     bool     `json:"allow_sign_hash"`
+	AllowTypedData    bool     `json:"allow_typed_data"`
+	AllowPersonalSign bool     `json:"allow_personal_sign"`
+	HTLCContracts     []string `json:"htlc_contracts"`
+
+	chains    map[int64]bool
+	to        map[common.Address]bool
+	htlc      map[common.Address]bool
+	selectors map[[4]byte]string
+	maxValue  *big.Int
+	maxAmount *big.Int
+}
+
+// knownABIs are used to resolve method names and decode "amount" arguments.
+var knownABIs = []abi.ABI{eth.ERC20ABI(), eth.MPHTLCABI()}
+
+// amountFreeMethods are the known methods that move no tokens, so max_amount
+// lets them through without an "amount" argument.
+var amountFreeMethods = map[string]bool{
+	"refund":               true,
+	"claimWithSig":         true,
+	"confirmParticipation": true,
+}
+
+func loadPolicy(path string) (*txPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var p txPolicy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *txPolicy) compile() error {
+	if len(p.ChainIDs) > 0 {
+		p.chains = map[int64]bool{}
+		for _, id := range p.ChainIDs {
+			p.chains[id] = true
+		}
+	}
+	if len(p.AllowTo) > 0 {
+		p.to = map[common.Address]bool{}
+		for _, a := range p.AllowTo {
+			if !common.IsHexAddress(a) {
+				return fmt.Errorf("bad allow_to address %q", a)
+			}
+			p.to[common.HexToAddress(a)] = true
+		}
+	}
+	p.htlc = map[common.Address]bool{}
+	for _, a := range p.HTLCContracts {
+		if !common.IsHexAddress(a) {
+			return fmt.Errorf("bad htlc_contracts address %q", a)
+		}
+		p.htlc[common.HexToAddress(a)] = true
+	}
+	if len(p.AllowMethods) > 0 {
+		p.selectors = map[[4]byte]string{}
+		for _, m := range p.AllowMethods {
+			sel, err := parseSelector(m)
+			if err != nil {
+				return err
+			}
+			p.selectors[sel] = m
+		}
+	}
+	var ok bool
+	if p.MaxValueWei != "" {
+		if p.maxValue, ok = new(big.Int).SetString(p.MaxValueWei, 10); !ok {
+			return fmt.Errorf("bad max_value_wei %q", p.MaxValueWei)
+		}
+	}
+	if p.MaxAmount != "" {
+		if p.maxAmount, ok = new(big.Int).SetString(p.MaxAmount, 10); !ok {
+			return fmt.Errorf("bad max_amount %q", p.MaxAmount)
+		}
+	}
+	return nil
+}
+
+// parseSelector accepts "0x095ea7b3", "approve(address,uint256)" or a method
+// name from knownABIs.
+func parseSelector(m string) ([4]byte, error) {
+	var sel [4]byte
+	m = strings.TrimSpace(m)
+	switch {
+	case strings.HasPrefix(m, "0x") && len(m) == 10:
+		b, err := hex.DecodeString(m[2:])
+		if err != nil {
+			return sel, fmt.Errorf("bad selector %q", m)
+		}
+		copy(sel[:], b)
+		return sel, nil
+	case strings.Contains(m, "("):
+		copy(sel[:], crypto.Keccak256([]byte(strings.ReplaceAll(m, " ", "")))[:4])
+		return sel, nil
+	}
+	for _, a := range knownABIs {
+		if method, ok := a.Methods[m]; ok {
+			copy(sel[:], method.ID)
+			return sel, nil
+		}
+	}
+	return sel, fmt.Errorf("unknown method %q (use a full signature or 0x selector)", m)
+}
+
+// check returns every rule the tx violates; empty means allowed.
+func (p *txPolicy) check(chainID *big.Int, tx *types.Transaction) []string {
+	var out []string
+	if p.chains != nil && (!chainID.IsInt64() || !p.chains[chainID.Int64()]) {
+		out = append(out, fmt.Sprintf("chain id %s not allowed", chainID))
+	}
+	if p.to != nil {
+		if tx.To() == nil {
+			out = append(out, "contract creation not allowed")
+		} else if !p.to[*tx.To()] {
+			out = append(out, fmt.Sprintf("to %s not allowed", tx.To().Hex()))
+		}
+	}
+	if p.maxValue != nil && tx.Value().Cmp(p.maxValue) > 0 {
+		out = append(out, fmt.Sprintf("value %s exceeds max_value_wei %s", tx.Value(), p.maxValue))
+	}
+
+	data := tx.Data()
+	if len(data) < 4 {
+		if p.selectors != nil {
+			out = append(out, "calldata has no method selector")
+		}
+		return out
+	}
+	var sel [4]byte
+	copy(sel[:], data[:4])
+	if p.selectors != nil {
+		if _, ok := p.selectors[sel]; !ok {
+			out = append(out, fmt.Sprintf("method 0x%x not allowed", sel))
+		}
+	}
+	if p.maxAmount != nil {
+		out = append(out, p.checkAmount(sel, data[4:])...)
+	}
+	return out
+}
+
+// checkTypedData returns every rule the EIP-712 data violates; empty means
+// allowed. Claim-shaped data is refused even with allow_typed_data.
+func (p *txPolicy) checkTypedData(td apitypes.TypedData) []string {
+	var out []string
+	if !p.AllowTypedData {
+		out = append(out, "typed data signing disabled")
+	}
+	if td.PrimaryType == "Claim" || td.Domain.Name == eth.ClaimDomainName {
+		out = append(out, "claim signatures are only issued by /authorizeClaim")
+	} else if common.IsHexAddress(td.Domain.VerifyingContract) && p.htlc[common.HexToAddress(td.Domain.VerifyingContract)] {
+		out = append(out, fmt.Sprintf("verifyingContract %s is an HTLC; use /authorizeClaim", td.Domain.VerifyingContract))
+	}
+	if p.chains != nil {
+		if td.Domain.ChainId == nil {
+			out = append(out, "domain has no chainId")
+		} else if id := (*big.Int)(td.Domain.ChainId); !id.IsInt64() || !p.chains[id.Int64()] {
+			out = append(out, fmt.Sprintf("chain id %s not allowed", id))
+		}
+	}
+	return out
+}
+
+// checkAmount fails closed: a call max_amount cannot check (unknown selector,
+// undecodable arguments, no "amount" argument) is a violation.
+func (p *txPolicy) checkAmount(sel [4]byte, args []byte) []string {
+	for _, a := range knownABIs {
+		method, err := a.MethodById(sel[:])
+		if err != nil {
+			continue
+		}
+		vals, err := method.Inputs.Unpack(args)
+		if err != nil {
+			return []string{fmt.Sprintf("cannot decode %s arguments: %v", method.Name, err)}
+		}
+		var out []string
+		found := false
+		for i, in := range method.Inputs {
+			if in.Name != "amount" {
+				continue
+			}
+			amt, ok := vals[i].(*big.Int)
+			if !ok {
+				return []string{fmt.Sprintf("%s amount is not an integer", method.Name)}
+			}
+			found = true
+			if amt.Cmp(p.maxAmount) > 0 {
+				out = append(out, fmt.Sprintf("%s amount %s exceeds max_amount %s", method.Name, amt, p.maxAmount))
+			}
+		}
+		if !found && !amountFreeMethods[method.Name] {
+			out = append(out, fmt.Sprintf("%s has no amount argument for max_amount", method.Name))
+		}
+		return out
+	}
+	return []string{fmt.Sprintf("method 0x%x unknown, cannot check max_amount", sel)}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+
+	"mp-htlc-lgp/experiment/internal/eth"
+)
+
+var (
+	testToken = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	testHTLC  = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	testOther = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+)
+
+func testPolicy(t *testing.T) *txPolicy {
+	t.Helper()
+	p := &txPolicy{
+		ChainIDs:      []int64{11155111},
+		AllowTo:       []string{testToken.Hex(), testHTLC.Hex()},
+		AllowMethods:  []string{"approve", "transfer", "transferFrom", "refund(bytes32)", "0xa4d4b4cc"},
+		MaxValueWei:   "0",
+		MaxAmount:     "100",
+		HTLCContracts: []string{testHTLC.Hex()},
+	}
+	if err := p.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func callTx(t *testing.T, to *common.Address, value int64, method string, args ...any) *types.Transaction {
+	t.Helper()
+	var data []byte
+	if method != "" {
+		var err error
+		for _, a := range knownABIs {
+			if _, ok := a.Methods[method]; ok {
+				data, err = a.Pack(method, args...)
+				break
+			}
+		}
+		if err != nil || data == nil {
+			t.Fatalf("pack %s: %v", method, err)
+		}
+	}
+	return types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(11155111), Gas: 100000, To: to, Value: big.NewInt(value), Data: data})
+}
+
+func rawTx(to *common.Address, data string) *types.Transaction {
+	return types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(11155111), Gas: 100000, To: to, Value: big.NewInt(0), Data: common.FromHex(data)})
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := testPolicy(t)
+	sepolia := big.NewInt(11155111)
+	lockID := [32]byte{1}
+	cases := []struct {
+		name    string
+		chainID *big.Int
+		tx      *types.Transaction
+		want    []string
+	}{
+		{"approve within cap", sepolia, callTx(t, &testToken, 0, "approve", testHTLC, big.NewInt(100)), nil},
+		{"refund by signature", sepolia, callTx(t, &testHTLC, 0, "refund", lockID), nil},
+		{"approve over cap", sepolia, callTx(t, &testToken, 0, "approve", testHTLC, big.NewInt(101)),
+			[]string{"approve amount 101 exceeds max_amount 100"}},
+		{"transfer over cap", sepolia, callTx(t, &testToken, 0, "transfer", testOther, big.NewInt(101)),
+			[]string{"transfer amount 101 exceeds max_amount 100"}},
+		{"transferFrom within cap", sepolia, callTx(t, &testToken, 0, "transferFrom", testHTLC, testOther, big.NewInt(100)), nil},
+		{"transferFrom over cap", sepolia, callTx(t, &testToken, 0, "transferFrom", testHTLC, testOther, big.NewInt(101)),
+			[]string{"transferFrom amount 101 exceeds max_amount 100"}},
+		{"raw selector without abi", sepolia, rawTx(&testToken, "a4d4b4cc"),
+			[]string{"method 0xa4d4b4cc unknown, cannot check max_amount"}},
+		{"undecodable arguments", sepolia, rawTx(&testToken, "095ea7b3"),
+			[]string{"cannot decode approve arguments: abi: attempting to unmarshal an empty string while arguments are expected"}},
+		{"wrong chain", big.NewInt(1), callTx(t, &testToken, 0, "approve", testHTLC, big.NewInt(1)),
+			[]string{"chain id 1 not allowed"}},
+		{"unknown recipient", sepolia, callTx(t, &testOther, 0, "approve", testHTLC, big.NewInt(1)),
+			[]string{"to " + testOther.Hex() + " not allowed"}},
+		{"contract creation", sepolia, callTx(t, nil, 0, ""),
+			[]string{"contract creation not allowed", "calldata has no method selector"}},
+		{"value over cap", sepolia, callTx(t, &testToken, 1, "approve", testHTLC, big.NewInt(1)),
+			[]string{"value 1 exceeds max_value_wei 0"}},
+		{"method not allowed", sepolia, callTx(t, &testToken, 0, "mint", testOther, big.NewInt(1)),
+			[]string{"method 0x40c10f19 not allowed"}},
+		{"plain transfer", sepolia, callTx(t, &testToken, 0, ""),
+			[]string{"calldata has no method selector"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := p.check(tc.chainID, tc.tx); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("check = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPolicyEmptyAllowsAll(t *testing.T) {
+	p := &txPolicy{}
+	if err := p.compile(); err != nil {
+		t.Fatal(err)
+	}
+	tx := callTx(t, &testOther, 5, "mint", testOther, big.NewInt(1e18))
+	if got := p.check(big.NewInt(1), tx); len(got) != 0 {
+		t.Fatalf("empty policy rejected tx: %q", got)
+	}
+}
+
+func TestPolicyCompileErrors(t *testing.T) {
+	for _, p := range []*txPolicy{
+		{AllowTo: []string{"nope"}},
+		{AllowMethods: []string{"burn"}},
+		{AllowMethods: []string{"0xzzzzzzzz"}},
+		{MaxValueWei: "1e18"},
+		{MaxAmount: "-"},
+		{HTLCContracts: []string{"0x12"}},
+	} {
+		if err := p.compile(); err == nil {
+			t.Errorf("compile(%+v) succeeded, want error", *p)
+		}
+	}
+}
+
+func TestPolicyCheckTypedData(t *testing.T) {
+	permit := func(contract common.Address, chainID int64) apitypes.TypedData {
+		return apitypes.TypedData{
+			PrimaryType: "Permit",
+			Domain: apitypes.TypedDataDomain{
+				Name: "Token", Version: "1",
+				ChainId:           math.NewHexOrDecimal256(chainID),
+				VerifyingContract: contract.Hex(),
+			},
+		}
+	}
+	claim := eth.ClaimTypedData(big.NewInt(11155111), testOther, [32]byte{1}, testOther)
+	claimRenamed := claim
+	claimRenamed.Domain.Name = "Other"
+	noChain := permit(testToken, 11155111)
+	noChain.Domain.ChainId = nil
+
+	closed := testPolicy(t)
+	open := testPolicy(t)
+	open.AllowTypedData = true
+	cases := []struct {
+		name string
+		p    *txPolicy
+		td   apitypes.TypedData
+		want []string
+	}{
+		{"denied by default", closed, permit(testToken, 11155111), []string{"typed data signing disabled"}},
+		{"allowed", open, permit(testToken, 11155111), nil},
+		{"claim primary type", open, claimRenamed, []string{"claim signatures are only issued by /authorizeClaim"}},
+		{"claim domain", open, claim, []string{"claim signatures are only issued by /authorizeClaim"}},
+		{"htlc contract", open, permit(testHTLC, 11155111),
+			[]string{"verifyingContract " + testHTLC.Hex() + " is an HTLC; use /authorizeClaim"}},
+		{"wrong chain", open, permit(testToken, 1), []string{"chain id 1 not allowed"}},
+		{"no chain id", open, noChain, []string{"domain has no chainId"}},
+		{"claim while closed", closed, claim,
+			[]string{"typed data signing disabled", "claim signatures are only issued by /authorizeClaim"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.p.checkTypedData(tc.td); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("checkTypedData = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// preParamsWait bounds how long a pool query waits for node answers.
+const preParamsWait = 3 * time.Second
+
+// queryPreParams asks every node for its pre-params pool status; parties that
+// do not answer within preParamsWait are missing from the result.
+func (s *server) queryPreParams(parties []string) map[string]tssnet.PreParamsStatus {
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+
+	// drop answers left over from a query that timed out
+	for len(s.poolIn) > 0 {
+		<-s.poolIn
+	}
+	_ = s.sendCmd(tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *gatewayParty, Cmd: "preparams", Parties: []string{"*"}})
+
+	out := map[string]tssnet.PreParamsStatus{}
+	deadline := time.After(preParamsWait)
+	for len(out) < len(parties) {
+		select {
+		case m := <-s.poolIn:
+			var st tssnet.PreParamsStatus
+			if err := json.Unmarshal(m.Payload, &st); err != nil {
+				log.Printf("preparams: party %s: bad payload: %v", m.Party, err)
+				continue
+			}
+			if containsStr(parties, m.Party) {
+				out[m.Party] = st
+			}
+		case <-deadline:
+			return out
+		}
+	}
+	return out
+}
+
+// poolsNotReady lists the parties without a ready pre-params entry.
+func (s *server) poolsNotReady(parties []string) []string {
+	pools := s.queryPreParams(parties)
+	var out []string
+	for _, p := range parties {
+		if st, ok := pools[p]; !ok || st.Ready == 0 {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (s *server) handlePreParams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	parties := partiesFromFlag()
+	pools := s.queryPreParams(parties)
+	warm := true
+	var missing []string
+	for _, p := range parties {
+		st, ok := pools[p]
+		if !ok {
+			missing = append(missing, p)
+		}
+		if !ok || st.Ready == 0 {
+			warm = false
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "warm_ready": warm, "nodes": pools, "no_answer": missing})
+}
+
+// keygenMode summarizes per-party modes: "warm", "cold" or "mixed".
+func keygenMode(modes map[string]string) string {
+	mode := ""
+	for _, m := range modes {
+		if mode == "" {
+			mode = m
+		} else if m != mode {
+			return "mixed"
+		}
+	}
+	return mode
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// Presignatures: the nodes run the message-independent rounds 1-4 of signing
+// ahead of time ("presign" cmd) and park the result; a sign naming one runs
+// only rounds 5-9 (see tss-node presign.go). The gateway keeps up to
+// -presig-pool of them per key, bound to the signing set they were made for,
+// and refills the pool once no sign request came for -presig-idle. A sign
+// request preempts a presign session in progress.
+
+// presigRetry is how long a key waits after a failed presign session.
+const presigRetry = 30 * time.Second
+
+// presigPoll is how often the filler looks for a key below -presig-pool.
+const presigPoll = 500 * time.Millisecond
+
+var (
+	errPreempted  = errors.New("preempted by a sign request")
+	errPresigLost = errors.New("a node no longer holds the presignature")
+)
+
+type presigStore struct {
+	mu       sync.Mutex
+	ready    map[string][]presigEntry // key_id -> presignatures, oldest first
+	running  *job                     // presign session in progress
+	lastSign time.Time
+	failedAt map[string]time.Time // key_id -> last failed presign session
+	stats    presigStats
+}
+
+type presigEntry struct {
+	ID        string    `json:"id"` // job id of the presign session
+	Parties   []string  `json:"parties"`
+	Threshold int       `json:"threshold"`
+	Created   time.Time `json:"created"`
+}
+
+type presigStats struct {
+	Generated int   `json:"generated"`
+	Taken     int   `json:"taken"`     // used by a sign
+	Fallback  int   `json:"fallback"`  // sign redone in full: a node had lost the presignature
+	Preempted int   `json:"preempted"` // presign cancelled for a sign request
+	Failed    int   `json:"failed"`
+	Discarded int   `json:"discarded"` // expired, or the key's signing set changed
+	LastGenMs int64 `json:"last_gen_ms"`
+}
+
+// fillPresigs keeps every key's pool at -presig-pool, forever.
+func (s *server) fillPresigs() {
+	for {
+		time.Sleep(presigPoll)
+		if keyID, parties, thr := s.presigWanted(); keyID != "" {
+			s.presign(keyID, parties, thr)
+		}
+	}
+}
+
+// presigIdleNow reports whether no sign request came for -presig-idle.
+func (s *server) presigIdleNow() bool {
+	s.presig.mu.Lock()
+	defer s.presig.mu.Unlock()
+	return time.Since(s.presig.lastSign) >= *presigIdle
+}
+
+// presigWanted picks the key with the fewest presignatures below
+// -presig-pool; "" when every pool is full or the gateway is busy signing.
+func (s *server) presigWanted() (string, []string, int) {
+	if !s.presigIdleNow() {
+		return "", nil, 0
+	}
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.keys))
+	for id, k := range s.keys {
+		if k.Address != "" {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(ids)
+
+	best, bestN := "", *presigPool
+	for _, id := range ids {
+		parties, thr := s.signingSet(id)
+		p := &s.presig
+		p.mu.Lock()
+		n := len(p.freshLocked(id, parties, thr))
+		backoff := time.Since(p.failedAt[id]) < presigRetry
+		p.mu.Unlock()
+		if n < bestN && !backoff {
+			best, bestN = id, n
+		}
+	}
+	if best == "" {
+		return "", nil, 0
+	}
+	parties, thr := s.signingSet(best)
+	return best, parties, thr
+}
+
+// freshLocked drops keyID's presignatures that expired or were made for
+// another signing set and returns the rest; p.mu must be held.
+func (p *presigStore) freshLocked(keyID string, parties []string, thr int) []presigEntry {
+	kept := p.ready[keyID][:0]
+	for _, e := range p.ready[keyID] {
+		if time.Since(e.Created) > *presigTTL || e.Threshold != thr || strings.Join(e.Parties, ",") != strings.Join(parties, ",") {
+			p.stats.Discarded++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	p.ready[keyID] = kept
+	return kept
+}
+
+// presign runs one presign session for keyID and adds the result to the pool.
+func (s *server) presign(keyID string, parties []string, thr int) {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+	// a sign request may have come in while waiting for reqMu
+	if !s.presigIdleNow() || s.reshareBlocksSigning(keyID) != nil {
+		return
+	}
+	p := &s.presig
+	j := s.startJobCtx(context.Background(), "presig-filler", "presign", keyID, parties, *signTimeout)
+	defer s.endJob(j)
+	p.mu.Lock()
+	p.running = j
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.running = nil
+		p.mu.Unlock()
+	}()
+
+	cmd := j.msg()
+	cmd.Threshold = thr
+	_ = s.sendCmd(cmd)
+
+	results := map[string]tssnet.WSMessage{}
+	for len(results) < len(parties) {
+		select {
+		case m := <-s.in:
+			if m.Cmd != "presign_result" || m.Job != j.ID || !containsStr(parties, m.Party) {
+				continue
+			}
+			if !m.Ok {
+				s.sessionFailed("presign", keyID, m)
+				s.cancelParties(j, pendingParties(parties, results, m.Party))
+				s.presigFailed(keyID)
+				return
+			}
+			results[m.Party] = m
+		case <-j.ctx.Done():
+			if errors.Is(context.Cause(j.ctx), errPreempted) {
+				stopped, noAnswer := s.cancelParties(j, pendingParties(parties, results, ""))
+				log.Printf("presign job %s (key %s) preempted by a sign request; stopped=%v no_answer=%v", j.ID, keyID, stopped, noAnswer)
+				p.mu.Lock()
+				p.stats.Preempted++
+				p.mu.Unlock()
+				return
+			}
+			_ = s.stopJob(j, results)
+			s.presigFailed(keyID)
+			return
+		}
+	}
+
+	took := time.Since(j.Started)
+	p.mu.Lock()
+	p.ready[keyID] = append(p.ready[keyID], presigEntry{ID: j.ID, Parties: parties, Threshold: thr, Created: time.Now().UTC()})
+	p.stats.Generated++
+	p.stats.LastGenMs = took.Milliseconds()
+	n := len(p.ready[keyID])
+	p.mu.Unlock()
+	log.Printf("presign key %s: job %s ready in %s (%d/%d in pool)", keyID, j.ID, took.Round(time.Millisecond), n, *presigPool)
+}
+
+// pendingParties lists the parties that have not answered, besides skip.
+func pendingParties(parties []string, answered map[string]tssnet.WSMessage, skip string) []string {
+	var out []string
+	for _, p := range parties {
+		if _, ok := answered[p]; !ok && p != skip {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (s *server) presigFailed(keyID string) {
+	s.presig.mu.Lock()
+	defer s.presig.mu.Unlock()
+	s.presig.stats.Failed++
+	s.presig.failedAt[keyID] = time.Now()
+}
+
+// preemptPresign is called by every sign request before it queues for
+// reqMu: it stops the presign session in progress and holds the filler off.
+func (s *server) preemptPresign() {
+	p := &s.presig
+	p.mu.Lock()
+	p.lastSign = time.Now()
+	j := p.running
+	p.mu.Unlock()
+	if j != nil {
+		j.cancel(errPreempted)
+	}
+}
+
+// signDone restarts the -presig-idle wait after a sign request.
+func (s *server) signDone() {
+	s.presig.mu.Lock()
+	s.presig.lastSign = time.Now()
+	s.presig.mu.Unlock()
+}
+
+// takePresig removes and returns the oldest presignature for keyID and this
+// signing set; "" if there is none.
+func (s *server) takePresig(keyID string, parties []string, thr int) string {
+	p := &s.presig
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fresh := p.freshLocked(keyID, parties, thr)
+	if len(fresh) == 0 {
+		return ""
+	}
+	p.ready[keyID] = fresh[1:]
+	p.stats.Taken++
+	return fresh[0].ID
+}
+
+// presigLost drops keyID's pool after a node did not know a presignature
+// (e.g. it restarted): the others are most likely gone too.
+func (s *server) presigLost(keyID string) {
+	p := &s.presig
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Fallback++
+	p.stats.Discarded += len(p.ready[keyID])
+	delete(p.ready, keyID)
+}
+
+// dropPresigs forgets keyID's presignatures (its shares changed).
+func (s *server) dropPresigs(keyID string) {
+	p := &s.presig
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Discarded += len(p.ready[keyID])
+	delete(p.ready, keyID)
+}
+
+// handlePresigs reports the pool: GET /presigs.
+func (s *server) handlePresigs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p := &s.presig
+	p.mu.Lock()
+	keys := map[string][]presigEntry{}
+	for id, list := range p.ready {
+		keys[id] = append([]presigEntry{}, list...)
+	}
+	running := ""
+	if p.running != nil {
+		running = p.running.ID
+	}
+	stats := p.stats
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "target": *presigPool, "ttl_s": int64(presigTTL.Seconds()), "running": running, "keys": keys, "stats": stats})
+}
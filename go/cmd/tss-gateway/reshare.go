@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// handleReshare moves a key to a new committee and/or threshold; the public
+// key and address stay the same. Steps: every node in old ∪ new runs the
+// tss-lib resharing protocol and new parties stage their share; the gateway
+// checks that all new parties agree on the public key and public shares;
+// then new parties commit (keeping the old share as .prev) and, once all of
+// them have, old-only parties delete their share and new parties drop .prev.
+// A reshare that stops halfway stays pending (also across restarts) until
+// /reshare/finish completes it or /reshare/rollback undoes it.
+func (s *server) handleReshare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		KeyID        string   `json:"key_id"`
+		NewParties   []string `json:"new_parties"`
+		NewThreshold int      `json:"new_threshold"`
+		PreParams    string   `json:"preparams"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad json: " + err.Error()})
+		return
+	}
+	keyID, err := requestKeyID(r, req.KeyID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	k, ok := s.getKey(keyID)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{"ok": false, "err": "unknown key", "key_id": keyID})
+		return
+	}
+	newParties := req.NewParties
+	if len(newParties) == 0 {
+		newParties = k.Parties
+	}
+	if err := checkParties(newParties); err != nil {
+		writeErr(w, err)
+		return
+	}
+	if req.NewThreshold < 1 || req.NewThreshold >= len(newParties) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "new_threshold must satisfy 1 <= t < n", "new_threshold": req.NewThreshold, "n": len(newParties)})
+		return
+	}
+	switch req.PreParams {
+	case "", tssnet.PreParamsWarm, tssnet.PreParamsCold:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": `preparams must be "warm" or "cold"`})
+		return
+	}
+
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	if p, ok := s.getReshare(keyID); ok {
+		writeJSON(w, http.StatusConflict, map[string]any{"ok": false, "err": "a reshare of this key is pending; finish or roll it back first", "job": p.Job, "phase": p.Phase})
+		return
+	}
+	oldParties, oldThr := s.signingSet(keyID)
+	oldKeys := k.PartyKeys
+	if len(oldKeys) == 0 {
+		for i := range oldParties {
+			oldKeys = append(oldKeys, strconv.Itoa(i+1))
+		}
+	}
+	// new keys must not collide with old ones: continue after the largest
+	maxOld := new(big.Int)
+	for _, ks := range oldKeys {
+		if v, ok := new(big.Int).SetString(ks, 10); ok && v.Cmp(maxOld) > 0 {
+			maxOld = v
+		}
+	}
+	newKeys := make([]string, len(newParties))
+	for i := range newParties {
+		newKeys[i] = new(big.Int).Add(maxOld, big.NewInt(int64(i+1))).String()
+	}
+	spec := tssnet.ReshareSpec{PubKeyHex: k.PubKey, OldParties: oldParties, OldKeys: oldKeys, OldThreshold: oldThr, NewParties: newParties, NewKeys: newKeys, NewThreshold: req.NewThreshold}
+
+	if req.PreParams == tssnet.PreParamsWarm {
+		if notReady := s.poolsNotReady(newParties); len(notReady) > 0 {
+			writeJSON(w, http.StatusConflict, map[string]any{"ok": false, "err": "pre-params not ready", "parties": notReady})
+			return
+		}
+	}
+
+	all := spec.AllParties()
+
+	log.Printf("reshare key %s: %v t=%d -> %v t=%d", keyID, oldParties, oldThr, newParties, req.NewThreshold)
+	// only the protocol run is a cancellable job; commit and retire are not
+	j := s.startJob(r, "reshare", keyID, all, *keygenTimeout)
+	defer s.endJob(j)
+	job := j.ID
+	start := time.Now()
+	cmd := j.msg()
+	cmd.PreParams, cmd.Payload = req.PreParams, tssnet.MustJSON(spec)
+	_ = s.sendCmd(cmd)
+	results, missing := s.collectResults(j.ctx, "reshare_result", job, all)
+	for _, p := range all {
+		if m, ok := results[p]; ok && !m.Ok {
+			s.abortReshare(job, keyID, all)
+			writeErr(w, newAPIError(http.StatusBadGateway, "party failed", append(s.sessionFailed("reshare", keyID, m), "detail", m.Err)...))
+			return
+		}
+	}
+	if len(missing) > 0 {
+		err := s.stopJob(j, results)
+		s.abortReshare(job, keyID, all)
+		writeErr(w, err)
+		return
+	}
+	s.endJob(j)
+
+	// every new party must hold a share of the same key with the same public shares
+	var refHash string
+	for _, p := range newParties {
+		m := results[p]
+		var rep tssnet.ReshareReport
+		_ = json.Unmarshal(m.Payload, &rep)
+		bad := ""
+		switch {
+		case !strings.EqualFold(rep.PubKeyHex, k.PubKey) || !strings.EqualFold(m.AddrHex, k.Address):
+			bad = "public key changed"
+		case rep.BigXjHash == "":
+			bad = "no public shares reported"
+		case refHash != "" && rep.BigXjHash != refHash:
+			bad = "public shares differ between new parties"
+		}
+		if bad != "" {
+			log.Printf("reshare key %s: %s: %s", keyID, p, bad)
+			s.abortReshare(job, keyID, all)
+			writeJSON(w, http.StatusBadGateway, map[string]any{"ok": false, "err": bad, "party": p})
+			return
+		}
+		refHash = rep.BigXjHash
+	}
+	took := time.Since(start)
+
+	p := pendingReshare{KeyID: keyID, Job: job, Phase: reshareCommit, Spec: spec, Old: k}
+	s.putReshare(p)
+	out, err := s.completeReshare(p)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	for f, v := range map[string]any{
+		"key_id":        keyID,
+		"address":       k.Address,
+		"pubkey":        k.PubKey,
+		"old_parties":   oldParties,
+		"old_threshold": oldThr,
+		"new_parties":   newParties,
+		"new_threshold": req.NewThreshold,
+		"t_reshare_ms":  took.Milliseconds(),
+		"telemetry":     s.telemetryReport(j.Started, results),
+	} {
+		out[f] = v
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// Phases of a pending reshare, as the gateway sees it.
+const (
+	reshareCommit  = "commit"  // new shares not committed on every new party; signing is blocked
+	reshareCleanup = "cleanup" // new shares in use; old shares not deleted everywhere yet
+)
+
+// pendingReshare is a reshare that is not finished on every node. It is
+// persisted in -keys-file with the key metadata.
+type pendingReshare struct {
+	KeyID string             `json:"key_id"`
+	Job   string             `json:"job"`
+	Phase string             `json:"phase"`
+	Spec  tssnet.ReshareSpec `json:"spec"`
+	Old   keyInfo            `json:"old"` // restored by a rollback
+}
+
+func (s *server) getReshare(keyID string) (pendingReshare, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.reshares[keyID]
+	return p, ok
+}
+
+func (s *server) putReshare(p pendingReshare) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reshares[p.KeyID] = p
+	s.saveKeysLocked()
+}
+
+func (s *server) dropReshare(keyID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reshares, keyID)
+	s.saveKeysLocked()
+}
+
+// reshareBlocksSigning refuses to sign with keyID while new parties of a
+// reshare may hold different shares: some committed, some not.
+func (s *server) reshareBlocksSigning(keyID string) error {
+	if p, ok := s.getReshare(keyID); ok && p.Phase == reshareCommit {
+		return newAPIError(http.StatusConflict, "reshare not committed on every party; finish or roll it back first", "key_id", keyID, "job", p.Job)
+	}
+	return nil
+}
+
+// completeReshare runs the steps of p that are not done yet: commit on every
+// new party, then retire on old-only parties and finalize on new parties. It
+// is safe to repeat; nodes answer ok for steps they already did.
+func (s *server) completeReshare(p pendingReshare) (map[string]any, error) {
+	spec := p.Spec
+	if p.Phase == reshareCommit {
+		if failed := s.reshareStep(p, "reshare_commit", spec.NewParties); len(failed) > 0 {
+			log.Printf("reshare key %s: commit failed on %v", p.KeyID, failed)
+			return nil, newAPIError(http.StatusBadGateway, "commit incomplete; retry with /reshare/finish or undo with /reshare/rollback", "key_id", p.KeyID, "job", p.Job, "parties", failed)
+		}
+		k := p.Old
+		k.Parties, k.Threshold, k.PartyKeys, k.ConfirmedBy = spec.NewParties, spec.NewThreshold, spec.NewKeys, spec.NewParties
+		p.Phase = reshareCleanup
+		s.mu.Lock()
+		s.keys[k.KeyID] = k
+		delete(s.keyConflicts, k.KeyID)
+		s.reshares[p.KeyID] = p
+		s.saveKeysLocked()
+		s.mu.Unlock()
+		s.dropPresigs(p.KeyID)
+	}
+
+	var oldOnly []string
+	for _, q := range spec.OldParties {
+		if !containsStr(spec.NewParties, q) {
+			oldOnly = append(oldOnly, q)
+		}
+	}
+	retireFailed := s.reshareStep(p, "reshare_retire", oldOnly)
+	finalizeFailed := s.reshareStep(p, "reshare_finalize", spec.NewParties)
+	pending := len(retireFailed) > 0 || len(finalizeFailed) > 0
+	if pending {
+		log.Printf("WARNING: reshare key %s: old shares not cleaned up (retire failed: %v, finalize failed: %v); retry with /reshare/finish", p.KeyID, retireFailed, finalizeFailed)
+	} else {
+		s.dropReshare(p.KeyID)
+	}
+	return map[string]any{
+		"ok":              true,
+		"key_id":          p.KeyID,
+		"job":             p.Job,
+		"retired":         oldOnly,
+		"retire_failed":   retireFailed,
+		"finalize_failed": finalizeFailed,
+		"pending":         pending,
+	}, nil
+}
+
+// reshareStep sends cmd for p to parties and returns those that did not
+// confirm it within a minute.
+func (s *server) reshareStep(p pendingReshare, cmd string, parties []string) []string {
+	if len(parties) == 0 {
+		return nil
+	}
+	_ = s.sendCmd(tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *gatewayParty, Cmd: cmd, Job: p.Job, KeyID: p.KeyID, Parties: parties})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var failed []string
+	rest := parties
+	// collectResults stops at the first failure; keep going for the others
+	for len(rest) > 0 && ctx.Err() == nil {
+		var got map[string]tssnet.WSMessage
+		got, rest = s.collectResults(ctx, cmd+"_result", p.Job, rest)
+		for q, m := range got {
+			if !m.Ok {
+				log.Printf("reshare key %s: %s on %s: %s", p.KeyID, cmd, q, m.Err)
+				failed = append(failed, q)
+			}
+		}
+	}
+	failed = append(failed, rest...)
+	sort.Strings(failed)
+	return failed
+}
+
+// handleReshareFinish retries the unfinished steps of a pending reshare.
+func (s *server) handleReshareFinish(w http.ResponseWriter, r *http.Request) {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+	p, ok := s.pendingFromRequest(w, r)
+	if !ok {
+		return
+	}
+	out, err := s.completeReshare(p)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleReshareRollback undoes a reshare that is not committed on every new
+// party: nodes drop the staged share or put the old one back, and the
+// gateway keeps serving the key with its old committee.
+func (s *server) handleReshareRollback(w http.ResponseWriter, r *http.Request) {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+	p, ok := s.pendingFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if p.Phase != reshareCommit {
+		writeJSON(w, http.StatusConflict, map[string]any{"ok": false, "err": "reshare is committed on every new party; use /reshare/finish", "job": p.Job})
+		return
+	}
+	if failed := s.reshareStep(p, "reshare_abort", p.Spec.AllParties()); len(failed) > 0 {
+		writeJSON(w, http.StatusBadGateway, map[string]any{"ok": false, "err": "rollback incomplete; retry", "job": p.Job, "parties": failed})
+		return
+	}
+	s.mu.Lock()
+	s.keys[p.KeyID] = p.Old
+	delete(s.reshares, p.KeyID)
+	s.saveKeysLocked()
+	s.mu.Unlock()
+	s.dropPresigs(p.KeyID)
+	log.Printf("reshare key %s: job %s rolled back", p.KeyID, p.Job)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "key_id": p.KeyID, "job": p.Job, "parties": p.Old.Parties, "threshold": p.Old.Threshold})
+}
+
+// pendingFromRequest looks up the reshare named by the request; on false the
+// response is written. reqMu must be held.
+func (s *server) pendingFromRequest(w http.ResponseWriter, r *http.Request) (pendingReshare, bool) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return pendingReshare{}, false
+	}
+	var req struct {
+		KeyID string `json:"key_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad json: " + err.Error()})
+		return pendingReshare{}, false
+	}
+	keyID, err := requestKeyID(r, req.KeyID)
+	if err != nil {
+		writeErr(w, err)
+		return pendingReshare{}, false
+	}
+	p, ok := s.getReshare(keyID)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{"ok": false, "err": "no reshare pending", "key_id": keyID})
+		return pendingReshare{}, false
+	}
+	return p, true
+}
+
+func (s *server) abortReshare(job, keyID string, parties []string) {
+	_ = s.sendCmd(tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *gatewayParty, Cmd: "reshare_abort", Job: job, KeyID: keyID, Parties: parties})
+}
+
+// collectResults gathers one cmd answer per party for job until all arrived,
+// one failed or ctx ended; missing lists the parties that did not answer.
+func (s *server) collectResults(ctx context.Context, cmd, job string, parties []string) (map[string]tssnet.WSMessage, []string) {
+	got := map[string]tssnet.WSMessage{}
+collect:
+	for len(got) < len(parties) {
+		select {
+		case m := <-s.in:
+			if m.Cmd != cmd || m.Job != job || !containsStr(parties, m.Party) {
+				continue
+			}
+			if _, dup := got[m.Party]; dup {
+				continue
+			}
+			got[m.Party] = m
+			if !m.Ok {
+				break collect
+			}
+		case <-ctx.Done():
+			break collect
+		}
+	}
+	var missing []string
+	for _, p := range parties {
+		if _, ok := got[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	return got, missing
+}
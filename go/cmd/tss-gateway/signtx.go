@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"mp-htlc-lgp/experiment/internal/eth"
+)
+
+// signTxReq carries an unsigned tx either as binary (tx_rlp) or JSON (tx).
+type signTxReq struct {
+	ChainID *big.Int        `json:"chain_id,omitempty"`
+	TxRLP   string          `json:"tx_rlp,omitempty"`
+	Tx      *eth.UnsignedTx `json:"tx,omitempty"`
+	KeyID   string          `json:"key_id,omitempty"`
+	Path    string          `json:"path,omitempty"`
+}
+
+// parseUnsignedTx decodes the request and returns the tx and the chain ID its
+// sighash is computed for.
+func parseUnsignedTx(req signTxReq) (*types.Transaction, *big.Int, error) {
+	var tx *types.Transaction
+	switch {
+	case req.TxRLP != "" && req.Tx != nil:
+		return nil, nil, newAPIError(http.StatusBadRequest, "set only one of tx_rlp or tx")
+	case req.TxRLP != "":
+		raw, err := hexutil.Decode(strings.TrimSpace(req.TxRLP))
+		if err != nil {
+			return nil, nil, newAPIError(http.StatusBadRequest, "bad tx_rlp: "+err.Error())
+		}
+		if tx, err = eth.DecodeUnsignedTx(raw, req.ChainID); err != nil {
+			return nil, nil, newAPIError(http.StatusBadRequest, "bad tx_rlp: "+err.Error())
+		}
+	case req.Tx != nil:
+		var err error
+		if tx, err = req.Tx.Transaction(req.ChainID); err != nil {
+			return nil, nil, newAPIError(http.StatusBadRequest, "bad tx: "+err.Error())
+		}
+	default:
+		return nil, nil, newAPIError(http.StatusBadRequest, "missing tx_rlp or tx")
+	}
+	chainID, err := eth.SigningChainID(tx, req.ChainID)
+	if err != nil {
+		return nil, nil, newAPIError(http.StatusBadRequest, err.Error())
+	}
+	return tx, chainID, nil
+}
+
+func (s *server) handleSignTx(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req signTxReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad json: " + err.Error()})
+		return
+	}
+	tx, chainID, err := parseUnsignedTx(req)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	keyID, err := requestKeyID(r, req.KeyID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	path, err := requestPath(r, req.Path)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if s.policy != nil {
+		if violations := s.policy.check(chainID, tx); len(violations) > 0 {
+			log.Printf("signTx rejected by policy: key=%s to=%v chain=%s violations=%q", keyID, tx.To(), chainID, violations)
+			writeErr(w, newAPIError(http.StatusForbidden, "policy violation", "violations", violations))
+			return
+		}
+	}
+	signer := types.LatestSignerForChainID(chainID)
+	sighash := signer.Hash(tx)
+	sig65, sig, from, err := s.signEthereum(r, keyID, path, sighash.Bytes())
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	signed, err := tx.WithSignature(signer, sig65)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	v, rr, ss := signed.RawSignatureValues()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":        true,
+		"key_id":    keyID,
+		"path":      sig.Path,
+		"raw":       hex0x(raw),
+		"hash":      signed.Hash().Hex(),
+		"sighash":   sighash.Hex(),
+		"from":      from.Hex(),
+		"chain_id":  chainID.String(),
+		"v":         hexutil.EncodeBig(v),
+		"r":         hexutil.EncodeBig(rr),
+		"s":         hexutil.EncodeBig(ss),
+		"party":     sig.Party,
+		"t_sign_ms": sig.Took.Milliseconds(),
+		"presig":    sig.Presig,
+		"telemetry": sig.Telemetry,
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// telemetryReport merges the per-round telemetry of a session's parties into
+// one timeline: times are ms since the job started, on the coordinator's
+// clock (each side's offset is estimated by ping/pong).
+type telemetryReport struct {
+	Clock   string                   `json:"clock"`
+	Parties map[string]partyTimeline `json:"parties"`
+	Rounds  []roundSummary           `json:"rounds"`
+}
+
+type partyTimeline struct {
+	StartMs       int64           `json:"start_ms"`
+	EndMs         int64           `json:"end_ms"`
+	CPUMs         int64           `json:"cpu_ms"`
+	ClockOffsetMs int64           `json:"clock_offset_ms"`
+	ClockRTTMs    int64           `json:"clock_rtt_ms"`
+	ClockSynced   bool            `json:"clock_synced"`
+	Rounds        []roundTimeline `json:"rounds"`
+}
+
+type roundTimeline struct {
+	Round      int    `json:"round"`
+	StartMs    *int64 `json:"start_ms"` // null: the party only received in this round
+	EndMs      *int64 `json:"end_ms"`
+	DurMs      int64  `json:"dur_ms"`
+	CPUMs      int64  `json:"cpu_ms"`
+	MsgsSent   int    `json:"msgs_sent"`
+	BytesSent  int    `json:"bytes_sent"`
+	MsgsRecv   int    `json:"msgs_recv"`
+	BytesRecv  int    `json:"bytes_recv"`
+	LastRecvMs *int64 `json:"last_recv_ms,omitempty"`
+}
+
+// roundSummary is one round across all parties.
+type roundSummary struct {
+	Round     int    `json:"round"`
+	StartMs   int64  `json:"start_ms"` // first party to start it
+	EndMs     int64  `json:"end_ms"`   // last party to finish it
+	MaxDurMs  int64  `json:"max_dur_ms"`
+	Slowest   string `json:"slowest"`
+	MaxCPUMs  int64  `json:"max_cpu_ms"`
+	BytesSent int    `json:"bytes_sent"`
+}
+
+// telemetryReport builds the report for a job started at started from the
+// parties' *_result messages; nil when no party sent telemetry.
+func (s *server) telemetryReport(started time.Time, results map[string]tssnet.WSMessage) *telemetryReport {
+	gwOffset, _, _ := s.ws.ClockOffset()
+	base := started.Add(gwOffset).UnixMilli()
+	rep := &telemetryReport{Clock: "coordinator", Parties: map[string]partyTimeline{}}
+	sums := map[int]*roundSummary{}
+	for party, m := range results {
+		t := m.Telemetry
+		if t == nil {
+			continue
+		}
+		rel := func(ms int64) int64 { return ms + t.ClockOffsetMs - base }
+		relp := func(ms int64) *int64 {
+			if ms == 0 {
+				return nil
+			}
+			v := rel(ms)
+			return &v
+		}
+		pt := partyTimeline{StartMs: rel(t.StartMs), EndMs: rel(t.EndMs), CPUMs: t.CPUMs, ClockOffsetMs: t.ClockOffsetMs, ClockRTTMs: t.ClockRTTMs, ClockSynced: t.ClockSynced}
+		for _, r := range t.Rounds {
+			rt := roundTimeline{Round: r.Round, StartMs: relp(r.StartMs), EndMs: relp(r.EndMs), CPUMs: r.CPUMs, MsgsSent: r.MsgsSent, BytesSent: r.BytesSent, MsgsRecv: r.MsgsRecv, BytesRecv: r.BytesRecv, LastRecvMs: relp(r.LastRecvMs)}
+			sum := sums[r.Round]
+			if sum == nil {
+				sum = &roundSummary{Round: r.Round, StartMs: -1}
+				sums[r.Round] = sum
+			}
+			sum.BytesSent += r.BytesSent
+			if rt.StartMs != nil && rt.EndMs != nil {
+				rt.DurMs = *rt.EndMs - *rt.StartMs
+				if sum.StartMs < 0 || *rt.StartMs < sum.StartMs {
+					sum.StartMs = *rt.StartMs
+				}
+				if *rt.EndMs > sum.EndMs {
+					sum.EndMs = *rt.EndMs
+				}
+				if rt.DurMs >= sum.MaxDurMs {
+					sum.MaxDurMs, sum.Slowest = rt.DurMs, party
+				}
+			}
+			if r.CPUMs > sum.MaxCPUMs {
+				sum.MaxCPUMs = r.CPUMs
+			}
+			pt.Rounds = append(pt.Rounds, rt)
+		}
+		rep.Parties[party] = pt
+	}
+	if len(rep.Parties) == 0 {
+		return nil
+	}
+	for _, sum := range sums {
+		if sum.StartMs < 0 {
+			sum.StartMs = 0
+		}
+		rep.Rounds = append(rep.Rounds, *sum)
+	}
+	sort.Slice(rep.Rounds, func(a, b int) bool { return rep.Rounds[a].Round < rep.Rounds[b].Round })
+	return rep
+}
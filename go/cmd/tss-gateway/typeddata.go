@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+
+	"mp-htlc-lgp/experiment/internal/eth"
+	"mp-htlc-lgp/experiment/internal/hdkey"
+)
+
+// signEthereum signs digest with cluster key keyID (or its child at path) and
+// returns r||s||v with v = 0/1, checked by recovering the signing address.
+func (s *server) signEthereum(r *http.Request, keyID string, path hdkey.Path, digest []byte) ([]byte, *sigResult, common.Address, error) {
+	from, err := s.keyAddress(keyID, path)
+	if err != nil {
+		return nil, nil, common.Address{}, err
+	}
+	sig, err := s.signDigest(r, keyID, path, digest)
+	if err != nil {
+		return nil, nil, from, err
+	}
+	sig65 := sig.sig65()
+	if pub, err := crypto.SigToPub(digest, sig65); err != nil || crypto.PubkeyToAddress(*pub) != from {
+		return nil, nil, from, newAPIError(http.StatusBadGateway, "signature does not recover to key address", "address", from.Hex())
+	}
+	return sig65, sig, from, nil
+}
+
+// writeSig65 answers with a 65-byte signature whose v is 27/28.
+func writeSig65(w http.ResponseWriter, keyID string, digest common.Hash, sig65 []byte, sig *sigResult, from common.Address) {
+	out := make([]byte, 65)
+	copy(out, sig65)
+	out[64] += 27
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":        true,
+		"key_id":    keyID,
+		"path":      sig.Path,
+		"signature": hex0x(out),
+		"digest":    digest.Hex(),
+		"address":   from.Hex(),
+		"r":         hex0x(out[0:32]),
+		"s":         hex0x(out[32:64]),
+		"v":         out[64],
+		"party":     sig.Party,
+		"parties":   sig.Parties,
+		"t_sign_ms": sig.Took.Milliseconds(),
+		"presig":    sig.Presig,
+		"telemetry": sig.Telemetry,
+	})
+}
+
+func (s *server) handleSignTypedData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var td apitypes.TypedData
+	if err := json.NewDecoder(r.Body).Decode(&td); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad json: " + err.Error()})
+		return
+	}
+	// the body is the typed data itself, so the key comes from ?key_id= and ?path=
+	keyID, err := requestKeyID(r, "")
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	path, err := requestPath(r, "")
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	digest, err := eth.TypedDataHash(td)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad typed data: " + err.Error()})
+		return
+	}
+	msg, _ := json.Marshal(td.Message)
+	if s.policy != nil {
+		if violations := s.policy.checkTypedData(td); len(violations) > 0 {
+			log.Printf("signTypedData rejected by policy: key=%s domain=%s contract=%s primaryType=%s violations=%q",
+				keyID, td.Domain.Name, td.Domain.VerifyingContract, td.PrimaryType, violations)
+			writeErr(w, newAPIError(http.StatusForbidden, "policy violation", "violations", violations))
+			return
+		}
+	}
+	log.Printf("signTypedData key=%s domain=%s/%s chainId=%v contract=%s primaryType=%s message=%s digest=%s",
+		keyID, td.Domain.Name, td.Domain.Version, td.Domain.ChainId, td.Domain.VerifyingContract, td.PrimaryType, msg, digest.Hex())
+
+	sig65, sig, from, err := s.signEthereum(r, keyID, path, digest.Bytes())
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeSig65(w, keyID, digest, sig65, sig, from)
+}
+
+func (s *server) handlePersonalSign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.policy != nil && !s.policy.AllowPersonalSign {
+		writeJSON(w, http.StatusForbidden, map[string]any{"ok": false, "err": "policy: /personalSign disabled"})
+		return
+	}
+	var req struct {
+		Message    string `json:"message"`
+		MessageHex string `json:"message_hex"`
+		KeyID      string `json:"key_id"`
+		Path       string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad json: " + err.Error()})
+		return
+	}
+	msg := []byte(req.Message)
+	if req.MessageHex != "" {
+		b, err := hexutil.Decode(strings.TrimSpace(req.MessageHex))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "bad message_hex: " + err.Error()})
+			return
+		}
+		msg = b
+	}
+	if len(msg) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "missing message or message_hex"})
+		return
+	}
+	keyID, err := requestKeyID(r, req.KeyID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	path, err := requestPath(r, req.Path)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	digest := eth.PersonalHash(msg)
+	log.Printf("personalSign key=%s len=%d message=%q digest=%s", keyID, len(msg), msg, digest.Hex())
+
+	sig65, sig, from, err := s.signEthereum(r, keyID, path, digest.Bytes())
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeSig65(w, keyID, digest, sig65, sig, from)
+}
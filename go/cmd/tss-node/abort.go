@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/bnb-chain/tss-lib/v2/tss"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// sessionAbort ends the running session early: either tss-lib reported an
+// error on this node, or a peer broadcast an "abort" for the same session.
+type sessionAbort struct {
+	Round    int
+	Culprits []string // node names (reshare "/new" suffix stripped)
+	Reporter string   // node that saw the error
+	Cause    string
+}
+
+func (a *sessionAbort) Error() string {
+	s := "aborted by " + a.Reporter
+	if a.Round > 0 {
+		s += fmt.Sprintf(" in round %d", a.Round)
+	}
+	if len(a.Culprits) > 0 {
+		s += fmt.Sprintf(", culprits %v", a.Culprits)
+	}
+	return s + ": " + a.Cause
+}
+
+func abortFromTSS(err *tss.Error) *sessionAbort {
+	a := &sessionAbort{Round: err.Round(), Reporter: *partyStr, Cause: err.Error()}
+	if err.Cause() != nil {
+		a.Cause = err.Cause().Error()
+	}
+	for _, p := range err.Culprits() {
+		if p == nil {
+			continue
+		}
+		name := strings.TrimSuffix(p.Id, newIDSuffix)
+		if !slices.Contains(a.Culprits, name) {
+			a.Culprits = append(a.Culprits, name)
+		}
+	}
+	return a
+}
+
+// fail hands err to the running session; only the first abort counts.
+func (rt *runtime) fail(err error) bool {
+	rt.mu.Lock()
+	ch := rt.abort
+	rt.mu.Unlock()
+	return stopSession(ch, err)
+}
+
+// stopSession delivers err on a session's abort channel unless one is
+// already pending.
+func stopSession(ch chan error, err error) bool {
+	if ch == nil {
+		return false
+	}
+	select {
+	case ch <- err:
+		return true
+	default:
+		return false
+	}
+}
+
+// tssFailed handles an error returned by tss-lib (Start or UpdateFromBytes):
+// the local session stops and every other session party is told to stop too,
+// so nobody waits for a round that will never complete.
+func (rt *runtime) tssFailed(c *tssnet.Conn, err *tss.Error) {
+	a := abortFromTSS(err)
+	log.Printf("tss error: round=%d culprits=%v: %v", a.Round, a.Culprits, err)
+	if !rt.fail(a) {
+		return
+	}
+	rt.mu.Lock()
+	job, keyID := rt.job, rt.keyID
+	var peers []string
+	for _, p := range rt.peers {
+		if p != *partyStr {
+			peers = append(peers, p)
+		}
+	}
+	rt.mu.Unlock()
+	if len(peers) > 0 {
+		writeWS(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *partyStr, Parties: peers, Cmd: "abort", Job: job, KeyID: keyID, Round: a.Round, Culprits: a.Culprits, Err: a.Cause})
+	}
+}
+
+// peerAbort stops the running session when a peer aborted it.
+func (rt *runtime) peerAbort(m tssnet.WSMessage) {
+	rt.mu.Lock()
+	match := rt.busy && rt.abort != nil && rt.job == m.Job && rt.keyID == m.KeyID && slices.Contains(rt.peers, m.Party)
+	rt.mu.Unlock()
+	if !match {
+		return
+	}
+	if rt.fail(&sessionAbort{Round: m.Round, Culprits: m.Culprits, Reporter: m.Party, Cause: m.Err}) {
+		log.Printf("session for key %s aborted by %s (round %d, culprits %v)", m.KeyID, m.Party, m.Round, m.Culprits)
+	}
+}
+
+// failResult is the *_result sent to the gateway when a session fails.
+func failResult(cmd, keyID string, err error) tssnet.WSMessage {
+	m := tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *partyStr, Parties: []string{*gatewayParty}, Cmd: cmd, KeyID: keyID, Ok: false, Err: errString(err)}
+	var a *sessionAbort
+	if errors.As(err, &a) {
+		m.Round, m.Culprits = a.Round, a.Culprits
+	}
+	return m
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/crypto"
+
+	"mp-htlc-lgp/experiment/internal/apiauth"
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+var (
+	adminListen   = flag.String("admin-listen", "", "address of the status/admin HTTP listener, e.g. 127.0.0.1:9300 (empty = off)")
+	adminAuthKeys = flag.String("admin-auth-keys", "", "API keys file for the admin listener (empty = unauthenticated)")
+	adminTLSCert  = flag.String("admin-tls-cert", "", "TLS certificate file for the admin listener (enables HTTPS)")
+	adminTLSKey   = flag.String("admin-tls-key", "", "TLS private key file for the admin listener")
+)
+
+// startedAt is when the process started, for the status uptime.
+var startedAt = time.Now()
+
+// nodeError is the last session that failed on this node.
+type nodeError struct {
+	At    time.Time `json:"at"`
+	Cmd   string    `json:"cmd"`
+	Job   string    `json:"job,omitempty"`
+	KeyID string    `json:"key_id,omitempty"`
+	Err   string    `json:"err"`
+}
+
+// sessionInfo describes the running session on the status page.
+type sessionInfo struct {
+	Job       string    `json:"job"`
+	Cmd       string    `json:"cmd"`
+	KeyID     string    `json:"key_id"`
+	Peers     []string  `json:"peers"`
+	Started   time.Time `json:"started"`
+	RunningMs int64     `json:"running_ms"`
+}
+
+// keyStatus is a stored share as shown on the status page (no secrets).
+type keyStatus struct {
+	KeyID     string    `json:"key_id"`
+	PubKey    string    `json:"pubkey"`
+	Address   string    `json:"address"`
+	Parties   []string  `json:"parties,omitempty"`
+	Threshold int       `json:"threshold,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Sealed    bool      `json:"sealed"`
+}
+
+// serveAdmin runs the -admin-listen listener until the process exits.
+func serveAdmin(rt *runtime, c *tssnet.Conn) {
+	var auth *apiauth.Authenticator
+	if *adminAuthKeys != "" {
+		a, err := apiauth.Load(*adminAuthKeys)
+		if err != nil {
+			log.Fatalf("admin auth keys: %v", err)
+		}
+		auth = a
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200); w.Write([]byte("ok")) })
+	mux.HandleFunc("/status", auth.Require(apiauth.ScopeAny, func(w http.ResponseWriter, r *http.Request) { rt.handleStatus(w, r, c) }))
+	// opens the shares: admin only
+	mux.HandleFunc("/selfcheck", auth.Require(apiauth.ScopeAdmin, handleSelfCheck))
+	log.Printf("admin listening on %s (tls=%v auth=%v)", *adminListen, *adminTLSCert != "", auth != nil)
+	log.Fatal(apiauth.ListenAndServe(*adminListen, mux, *adminTLSCert, *adminTLSKey, ""))
+}
+
+// handleStatus reports what the node is doing: GET /status.
+func (rt *runtime) handleStatus(w http.ResponseWriter, r *http.Request, c *tssnet.Conn) {
+	rt.mu.Lock()
+	busy, stats, lastErr := rt.busy, rt.stats, rt.lastErr
+	var session *sessionInfo
+	if busy {
+		session = &sessionInfo{Job: rt.job, Cmd: rt.cmd, KeyID: rt.keyID, Peers: rt.peers}
+		if rt.trace != nil {
+			session.Started = rt.trace.start
+			session.RunningMs = time.Since(rt.trace.start).Milliseconds()
+		}
+	}
+	rt.mu.Unlock()
+
+	out := map[string]any{
+		"ok":          true,
+		"party":       *partyStr,
+		"session":     *clusterSession,
+		"coordinator": c.State(),
+		"busy":        busy,
+		"job":         session,
+		"preparams":   pool.status(),
+		"presigs":     rt.presigCount(),
+		"wire":        stats,
+		"last_error":  lastErr,
+		"started_at":  startedAt.UTC(),
+		"uptime_s":    int64(time.Since(startedAt).Seconds()),
+	}
+	shares, err := listShares(*dataDir)
+	keys := make([]keyStatus, 0, len(shares))
+	for _, f := range shares {
+		keys = append(keys, keyStatus{KeyID: f.KeyID, PubKey: f.PubKeyHex, Address: f.Address, Parties: f.Parties, Threshold: f.Threshold, CreatedAt: f.CreatedAt, Sealed: f.Sealed != nil})
+	}
+	out["keys"] = keys
+	if err != nil {
+		out["keys_err"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// selfCheck is the result of checking one stored share.
+type selfCheck struct {
+	KeyID   string            `json:"key_id"`
+	Address string            `json:"address"`
+	Ok      bool              `json:"ok"`
+	Checks  map[string]string `json:"checks"` // name -> "ok" or what is wrong
+}
+
+// handleSelfCheck opens the stored shares and checks that each still matches
+// its public key and address: GET /selfcheck[?key_id=…&address=0x…].
+// address is the address the caller expects (e.g. from the gateway's /keys).
+func handleSelfCheck(w http.ResponseWriter, r *http.Request) {
+	keyID, want := r.URL.Query().Get("key_id"), r.URL.Query().Get("address")
+	if want != "" && keyID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "err": "address needs key_id"})
+		return
+	}
+	var ids []string
+	if keyID != "" {
+		if !shareExists(*dataDir, keyID) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"ok": false, "err": "unknown key", "key_id": keyID})
+			return
+		}
+		ids = []string{keyID}
+	} else {
+		shares, err := listShares(*dataDir)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"ok": false, "err": err.Error()})
+			return
+		}
+		for _, f := range shares {
+			ids = append(ids, f.KeyID)
+		}
+	}
+
+	results := make([]selfCheck, 0, len(ids))
+	var failed []string
+	for _, id := range ids {
+		res := checkShare(id, want)
+		if !res.Ok {
+			failed = append(failed, id)
+		}
+		results = append(results, res)
+	}
+	if len(failed) > 0 {
+		log.Printf("self-check failed for %v", failed)
+		writeJSON(w, http.StatusConflict, map[string]any{"ok": false, "err": fmt.Sprintf("self-check failed for %v", failed), "party": *partyStr, "keys": results})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "party": *partyStr, "keys": results})
+}
+
+// checkShare loads keyID and checks, each reported separately:
+//   - load: the file decodes (and unseals) with this node's share key
+//   - pubkey: the share's ECDSA public key is the recorded pubkey_hex
+//   - address: that public key hashes to the recorded address
+//   - expected_address: it is the address the caller expects
+//   - public_share: this node's secret share times G is its public share X_i
+//   - party: this node is one of the key's parties
+func checkShare(keyID, want string) selfCheck {
+	res := selfCheck{KeyID: keyID, Checks: map[string]string{}}
+	f, err := loadShare(*dataDir, keyID)
+	if err != nil {
+		res.Checks["load"] = err.Error()
+		return res
+	}
+	res.Address = f.Address
+	res.Checks["load"] = "ok"
+	check := func(name string, ok bool, bad string) {
+		if ok {
+			res.Checks[name] = "ok"
+		} else {
+			res.Checks[name] = bad
+		}
+	}
+
+	pub, addr, err := pubAndAddr(f.Share)
+	if err != nil {
+		res.Checks["pubkey"] = err.Error()
+		return res
+	}
+	check("pubkey", strings.EqualFold(pub, f.PubKeyHex), "share holds pubkey "+pub)
+	check("address", strings.EqualFold(addr, f.Address), "pubkey derives "+addr)
+	if want != "" {
+		check("expected_address", strings.EqualFold(addr, want), "share is for "+addr)
+	}
+	check("public_share", ownPublicShare(f), "secret share does not match its public share")
+	if len(f.Parties) > 0 { // legacy and early shares do not record them
+		check("party", slices.Contains(f.Parties, *partyStr), "not in parties "+strings.Join(f.Parties, ","))
+	}
+
+	res.Ok = true
+	for _, v := range res.Checks {
+		if v != "ok" {
+			res.Ok = false
+		}
+	}
+	return res
+}
+
+// ownPublicShare reports whether Xi·G equals this party's entry in BigXj.
+func ownPublicShare(f shareFile) bool {
+	s := f.Share
+	if s.Xi == nil || s.ShareID == nil || s.ECDSAPub == nil {
+		return false
+	}
+	for i, k := range s.Ks {
+		if k != nil && k.Cmp(s.ShareID) == 0 && i < len(s.BigXj) && s.BigXj[i] != nil {
+			return crypto.ScalarBaseMult(s.ECDSAPub.Curve(), s.Xi).Equals(s.BigXj[i])
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/signing"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+
+	"mp-htlc-lgp/experiment/internal/hdkey"
+)
+
+// deriveShare prepares share for signing with the child key at path: it
+// returns a copy whose public key and public shares are the child's, and the
+// delta that signing adds to Xi (NewLocalPartyWithKDD). The stored share is
+// left as it is. An empty path returns share and a nil delta.
+func deriveShare(share keygen.LocalPartySaveData, path string) (keygen.LocalPartySaveData, *big.Int, error) {
+	p, err := hdkey.ParsePath(path)
+	if err != nil || len(p) == 0 {
+		return share, nil, err
+	}
+	if share.ECDSAPub == nil {
+		return share, nil, errors.New("share has no public key")
+	}
+	delta, child, err := hdkey.Derive(share.ECDSAPub.ToECDSAPubKey(), p)
+	if err != nil {
+		return share, nil, err
+	}
+	// UpdatePublicKeyAndAdjustBigXj rewrites BigXj in place
+	share.BigXj = append([]*crypto.ECPoint(nil), share.BigXj...)
+	keys := []keygen.LocalPartySaveData{share}
+	if err := signing.UpdatePublicKeyAndAdjustBigXj(delta, keys, child, tss.S256()); err != nil {
+		return share, nil, err
+	}
+	return keys[0], delta, nil
+}
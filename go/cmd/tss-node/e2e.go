@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+var (
+	e2eOn        = flag.Bool("e2e", false, "encrypt unicast wire messages end to end (needs -identity-key and -registry; every node must agree)")
+	registryPath = flag.String("registry", "", "party registry (the coordinator's -registry file) with the peers' identity keys, for -e2e")
+)
+
+// sealer holds the pairwise keys of -e2e; nil = wire messages go in the clear.
+var sealer *e2eKeys
+
+// e2eTag separates the pairwise keys and the AAD from other uses of the
+// identity keys.
+const e2eTag = "mp-htlc-lgp/tss-p2p/v1"
+
+// e2eKeys derives one AES-256-GCM key per peer from X25519 over the parties'
+// ed25519 identity keys (both mapped to Curve25519), so the coordinator only
+// sees routing metadata of unicast messages. Broadcasts stay in the clear:
+// every party gets them anyway.
+type e2eKeys struct {
+	priv  *ecdh.PrivateKey
+	peers map[string]ed25519.PublicKey // node -> identity key
+
+	mu    sync.Mutex
+	aeads map[string]cipher.AEAD // node -> pairwise key, derived on first use
+}
+
+func newE2EKeys(id ed25519.PrivateKey, peers map[string]ed25519.PublicKey) (*e2eKeys, error) {
+	// the X25519 scalar of an ed25519 key is the first half of SHA-512(seed)
+	h := sha512.Sum512(id.Seed())
+	priv, err := ecdh.X25519().NewPrivateKey(h[:32])
+	if err != nil {
+		return nil, err
+	}
+	return &e2eKeys{priv: priv, peers: peers, aeads: map[string]cipher.AEAD{}}, nil
+}
+
+// curve25519P is 2^255 - 19.
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// montgomeryPub maps an ed25519 public key to X25519: u = (1+y)/(1-y) mod p.
+func montgomeryPub(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("bad identity key")
+	}
+	le := make([]byte, 32)
+	copy(le, pub)
+	le[31] &= 0x7f // sign of x
+	y := new(big.Int).SetBytes(reverse(le))
+	one := big.NewInt(1)
+	if y.Cmp(curve25519P) >= 0 || y.Cmp(one) == 0 {
+		return nil, errors.New("bad identity key")
+	}
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P).ModInverse(den, curve25519P)
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den).Mod(u, curve25519P)
+	return ecdh.X25519().NewPublicKey(reverse(u.FillBytes(make([]byte, 32))))
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// aead returns the key shared with node peer.
+func (e *e2eKeys) aead(peer string) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if a, ok := e.aeads[peer]; ok {
+		return a, nil
+	}
+	id, ok := e.peers[peer]
+	if !ok {
+		return nil, fmt.Errorf("no identity key for %s in -registry", peer)
+	}
+	pub, err := montgomeryPub(id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", peer, err)
+	}
+	shared, err := e.priv.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", peer, err)
+	}
+	// HKDF-SHA256 (one block), salted with the session; the info names the
+	// pair in a fixed order, so both ends derive the same key
+	a, b := *partyStr, peer
+	if b < a {
+		a, b = b, a
+	}
+	prk := hmacSHA256([]byte(*clusterSession), shared)
+	key := hmacSHA256(prk, []byte(e2eTag), []byte{0}, []byte(a), []byte{0}, []byte(b), []byte{1})
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	g, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads[peer] = g
+	return g, nil
+}
+
+func hmacSHA256(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// e2eAAD binds a sealed payload to its routing: a relay cannot move it to
+// another session, job, sender, recipient or committee.
+func e2eAAD(m tssnet.WSMessage, sender, recipient string) []byte {
+	var b []byte
+	for _, s := range []string{e2eTag, m.Session, m.Job, m.MsgID, sender, recipient, m.From, m.Committee} {
+		b = append(append(b, s...), 0)
+	}
+	return b
+}
+
+// seal encrypts the payload of m, which must have exactly one recipient.
+func (e *e2eKeys) seal(m tssnet.WSMessage) (tssnet.WSMessage, error) {
+	to := m.To[0]
+	a, err := e.aead(to)
+	if err != nil {
+		return m, err
+	}
+	plain, err := base64.StdEncoding.DecodeString(m.PayloadB64)
+	if err != nil {
+		return m, err
+	}
+	nonce := make([]byte, a.NonceSize(), a.NonceSize()+len(plain)+a.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return m, err
+	}
+	m.PayloadB64 = base64.StdEncoding.EncodeToString(a.Seal(nonce, nonce, plain, e2eAAD(m, m.Party, to)))
+	m.Sealed = true
+	return m, nil
+}
+
+// open returns the wire bytes of a sealed message addressed to this node.
+func (e *e2eKeys) open(m tssnet.WSMessage) ([]byte, error) {
+	a, err := e.aead(m.Party)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(m.PayloadB64)
+	if err != nil || len(b) < a.NonceSize() {
+		return nil, errors.New("bad sealed payload")
+	}
+	plain, err := a.Open(nil, b[:a.NonceSize()], b[a.NonceSize():], e2eAAD(m, m.Party, *partyStr))
+	if err != nil {
+		return nil, errors.New("sealed payload does not authenticate")
+	}
+	return plain, nil
+}
+
+// sealWire splits an outgoing unicast message per recipient and seals each
+// copy; broadcasts, and everything when -e2e is off, pass unchanged.
+func sealWire(m tssnet.WSMessage) ([]tssnet.WSMessage, error) {
+	if sealer == nil || m.Bcast {
+		return []tssnet.WSMessage{m}, nil
+	}
+	out := make([]tssnet.WSMessage, 0, len(m.To))
+	for _, to := range m.To {
+		one := m
+		one.To = []string{to}
+		s, err := sealer.seal(one)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// wirePayload returns the wire bytes of an incoming message. With -e2e a
+// unicast message must be sealed for this node; without it, sealed messages
+// cannot be read.
+func wirePayload(m tssnet.WSMessage) ([]byte, error) {
+	switch {
+	case m.Sealed && sealer == nil:
+		return nil, errors.New("sealed message but -e2e is off")
+	case m.Sealed:
+		return sealer.open(m)
+	case sealer != nil && !m.Bcast:
+		return nil, errors.New("unicast message in the clear while -e2e is on")
+	}
+	return base64.StdEncoding.DecodeString(m.PayloadB64)
+}
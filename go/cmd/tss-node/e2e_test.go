@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// e2eParties builds the -e2e keys of P1, P2 and P3 over one registry.
+func e2eParties(t *testing.T) map[string]*e2eKeys {
+	t.Helper()
+	ids := map[string]ed25519.PrivateKey{}
+	reg := map[string]ed25519.PublicKey{}
+	for i, p := range []string{"P1", "P2", "P3"} {
+		id := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(i + 1)}, ed25519.SeedSize))
+		ids[p], reg[p] = id, id.Public().(ed25519.PublicKey)
+	}
+	out := map[string]*e2eKeys{}
+	for p, id := range ids {
+		k, err := newE2EKeys(id, reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[p] = k
+	}
+	prevParty, prevSession := *partyStr, *clusterSession
+	*clusterSession = "cluster"
+	t.Cleanup(func() { *partyStr, *clusterSession = prevParty, prevSession })
+	return out
+}
+
+// as runs f as party p: the pairwise key is derived with *partyStr.
+func as(p string, f func()) {
+	*partyStr = p
+	f()
+}
+
+func TestMontgomeryPub(t *testing.T) {
+	// the mapped ed25519 public key must be the X25519 public key of the
+	// scalar newE2EKeys derives from the same seed
+	for i := byte(1); i <= 4; i++ {
+		id := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{i}, ed25519.SeedSize))
+		k, err := newE2EKeys(id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := montgomeryPub(id.Public().(ed25519.PublicKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), k.priv.PublicKey().Bytes()) {
+			t.Fatalf("seed %d: mapped key differs from the X25519 key", i)
+		}
+	}
+	if _, err := montgomeryPub(ed25519.PublicKey{1, 2, 3}); err == nil {
+		t.Fatal("short key accepted")
+	}
+}
+
+func TestE2ESealOpen(t *testing.T) {
+	keys := e2eParties(t)
+	plain := []byte("round 2 message for P2")
+	msg := tssnet.WSMessage{Type: "send", Session: "cluster", Party: "P1", From: "P1", To: []string{"P2"}, Job: "j1", MsgID: "m1", Committee: "new", PayloadB64: base64.StdEncoding.EncodeToString(plain)}
+	var sealed tssnet.WSMessage
+	as("P1", func() {
+		var err error
+		if sealed, err = keys["P1"].seal(msg); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !sealed.Sealed || strings.Contains(sealed.PayloadB64, msg.PayloadB64) {
+		t.Fatal("payload not sealed")
+	}
+	with := func(f func(*tssnet.WSMessage)) tssnet.WSMessage { m := sealed; f(&m); return m }
+	flip := func(m *tssnet.WSMessage) {
+		b, _ := base64.StdEncoding.DecodeString(m.PayloadB64)
+		b[len(b)-1] ^= 1
+		m.PayloadB64 = base64.StdEncoding.EncodeToString(b)
+	}
+
+	cases := []struct {
+		name    string
+		as      string
+		m       tssnet.WSMessage
+		wantErr string
+	}{
+		{"recipient", "P2", sealed, ""},
+		{"other node", "P3", sealed, "does not authenticate"},
+		{"moved to another job", "P2", with(func(m *tssnet.WSMessage) { m.Job = "j2" }), "does not authenticate"},
+		{"moved to another session", "P2", with(func(m *tssnet.WSMessage) { m.Session = "other" }), "does not authenticate"},
+		{"replayed under another msg_id", "P2", with(func(m *tssnet.WSMessage) { m.MsgID = "m2" }), "does not authenticate"},
+		{"other committee", "P2", with(func(m *tssnet.WSMessage) { m.Committee = "old" }), "does not authenticate"},
+		{"other tss-lib sender", "P2", with(func(m *tssnet.WSMessage) { m.From = "P1/new" }), "does not authenticate"},
+		{"relabelled sender", "P2", with(func(m *tssnet.WSMessage) { m.Party, m.From = "P3", "P3" }), "does not authenticate"},
+		{"unknown sender", "P2", with(func(m *tssnet.WSMessage) { m.Party = "P9" }), "no identity key"},
+		{"flipped ciphertext", "P2", with(flip), "does not authenticate"},
+		{"truncated", "P2", with(func(m *tssnet.WSMessage) { m.PayloadB64 = base64.StdEncoding.EncodeToString([]byte{1, 2}) }), "bad sealed payload"},
+		{"not base64", "P2", with(func(m *tssnet.WSMessage) { m.PayloadB64 = "%%" }), "bad sealed payload"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []byte
+			var err error
+			as(tc.as, func() { got, err = keys[tc.as].open(tc.m) })
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Fatalf("opened %q, want %q", got, plain)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSealWirePayload(t *testing.T) {
+	keys := e2eParties(t)
+	prev := sealer
+	t.Cleanup(func() { sealer = prev })
+	payload := base64.StdEncoding.EncodeToString([]byte("wire"))
+	uni := tssnet.WSMessage{Type: "send", Session: "cluster", Party: "P1", From: "P1", To: []string{"P2", "P3"}, Job: "j1", MsgID: "m1", PayloadB64: payload}
+	bcast := uni
+	bcast.Bcast, bcast.To = true, nil
+
+	// sending side: P1 with -e2e splits the unicast message per recipient
+	*partyStr, sealer = "P1", keys["P1"]
+	split, err := sealWire(uni)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(split) != 2 || split[0].To[0] != "P2" || split[1].To[0] != "P3" || !split[0].Sealed {
+		t.Fatalf("sealWire split = %+v", split)
+	}
+	if out, _ := sealWire(bcast); len(out) != 1 || out[0].Sealed {
+		t.Fatal("broadcast was sealed")
+	}
+
+	cases := []struct {
+		name    string
+		party   string
+		e2e     bool
+		m       tssnet.WSMessage
+		wantErr string
+	}{
+		{"sealed, e2e on", "P2", true, split[0], ""},
+		{"sealed for P3, e2e on", "P3", true, split[1], ""},
+		{"sealed, e2e off", "P2", false, split[0], "-e2e is off"},
+		{"clear unicast, e2e on", "P2", true, uni, "in the clear"},
+		{"clear unicast, e2e off", "P2", false, uni, ""},
+		{"clear broadcast, e2e on", "P2", true, bcast, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			*partyStr, sealer = tc.party, nil
+			if tc.e2e {
+				sealer = keys[tc.party]
+			}
+			got, err := wirePayload(tc.m)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(got) != "wire" {
+					t.Fatalf("payload = %q", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
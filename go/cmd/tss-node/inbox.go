@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/tss"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// Wire messages may reach a node before its local party has started: a faster
+// peer got the cmd first and already sent round 1 (round 5 when signing with
+// a presignature), or tss-lib is still in Start (a cold keygen generates safe
+// primes there). They wait in rt.early and are delivered once the party is
+// ready.
+const (
+	earlyMax = 1024             // buffered messages across all jobs
+	earlyTTL = 60 * time.Second // for jobs whose cmd never arrives here
+)
+
+type earlyMsg struct {
+	m  tssnet.WSMessage
+	at time.Time
+}
+
+// wireStats counts what happened to incoming wire messages.
+type wireStats struct {
+	Delivered uint64 `json:"delivered"` // handed to tss-lib
+	Early     uint64 `json:"early"`     // buffered until the party started, then delivered
+	Duplicate uint64 `json:"duplicate"` // same msg_id seen before in the session (e.g. resent on resume)
+	Late      uint64 `json:"late"`      // for a session that already ended here
+	Dropped   uint64 `json:"dropped"`   // buffer full or expired, unknown sender, bad payload
+	Rejected  uint64 `json:"rejected"`  // -e2e: unicast in the clear, or sealed payload that does not open
+	Sealed    uint64 `json:"sealed"`    // -e2e: delivered after opening
+}
+
+func (s *wireStats) add(o wireStats) {
+	s.Delivered += o.Delivered
+	s.Early += o.Early
+	s.Duplicate += o.Duplicate
+	s.Late += o.Late
+	s.Dropped += o.Dropped
+	s.Rejected += o.Rejected
+	s.Sealed += o.Sealed
+}
+
+func (s wireStats) String() string {
+	return fmt.Sprintf("delivered=%d early=%d duplicate=%d late=%d dropped=%d rejected=%d sealed=%d", s.Delivered, s.Early, s.Duplicate, s.Late, s.Dropped, s.Rejected, s.Sealed)
+}
+
+// receive handles a "send" from the coordinator: deliver it, buffer it until
+// the local party is ready, or count why it was dropped.
+func (rt *runtime) receive(c *tssnet.Conn, m tssnet.WSMessage) {
+	rt.mu.Lock()
+	switch {
+	case m.Job != rt.job && m.Job != "" && slices.Contains(rt.ended, m.Job):
+		rt.stats.Late++
+		rt.mu.Unlock()
+		return
+	case m.Job != rt.job || !rt.ready[m.Committee]:
+		rt.buffer(m)
+		rt.mu.Unlock()
+		return
+	case m.MsgID != "" && rt.msgSeen[m.MsgID]:
+		rt.wire.Duplicate++
+		rt.mu.Unlock()
+		return
+	}
+	if m.MsgID != "" {
+		rt.msgSeen[m.MsgID] = true
+	}
+	p, idMap := rt.partyFor(m.Committee), rt.idMap
+	rt.mu.Unlock()
+	rt.deliver(c, p, idMap, m)
+}
+
+// buffer keeps m for later; rt.mu must be held.
+func (rt *runtime) buffer(m tssnet.WSMessage) {
+	now := time.Now()
+	kept := rt.early[:0]
+	for _, e := range rt.early {
+		if e.m.Job != rt.job && now.Sub(e.at) > earlyTTL {
+			rt.stats.Dropped++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	rt.early = kept
+	if len(rt.early) >= earlyMax {
+		rt.stats.Dropped++
+		return
+	}
+	rt.early = append(rt.early, earlyMsg{m: m, at: now})
+}
+
+// partyReady is called once a local party's Start returned: from now on its
+// messages go straight to tss-lib, and those that came early are delivered.
+func (rt *runtime) partyReady(c *tssnet.Conn, job, committee string) {
+	rt.mu.Lock()
+	if !rt.busy || rt.job != job {
+		rt.mu.Unlock()
+		return
+	}
+	rt.ready[committee] = true
+	p, idMap := rt.partyFor(committee), rt.idMap
+	var now []tssnet.WSMessage
+	kept := rt.early[:0]
+	for _, e := range rt.early {
+		switch {
+		case e.m.Job != job || e.m.Committee != committee:
+			kept = append(kept, e)
+		case e.m.MsgID != "" && rt.msgSeen[e.m.MsgID]:
+			rt.wire.Duplicate++
+		default:
+			if e.m.MsgID != "" {
+				rt.msgSeen[e.m.MsgID] = true
+			}
+			now = append(now, e.m)
+		}
+	}
+	rt.early = kept
+	rt.wire.Early += uint64(len(now))
+	rt.mu.Unlock()
+	for _, m := range now {
+		rt.deliver(c, p, idMap, m)
+	}
+}
+
+// partyFor returns the local party a message for committee goes to; rt.mu
+// must be held.
+func (rt *runtime) partyFor(committee string) tss.Party {
+	if committee != "" {
+		return rt.committees[committee]
+	}
+	return rt.party
+}
+
+func (rt *runtime) deliver(c *tssnet.Conn, p tss.Party, idMap map[string]*tss.PartyID, m tssnet.WSMessage) {
+	from := idMap[m.From]
+	if p == nil || from == nil {
+		rt.countWire(func(s *wireStats) { s.Dropped++ })
+		return
+	}
+	wireBytes, err := wirePayload(m)
+	if err != nil {
+		if m.Sealed || sealer != nil {
+			log.Printf("job %s: rejecting %s from %s: %v", m.Job, m.MsgID, m.Party, err)
+			rt.countWire(func(s *wireStats) { s.Rejected++ })
+		} else {
+			rt.countWire(func(s *wireStats) { s.Dropped++ })
+		}
+		return
+	}
+	rt.mu.Lock()
+	rt.wire.Delivered++
+	if m.Sealed {
+		rt.wire.Sealed++
+	}
+	trace, pre := rt.trace, rt.presign
+	rt.mu.Unlock()
+	// parse here: tss-lib's UpdateFromBytes reports a malformed message
+	// without naming the sender
+	msg, err := tss.ParseWireMessage(wireBytes, from, m.Bcast)
+	if err != nil {
+		rt.tssFailed(c, tss.NewError(err, "parse", 0, p.PartyID(), from))
+		return
+	}
+	trace.received(msg.Type(), len(wireBytes))
+	if pre != nil && msgRound(msg.Type()) == presignHeldRound {
+		pre.hold(msg)
+		return
+	}
+	if _, terr := p.Update(msg); terr != nil {
+		rt.tssFailed(c, terr)
+	}
+}
+
+func (rt *runtime) countWire(bump func(*wireStats)) {
+	rt.mu.Lock()
+	bump(&rt.wire)
+	rt.mu.Unlock()
+}
+
+// endWire closes the running session's wire bookkeeping: messages still
+// buffered for it will never be delivered, and its counters go to the log
+// and the node totals. rt.mu must be held.
+func (rt *runtime) endWire() {
+	kept := rt.early[:0]
+	for _, e := range rt.early {
+		if e.m.Job == rt.job {
+			rt.wire.Late++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	rt.early = kept
+	rt.ended = rememberJob(rt.ended, rt.job)
+	rt.stats.add(rt.wire)
+	if rt.wire != (wireStats{}) {
+		log.Printf("job %s wire: %v (node total: %v)", rt.job, rt.wire, rt.stats)
+	}
+	rt.wire = wireStats{}
+	rt.ready, rt.msgSeen = nil, nil
+}
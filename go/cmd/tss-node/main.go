@@ -1,31 +1,25 @@
 package main
 
 import (
-	stdecdsa "crypto/ecdsa"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"math/big"
 	"net/url"
-	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
-
 	"github.com/bnb-chain/tss-lib/v2/common"
 	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
 	"github.com/bnb-chain/tss-lib/v2/ecdsa/signing"
 	"github.com/bnb-chain/tss-lib/v2/tss"
 
-	"github.com/ethereum/go-ethereum/crypto"
-
 	"mp-htlc-lgp/experiment/internal/tssnet"
 )
 
@@ -35,56 +29,184 @@ var (
 	partyStr       = flag.String("party", "P1", "this node party id")
 	dataDir        = flag.String("data", "/data", "data directory")
 	gatewayParty   = flag.String("gateway", "G", "gateway party id")
+	shareKeyFile   = flag.String("share-key-file", "", "file with a 32-byte key (raw or hex) that encrypts key shares at rest")
+	sharePassFile  = flag.String("share-pass-file", "", "file with a passphrase that encrypts key shares at rest (scrypt)")
+	migrate        = flag.Bool("migrate-shares", false, "encrypt plaintext shares (and reseal older sealed shares) in -data, then exit")
+	poolSize       = flag.Int("preparams-pool", 2, "keygen pre-params to keep ready (0 = always generate in round 1)")
+	poolTimeout    = flag.Duration("preparams-timeout", 5*time.Minute, "timeout for generating one set of pre-params")
+	identityKey    = flag.String("identity-key", "", "file with this party's ed25519 identity key, answers the coordinator's challenge (empty = none)")
+	genIdentity    = flag.Bool("gen-identity", false, "print the public key of -identity-key (created if missing) for the coordinator registry, then exit")
+	coordinatorCA  = flag.String("coordinator-ca", "", "CA file trusted for a wss:// coordinator (empty = system roots)")
 )
 
+// pool holds pre-generated keygen pre-params (see preparams.go).
+var pool *preParamsPool
+
 type runtime struct {
-	mu        sync.Mutex
-	busy      bool
-	party     tss.Party
-	idMap     map[string]*tss.PartyID
-	parties   []string
-	threshold int
+	mu    sync.Mutex
+	busy  bool
+	party tss.Party
+	idMap map[string]*tss.PartyID
+
+	committees map[string]tss.Party // reshare: "old"/"new" instance on this node
+	parties    []string
+	threshold  int
+
+	job   string        // gateway job id of the running session
+	cmd   string        // cmd that started the running session
+	keyID string        // key of the running session
+	peers []string      // all parties of the running session (abort targets)
+	abort chan error    // first tss error, peer abort, cancel or deadline of the running session
+	done  chan struct{} // closed when the running session has cleaned up
+
+	outbox []tssnet.WSMessage // wire messages sent in the running session (resent on resume)
+	seen   []string           // recent session-starting job ids (replay guard)
+	ended  []string           // recent job ids whose session ended here
+	gone   []string           // recent job ids cancelled before their session started here
+
+	ready   map[string]bool // local parties of the running session whose Start returned ("" or committee)
+	msgSeen map[string]bool // msg_ids delivered in the running session
+	early   []earlyMsg      // wire messages waiting for their local party (see inbox.go)
+	seq     int             // msg_id counter of the running session
+	wire    wireStats       // running session
+	trace   *sessionTrace   // per-round timeline of the running session
+	stats   wireStats       // since the node started
+	lastErr *nodeError      // last failed *_result (admin status)
+
+	presign *presig   // running presign session (see presign.go)
+	presigs []*presig // parked presignatures, oldest first
 }
 
+// defaultTimeouts bound sessions whose cmd carries no deadline.
+var defaultTimeouts = map[string]time.Duration{"keygen": 30 * time.Minute, "sign": 10 * time.Minute, "reshare": 30 * time.Minute}
+
+var (
+	errCancelled = errors.New("cancelled by gateway")
+	errDeadline  = errors.New("session deadline exceeded")
+)
+
+// busyWait is how long a cmd waits for the running session to clean up: the
+// gateway may send the next cmd as soon as it has one failed result, while
+// this node is still unwinding the aborted session.
+const busyWait = 2 * time.Second
+
 func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	if *genIdentity {
+		pub, err := tssnet.EnsureIdentity(*identityKey)
+		if err != nil {
+			log.Fatalf("identity key: %v", err)
+		}
+		fmt.Println(hex.EncodeToString(pub))
+		return
+	}
+
+	sc, err := newShareCipher(*shareKeyFile, *sharePassFile)
+	if err != nil {
+		log.Fatalf("share key: %v", err)
+	}
+	shareKey = sc
+	if *migrate {
+		if err := migrateShares(*dataDir, sc); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	if err := checkShares(*dataDir, sc); err != nil {
+		log.Fatalf("key shares: %v", err)
+	}
 
 	u, err := url.Parse(*coordinatorURL)
 	if err != nil {
 		log.Fatalf("bad coordinator url: %v", err)
 	}
+	opts, err := tssnet.ClientOptions(*identityKey, *coordinatorCA)
+	if err != nil {
+		log.Fatalf("coordinator identity/tls: %v", err)
+	}
+	if *e2eOn {
+		if opts.Identity == nil || *registryPath == "" {
+			log.Fatalf("-e2e needs -identity-key and -registry")
+		}
+		reg, err := tssnet.LoadRegistry(*registryPath)
+		if err != nil {
+			log.Fatalf("registry: %v", err)
+		}
+		if sealer, err = newE2EKeys(opts.Identity, reg.Keys[*clusterSession]); err != nil {
+			log.Fatalf("e2e: %v", err)
+		}
+		log.Printf("e2e: unicast wire messages sealed (%d registered parties)", len(reg.Keys[*clusterSession]))
+	}
+	if *replayFile != "" {
+		if err := runReplay(); err != nil {
+			log.Fatalf("replay: %v", err)
+		}
+		return
+	}
 
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	rt := &runtime{}
+	// on a reconnect the hello names the running job, so the coordinator log
+	// shows which session this node is resuming
+	c, err := tssnet.Dial(u.String(), opts, func(resume bool) tssnet.WSMessage {
+		m := tssnet.WSMessage{Type: "hello", Session: *clusterSession, Party: *partyStr, Role: "node", Resume: resume}
+		if resume {
+			rt.mu.Lock()
+			m.Job, m.KeyID = rt.job, rt.keyID
+			rt.mu.Unlock()
+		}
+		return m
+	})
 	if err != nil {
 		log.Fatalf("dial: %v", err)
 	}
-	defer c.Close()
 
-	// hello
-	_ = c.WriteMessage(websocket.TextMessage, tssnet.MustJSON(tssnet.WSMessage{Type: "hello", Session: *clusterSession, Party: *partyStr, Role: "node"}))
-
-	rt := &runtime{}
+	pool, err = newPreParamsPool(*dataDir, *poolSize, *poolTimeout, rt.isBusy)
+	if err != nil {
+		log.Fatalf("preparams pool: %v", err)
+	}
+	if *adminListen != "" {
+		go serveAdmin(rt, c)
+	}
 
-	for {
-		_, b, err := c.ReadMessage()
-		if err != nil {
-			log.Fatalf("read: %v", err)
-		}
-		var m tssnet.WSMessage
-		if err := json.Unmarshal(b, &m); err != nil {
-			continue
-		}
+	// the tss-lib parties live in rt, so a session survives a reconnect
+	c.Run(func(m tssnet.WSMessage) {
 		switch m.Type {
 		case "send":
-			handleWire(rt, m)
+			rt.receive(c, m)
 		case "cmd":
 			handleCmd(rt, c, m)
+		case "welcome":
+			rt.resumed(c, m)
+		case "resume":
+			rt.peerResumed(c, m)
 		}
-	}
+	})
 }
 
-func handleCmd(rt *runtime, c *websocket.Conn, m tssnet.WSMessage) {
+func handleCmd(rt *runtime, c *tssnet.Conn, m tssnet.WSMessage) {
+	// a peer's abort of a session both are in (checked in peerAbort); every
+	// other cmd must come from the gateway
+	if m.Cmd == "abort" {
+		rt.peerAbort(m)
+		return
+	}
+	if m.Party != *gatewayParty {
+		log.Printf("ignoring cmd=%s job=%s from %q: not the gateway", m.Cmd, m.Job, m.Party)
+		return
+	}
+	// read-only query, answered even while busy
+	switch m.Cmd {
+	case "keys":
+		go reportKeys(c)
+		return
+	case "preparams":
+		sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *partyStr, Parties: []string{*gatewayParty}, Cmd: "preparams_result", Ok: true, Payload: tssnet.MustJSON(pool.status())})
+		return
+	case "cancel":
+		rt.cancel(m)
+		return
+	}
 	// only act if we're in the party set (if provided)
 	if len(m.Parties) > 0 {
 		mine := false
@@ -99,72 +221,152 @@ func handleCmd(rt *runtime, c *websocket.Conn, m tssnet.WSMessage) {
 		}
 	}
 
-	rt.mu.Lock()
-	if rt.busy {
-		rt.mu.Unlock()
-		log.Printf("busy; ignoring cmd=%s", m.Cmd)
+	keyID := m.KeyID
+	if keyID == "" {
+		keyID = tssnet.DefaultKeyID
+	}
+	// e.g. a cmd replayed by the coordinator long after the gateway gave up
+	if m.Deadline != 0 && time.Now().UnixMilli() >= m.Deadline {
+		log.Printf("ignoring expired cmd=%s job=%s", m.Cmd, m.Job)
+		return
+	}
+	// the coordinator replays the last cmd to every (re)connecting party
+	if m.Job != "" && (m.Cmd == "keygen" || m.Cmd == "sign" || m.Cmd == "presign" || m.Cmd == "reshare") && rt.seenJob(m.Job) {
+		log.Printf("ignoring replayed cmd=%s job=%s", m.Cmd, m.Job)
 		return
 	}
-	rt.busy = true
-	rt.mu.Unlock()
 
 	go func() {
+		abort, ok := rt.acquire(m.Cmd, m.Job, keyID, m.Parties)
+		if !ok {
+			log.Printf("busy; ignoring cmd=%s", m.Cmd)
+			// the presig filler should not wait out the deadline for us
+			if m.Cmd == "presign" {
+				res := failResult("presign_result", keyID, errors.New("node busy"))
+				res.Job = m.Job
+				sendResult(c, res)
+			}
+			return
+		}
+		if d := sessionTimeout(m); d > 0 {
+			t := time.AfterFunc(d, func() { stopSession(abort, errDeadline) })
+			defer t.Stop()
+		}
 		defer func() {
 			rt.mu.Lock()
+			rt.endWire()
 			rt.busy = false
 			rt.party = nil
+			rt.committees = nil
 			rt.idMap = nil
+			rt.cmd, rt.job, rt.keyID, rt.peers, rt.abort = "", "", "", nil, nil
+			rt.outbox, rt.trace, rt.presign = nil, nil, nil
+			close(rt.done)
 			rt.mu.Unlock()
 		}()
 
 		switch m.Cmd {
 		case "keygen":
-			if err := runKeygen(rt, c, m.Parties, m.Threshold); err != nil {
-				sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *partyStr, Parties: []string{*gatewayParty}, Cmd: "keygen_result", Ok: false, Err: errString(err)})
+			if err := runKeygen(rt, c, keyID, m.Parties, m.Threshold, m.PreParams); err != nil {
+				rt.sendResult(c, failResult("keygen_result", keyID, err))
 			}
 		case "sign":
-			err := runSign(rt, c, m.Parties, m.Threshold, m.HashHex)
+			err := runSign(rt, c, keyID, m.Parties, m.Threshold, m.HashHex, m.Presig, m.Path)
 			// runSign itself will send sign_result (with r,s) if ok
 			if err != nil {
-				sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *partyStr, Parties: []string{*gatewayParty}, Cmd: "sign_result", Ok: false, Err: errString(err)})
+				rt.sendResult(c, failResult("sign_result", keyID, err))
+			}
+		case "presign":
+			if err := runPresign(rt, c, keyID, m.Parties, m.Threshold); err != nil {
+				rt.sendResult(c, failResult("presign_result", keyID, err))
 			}
+		case "reshare", "reshare_commit", "reshare_finalize", "reshare_retire", "reshare_abort":
+			handleReshareCmd(rt, c, m, keyID)
 		default:
 			log.Printf("unknown cmd: %s", m.Cmd)
 		}
 	}()
 }
 
-func handleWire(rt *runtime, m tssnet.WSMessage) {
-	rt.mu.Lock()
-	p := rt.party
-	idMap := rt.idMap
-	rt.mu.Unlock()
-	if p == nil || idMap == nil {
-		return
+// acquire marks the node busy with a new session, waiting up to busyWait for
+// the running one to finish. It returns the new session's abort channel.
+func (rt *runtime) acquire(cmd, job, keyID string, peers []string) (chan error, bool) {
+	timeout := time.After(busyWait)
+	for {
+		rt.mu.Lock()
+		if !rt.busy {
+			rt.busy = true
+			// background pre-params generation would compete for the CPU
+			pool.interrupt()
+			rt.cmd, rt.job, rt.keyID, rt.peers = cmd, job, keyID, peers
+			rt.abort = make(chan error, 1)
+			rt.ready, rt.msgSeen, rt.seq = map[string]bool{}, map[string]bool{}, 0
+			rt.trace = newTrace()
+			rt.done = make(chan struct{})
+			abort := rt.abort
+			// the cancel overtook the cmd (acquire runs off the read loop)
+			if slices.Contains(rt.gone, job) {
+				abort <- errCancelled
+			}
+			rt.mu.Unlock()
+			return abort, true
+		}
+		done := rt.done
+		rt.mu.Unlock()
+		select {
+		case <-done:
+		case <-timeout:
+			return nil, false
+		}
 	}
-	wireBytes, err := base64.StdEncoding.DecodeString(m.PayloadB64)
-	if err != nil {
-		return
+}
+
+// sessionTimeout is how long the session started by m may run: until the
+// gateway's deadline, else the node default for the cmd (0 = unbounded).
+func sessionTimeout(m tssnet.WSMessage) time.Duration {
+	if m.Deadline != 0 {
+		return time.Until(time.UnixMilli(m.Deadline))
 	}
-	from := idMap[m.From]
-	if from == nil {
-		return
+	return defaultTimeouts[m.Cmd]
+}
+
+// cancel stops the running session if it belongs to the job being cancelled;
+// the session then reports errCancelled in its *_result. A cancel that
+// overtakes its cmd is remembered, and acquire stops that session at once.
+func (rt *runtime) cancel(m tssnet.WSMessage) {
+	rt.mu.Lock()
+	abort := rt.abort
+	match := rt.busy && m.Job != "" && rt.job == m.Job
+	if !match && m.Job != "" {
+		rt.gone = rememberJob(rt.gone, m.Job)
 	}
-	_, terr := p.UpdateFromBytes(wireBytes, from, m.Bcast)
-	if terr != nil {
-		log.Printf("UpdateFromBytes error: %v", terr)
+	rt.mu.Unlock()
+	if match && stopSession(abort, errCancelled) {
+		log.Printf("job %s (key %s) cancelled by %s", m.Job, m.KeyID, m.Party)
 	}
 }
 
-func runKeygen(rt *runtime, c *websocket.Conn, parties []string, threshold int) error {
+func (rt *runtime) isBusy() bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.busy
+}
+
+func runKeygen(rt *runtime, c *tssnet.Conn, keyID string, parties []string, threshold int, preMode string) error {
 	if len(parties) == 0 {
 		return errors.New("empty parties")
 	}
 	if threshold <= 0 || threshold >= len(parties) {
 		return fmt.Errorf("bad threshold=%d for n=%d", threshold, len(parties))
 	}
+	if err := tssnet.ValidKeyID(keyID); err != nil {
+		return err
+	}
+	if shareExists(*dataDir, keyID) {
+		return fmt.Errorf("key %s already exists on this node", keyID)
+	}
 	thisID := strings.TrimSpace(*partyStr)
-	partyIDs, idMap, thisParty, err := makeParties(parties, thisID)
+	partyIDs, idMap, thisParty, err := makeParties(parties, nil, thisID)
 	if err != nil {
 		return err
 	}
@@ -174,122 +376,260 @@ func runKeygen(rt *runtime, c *websocket.Conn, parties []string, threshold int)
 	outCh := make(chan tss.Message, 1024)
 	endCh := make(chan *keygen.LocalPartySaveData, 1)
 
-	// omit preParams => library computes in round 1
-	local := keygen.NewLocalParty(params, outCh, endCh)
+	// no preParams => library computes them in round 1 ("cold")
+	pre, mode, err := pool.preParamsFor(preMode)
+	if err != nil {
+		return err
+	}
+	log.Printf("keygen key=%s preparams=%s (requested %q)", keyID, mode, preMode)
+	var local tss.Party
+	if pre != nil {
+		local = keygen.NewLocalParty(params, outCh, endCh, *pre)
+	} else {
+		local = keygen.NewLocalParty(params, outCh, endCh)
+	}
 
 	rt.mu.Lock()
 	rt.party = local
 	rt.idMap = idMap
 	rt.parties = parties
 	rt.threshold = threshold
+	aborted, job, trace := rt.abort, rt.job, rt.trace
 	rt.mu.Unlock()
 
 	go func() {
 		if err := local.Start(); err != nil {
-			log.Printf("party.Start error: %v", err)
+			rt.tssFailed(c, err)
+			return
 		}
+		rt.partyReady(c, job, "")
 	}()
 
 	// forward outCh messages
+	forward := func(msg tss.Message) {
+		wire, routing, err := msg.WireBytes()
+		if err != nil {
+			return
+		}
+		to := routeToStrings(parties, routing, thisID)
+		trace.sent(msg.Type(), len(wire), len(to))
+		rt.sendWire(c, to, routing.From.Id, routing.IsBroadcast, msgRound(msg.Type()), wire)
+	}
 	for {
 		select {
 		case msg := <-outCh:
-			wire, routing, err := msg.WireBytes()
-			if err != nil {
-				continue
-			}
-			to := routeToStrings(parties, routing, thisID)
-			sendWire(c, to, routing.From.Id, routing.IsBroadcast, wire)
+			forward(msg)
 		case save := <-endCh:
+			drain(outCh, forward)
 			if save == nil {
 				return errors.New("nil keygen result")
 			}
-			// persist key shares locally
-			if err := persistKeygen(*dataDir, *save); err != nil {
-				log.Printf("persist keygen error: %v", err)
+			pub, addr, err := pubAndAddr(*save)
+			if err != nil {
+				return err
 			}
-			if save.ECDSAPub == nil {
-				return errors.New("nil ECDSAPub in keygen result")
+			// persist key shares locally
+			sf := shareFile{KeyID: keyID, Parties: parties, Threshold: threshold, CreatedAt: time.Now().UTC(), PubKeyHex: pub, Address: addr, Share: *save}
+			if err := saveShare(*dataDir, sf); err != nil {
+				return fmt.Errorf("persist key %s: %w", keyID, err)
 			}
-			ecdsaPub := &stdecdsa.PublicKey{Curve: save.ECDSAPub.Curve(), X: save.ECDSAPub.X(), Y: save.ECDSAPub.Y()}
-			pub := crypto.FromECDSAPub(ecdsaPub)
-			addr := crypto.PubkeyToAddress(*ecdsaPub).Hex()
 			// send a richer result to gateway
-			sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: thisID, Parties: []string{*gatewayParty}, Cmd: "keygen_result", Ok: true, PubKeyHex: "0x" + hex.EncodeToString(pub), AddrHex: addr})
+			rt.sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: thisID, Parties: []string{*gatewayParty}, Cmd: "keygen_result", KeyID: keyID, Ok: true, PubKeyHex: pub, AddrHex: addr, PreParams: mode})
 			return nil
-		case <-time.After(30 * time.Minute):
-			return errors.New("keygen timeout")
+		case err := <-aborted:
+			return err
 		}
 	}
 }
 
-func runSign(rt *runtime, c *websocket.Conn, parties []string, threshold int, hashHex string) error {
+// runSign signs hashHex with keyID, or with its child at path (see derive.go).
+// With presigID it runs only the online phase of that presignature (see
+// presign.go).
+func runSign(rt *runtime, c *tssnet.Conn, keyID string, parties []string, threshold int, hashHex, presigID, path string) error {
 	if len(parties) == 0 {
 		return errors.New("empty parties")
 	}
 	thisID := strings.TrimSpace(*partyStr)
-	partyIDs, idMap, thisParty, err := makeParties(parties, thisID)
-	if err != nil {
-		return err
-	}
-	ctx := tss.NewPeerContext(partyIDs)
-	params := tss.NewParameters(tss.S256(), ctx, thisParty, len(partyIDs), threshold)
-
-	keyData, err := loadKeygen(*dataDir)
-	if err != nil {
-		return err
-	}
 	hashBytes, err := decode32(hashHex)
 	if err != nil {
 		return err
 	}
 	msgInt := new(big.Int).SetBytes(hashBytes)
 
-	outCh := make(chan tss.Message, 1024)
-	endCh := make(chan *common.SignatureData, 1)
-	local := signing.NewLocalParty(msgInt, params, keyData, outCh, endCh)
+	var (
+		local tss.Party
+		idMap map[string]*tss.PartyID
+		outCh chan tss.Message
+		endCh chan *common.SignatureData
+		held  []tss.ParsedMessage // presigned: peers' round 4, delivered instead of Start
+	)
+	if presigID != "" && path != "" {
+		// a presignature is bound to the parent key's shares
+		return errors.New("presignature cannot sign for a derived key")
+	}
+	if presigID != "" {
+		pre, err := rt.takePresig(presigID, keyID, parties, threshold)
+		if err != nil {
+			return err
+		}
+		// round 1 checks this for a fresh party
+		if msgInt.Cmp(tss.S256().Params().N) >= 0 {
+			return errors.New("hashed message is not valid")
+		}
+		pre.m.Set(msgInt)
+		pre.mu.Lock()
+		held = append(held, pre.held...)
+		pre.mu.Unlock()
+		local, idMap, outCh, endCh = pre.party, pre.idMap, pre.outCh, pre.endCh
+	} else {
+		sf, err := loadShare(*dataDir, keyID)
+		if err != nil {
+			return err
+		}
+		keys, err := sf.partyKeys(parties)
+		if err != nil {
+			return err
+		}
+		partyIDs, ids, thisParty, err := makeParties(parties, keys, thisID)
+		if err != nil {
+			return err
+		}
+		ctx := tss.NewPeerContext(partyIDs)
+		params := tss.NewParameters(tss.S256(), ctx, thisParty, len(partyIDs), threshold)
+		share, delta, err := deriveShare(sf.Share, path)
+		if err != nil {
+			return err
+		}
+		outCh = make(chan tss.Message, 1024)
+		endCh = make(chan *common.SignatureData, 1)
+		if delta != nil {
+			local = signing.NewLocalPartyWithKDD(msgInt, params, share, delta, outCh, endCh)
+		} else {
+			local = signing.NewLocalParty(msgInt, params, share, outCh, endCh)
+		}
+		idMap = ids
+	}
 
 	rt.mu.Lock()
 	rt.party = local
 	rt.idMap = idMap
 	rt.parties = parties
 	rt.threshold = threshold
+	aborted, job, trace := rt.abort, rt.job, rt.trace
 	rt.mu.Unlock()
 
 	go func() {
-		if err := local.Start(); err != nil {
-			log.Printf("sign party.Start error: %v", err)
+		if presigID == "" {
+			if err := local.Start(); err != nil {
+				rt.tssFailed(c, err)
+				return
+			}
+		} else {
+			trace.skipTo(presignHeldRound + 1)
+			for _, msg := range held {
+				if _, err := local.Update(msg); err != nil {
+					rt.tssFailed(c, err)
+					return
+				}
+			}
 		}
+		rt.partyReady(c, job, "")
 	}()
 
+	forward := func(msg tss.Message) {
+		wire, routing, err := msg.WireBytes()
+		if err != nil {
+			return
+		}
+		to := routeToStrings(parties, routing, thisID)
+		trace.sent(msg.Type(), len(wire), len(to))
+		rt.sendWire(c, to, routing.From.Id, routing.IsBroadcast, msgRound(msg.Type()), wire)
+	}
 	for {
 		select {
 		case msg := <-outCh:
-			wire, routing, err := msg.WireBytes()
-			if err != nil {
-				continue
-			}
-			to := routeToStrings(parties, routing, thisID)
-			sendWire(c, to, routing.From.Id, routing.IsBroadcast, wire)
+			forward(msg)
 		case sig := <-endCh:
+			drain(outCh, forward)
 			if sig == nil {
 				return errors.New("nil signature")
 			}
-			sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: thisID, Parties: []string{*gatewayParty}, Cmd: "sign_result", Ok: true, RHex: "0x" + hex.EncodeToString(sig.R), SHex: "0x" + hex.EncodeToString(sig.S)})
+			rt.sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: thisID, Parties: []string{*gatewayParty}, Cmd: "sign_result", KeyID: keyID, Ok: true, RHex: "0x" + hex.EncodeToString(sig.R), SHex: "0x" + hex.EncodeToString(sig.S)})
 			return nil
-		case <-time.After(10 * time.Minute):
-			return errors.New("sign timeout")
+		case err := <-aborted:
+			return err
+		}
+	}
+}
+
+// drain forwards what the party queued before it finished: its last broadcast
+// and its end result can be ready at once, and select picks either, which
+// left the peers waiting for that broadcast until the deadline.
+func drain(outCh chan tss.Message, forward func(tss.Message)) {
+	for {
+		select {
+		case msg := <-outCh:
+			forward(msg)
+		default:
+			return
 		}
 	}
 }
 
-func sendWire(c *websocket.Conn, to []string, from string, bcast bool, wire []byte) {
-	m := tssnet.WSMessage{Type: "send", Session: *clusterSession, Party: *partyStr, From: from, To: to, Bcast: bcast, PayloadB64: base64.StdEncoding.EncodeToString(wire)}
-	_ = c.WriteMessage(websocket.TextMessage, tssnet.MustJSON(m))
+// writeWS sends m to the coordinator; while the connection is down it is
+// queued and sent after the reconnect.
+func writeWS(c *tssnet.Conn, m tssnet.WSMessage) {
+	if err := c.Send(m); err != nil {
+		log.Printf("send %s/%s: %v", m.Type, m.Cmd, err)
+	}
+}
+
+func (rt *runtime) sendWire(c *tssnet.Conn, to []string, from string, bcast bool, round int, wire []byte) {
+	rt.relay(c, tssnet.WSMessage{Type: "send", Session: *clusterSession, Party: *partyStr, From: from, To: to, Bcast: bcast, Round: round, PayloadB64: base64.StdEncoding.EncodeToString(wire)})
 }
 
-func sendResult(c *websocket.Conn, m tssnet.WSMessage) {
-	_ = c.WriteMessage(websocket.TextMessage, tssnet.MustJSON(m))
+func sendResult(c *tssnet.Conn, m tssnet.WSMessage) {
+	writeWS(c, m)
+}
+
+// sendResult answers for the running session, tagged with its job id so the
+// gateway can tell it from a late answer to an earlier job.
+func (rt *runtime) sendResult(c *tssnet.Conn, m tssnet.WSMessage) {
+	rt.mu.Lock()
+	m.Job = rt.job
+	trace := rt.trace
+	if !m.Ok {
+		rt.lastErr = &nodeError{At: time.Now().UTC(), Cmd: m.Cmd, Job: m.Job, KeyID: m.KeyID, Err: m.Err}
+	}
+	rt.mu.Unlock()
+	m.Telemetry = trace.telemetry(c)
+	writeWS(c, m)
+}
+
+// reportKeys answers a "keys" query with the metadata of every stored share.
+func reportKeys(c *tssnet.Conn) {
+	shares, err := listShares(*dataDir)
+	if err != nil {
+		sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *partyStr, Parties: []string{*gatewayParty}, Cmd: "keys_result", Ok: false, Err: errString(err)})
+		return
+	}
+	reports := make([]tssnet.KeyReport, 0, len(shares))
+	for _, f := range shares {
+		st, _ := loadReshareState(*dataDir, f.KeyID)
+		reports = append(reports, tssnet.KeyReport{KeyID: f.KeyID, PubKeyHex: f.PubKeyHex, AddrHex: f.Address, Parties: f.Parties, Threshold: f.Threshold, PartyKeys: f.PartyKeys, CreatedAt: f.CreatedAt, Reshare: st})
+	}
+	// a new-only party that has not committed yet holds no share, only state
+	states, _ := filepath.Glob(filepath.Join(*dataDir, "keys", "*.json.reshare"))
+	for _, p := range states {
+		keyID := strings.TrimSuffix(filepath.Base(p), ".json.reshare")
+		if tssnet.ValidKeyID(keyID) != nil || shareExists(*dataDir, keyID) {
+			continue
+		}
+		if st, err := loadReshareState(*dataDir, keyID); err == nil && st != nil {
+			reports = append(reports, tssnet.KeyReport{KeyID: keyID, Reshare: st})
+		}
+	}
+	sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *partyStr, Parties: []string{*gatewayParty}, Cmd: "keys_result", Ok: true, Payload: tssnet.MustJSON(reports)})
 }
 
 func routeToStrings(all []string, routing *tss.MessageRouting, self string) []string {
@@ -314,11 +654,16 @@ func routeToStrings(all []string, routing *tss.MessageRouting, self string) []st
 	return to
 }
 
-func makeParties(parties []string, self string) ([]*tss.PartyID, map[string]*tss.PartyID, *tss.PartyID, error) {
+// makeParties builds sorted party IDs; keys[i] is the tss-lib key of
+// parties[i], nil means 1..n in list order (as used by keygen).
+func makeParties(parties []string, keys []*big.Int, self string) ([]*tss.PartyID, map[string]*tss.PartyID, *tss.PartyID, error) {
 	unsorted := make([]*tss.PartyID, 0, len(parties))
 	for i, id := range parties {
 		id = strings.TrimSpace(id)
 		uid := big.NewInt(int64(i + 1))
+		if keys != nil {
+			uid = keys[i]
+		}
 		pid := tss.NewPartyID(id, id, uid)
 		unsorted = append(unsorted, pid)
 	}
@@ -333,39 +678,16 @@ func makeParties(parties []string, self string) ([]*tss.PartyID, map[string]*tss
 
 	this := idMap[self]
 	if this == nil {
-		return nil, nil, nil, fmt.Errorf("self party %s not in parties", self)
+		return partyIDs, idMap, nil, fmt.Errorf("self party %s not in parties", self)
 	}
 	return partyIDs, idMap, this, nil
 }
 
 func mustPartyIDs(parties []string) []*tss.PartyID {
-	ids, _, _, _ := makeParties(parties, parties[0]) // hack: returns list; ignore this
+	ids, _, _, _ := makeParties(parties, nil, parties[0]) // hack: returns list; ignore this
 	return ids
 }
 
-func persistKeygen(dir string, save keygen.LocalPartySaveData) error {
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return err
-	}
-	b, err := json.MarshalIndent(save, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(filepath.Join(dir, "keygen.json"), b, 0o600)
-}
-
-func loadKeygen(dir string) (keygen.LocalPartySaveData, error) {
-	b, err := os.ReadFile(filepath.Join(dir, "keygen.json"))
-	if err != nil {
-		return keygen.LocalPartySaveData{}, err
-	}
-	var save keygen.LocalPartySaveData
-	if err := json.Unmarshal(b, &save); err != nil {
-		return keygen.LocalPartySaveData{}, err
-	}
-	return save, nil
-}
-
 func decode32(h string) ([]byte, error) {
 	h = strings.TrimPrefix(h, "0x")
 	b, err := hex.DecodeString(h)
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// preParamsPool keeps up to target keygen.LocalPreParams ready so keygen does
+// not have to find safe primes in round 1. Each entry is persisted under
+// <data>/preparams/ (sealed like shares when a share key is set) and is
+// deleted when taken: pre-params must never back two keys.
+//
+// Generation uses every core for minutes, so it must not overlap a session
+// being measured: it starts only after the node has been idle for ppIdle, and
+// a session that starts meanwhile cancels it (see interrupt).
+type preParamsPool struct {
+	dir     string
+	target  int
+	timeout time.Duration
+	busy    func() bool // generation pauses while a TSS session runs
+
+	mu          sync.Mutex
+	ready       []string // file names, oldest first
+	generating  bool
+	stop        context.CancelFunc // cancels the running generation
+	lastSession time.Time
+	generated   int
+	interrupted int
+	lastGen     time.Duration
+	lastErr     string
+	wake        chan struct{}
+}
+
+// ppIdle is how long the node must be idle before generation (re)starts, so
+// back-to-back sessions of a benchmark do not each cancel a fresh run.
+const ppIdle = 5 * time.Second
+
+// preParamsFile is one pooled entry on disk.
+type preParamsFile struct {
+	Version   int                    `json:"version"`
+	Plain     *keygen.LocalPreParams `json:"preparams,omitempty"`
+	Sealed    *sealedShare           `json:"sealed,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+func newPreParamsPool(dataDir string, target int, timeout time.Duration, busy func() bool) (*preParamsPool, error) {
+	p := &preParamsPool{dir: filepath.Join(dataDir, "preparams"), target: target, timeout: timeout, busy: busy, wake: make(chan struct{}, 1)}
+	if target <= 0 {
+		return p, nil
+	}
+	if err := os.MkdirAll(p.dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			p.ready = append(p.ready, e.Name())
+		}
+	}
+	sort.Strings(p.ready)
+	go p.fill()
+	return p, nil
+}
+
+func ppAAD(name string) []byte { return []byte("tss-preparams|" + name) }
+
+// fill generates pre-params until the pool is full, then waits for take.
+func (p *preParamsPool) fill() {
+	for {
+		p.mu.Lock()
+		need := len(p.ready) < p.target
+		p.mu.Unlock()
+		if !need {
+			<-p.wake
+			continue
+		}
+		p.mu.Lock()
+		quiet := time.Since(p.lastSession) >= ppIdle
+		p.mu.Unlock()
+		if !quiet || p.busy() {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		p.mu.Lock()
+		p.generating, p.stop = true, cancel
+		p.mu.Unlock()
+		start := time.Now()
+		pre, err := keygen.GeneratePreParamsWithContext(ctx)
+		if err == nil {
+			err = p.store(pre)
+		}
+		cancel()
+		p.mu.Lock()
+		p.generating, p.stop = false, nil
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			p.interrupted++
+			p.mu.Unlock()
+			log.Printf("preparams: generation stopped after %s: a session started", time.Since(start).Round(time.Millisecond))
+			continue
+		}
+		if err != nil {
+			p.lastErr = err.Error()
+		} else {
+			p.generated++
+			p.lastGen = time.Since(start)
+			p.lastErr = ""
+		}
+		ready := len(p.ready)
+		p.mu.Unlock()
+		if err != nil {
+			log.Printf("preparams: generate: %v", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		log.Printf("preparams: generated in %s (%d/%d ready)", time.Since(start).Round(time.Millisecond), ready, p.target)
+	}
+}
+
+func (p *preParamsPool) store(pre *keygen.LocalPreParams) error {
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + ".json"
+	f := preParamsFile{Version: 1, CreatedAt: time.Now().UTC()}
+	if shareKey == nil {
+		f.Plain = pre
+	} else {
+		plain, err := json.Marshal(pre)
+		if err != nil {
+			return err
+		}
+		if f.Sealed, err = shareKey.seal(plain, ppAAD(name)); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(p.dir, name), b); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.ready = append(p.ready, name)
+	p.mu.Unlock()
+	return nil
+}
+
+// take removes and returns the oldest ready entry; nil if the pool is empty.
+func (p *preParamsPool) take() (*keygen.LocalPreParams, error) {
+	p.mu.Lock()
+	if len(p.ready) == 0 {
+		p.mu.Unlock()
+		return nil, nil
+	}
+	name := p.ready[0]
+	p.ready = p.ready[1:]
+	p.mu.Unlock()
+	defer p.poke()
+
+	path := filepath.Join(p.dir, name)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// delete first: an entry is single-use even if it turns out to be bad
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	var f preParamsFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("preparams %s: %w", name, err)
+	}
+	pre := f.Plain
+	if f.Sealed != nil {
+		plain, err := shareKey.open(f.Sealed, ppAAD(name))
+		if err != nil {
+			return nil, fmt.Errorf("preparams %s: %w", name, err)
+		}
+		pre = new(keygen.LocalPreParams)
+		if err := json.Unmarshal(plain, pre); err != nil {
+			return nil, fmt.Errorf("preparams %s: %w", name, err)
+		}
+	}
+	if pre == nil || !pre.ValidateWithProof() {
+		return nil, fmt.Errorf("preparams %s: invalid", name)
+	}
+	return pre, nil
+}
+
+// interrupt is called when a session starts: it cancels a running generation
+// and holds off the next one until the node has been idle for ppIdle.
+func (p *preParamsPool) interrupt() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastSession = time.Now()
+	if p.stop != nil {
+		p.stop()
+	}
+}
+
+func (p *preParamsPool) poke() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *preParamsPool) status() tssnet.PreParamsStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return tssnet.PreParamsStatus{Ready: len(p.ready), Target: p.target, Generating: p.generating, Generated: p.generated, Interrupted: p.interrupted, LastGenMs: p.lastGen.Milliseconds(), LastErr: p.lastErr}
+}
+
+// preParamsFor picks pre-params for a keygen: "cold" never uses the pool,
+// "warm" requires an entry, "" uses one if ready. Returns the mode used.
+func (p *preParamsPool) preParamsFor(mode string) (*keygen.LocalPreParams, string, error) {
+	switch mode {
+	case tssnet.PreParamsCold:
+		return nil, tssnet.PreParamsCold, nil
+	case tssnet.PreParamsWarm, "":
+	default:
+		return nil, "", fmt.Errorf("bad preparams mode %q", mode)
+	}
+	pre, err := p.take()
+	if err != nil {
+		log.Printf("preparams: %v", err)
+	}
+	if pre == nil {
+		if mode == tssnet.PreParamsWarm {
+			return nil, "", errors.New("no pre-params ready on this node")
+		}
+		return nil, tssnet.PreParamsCold, nil
+	}
+	return pre, tssnet.PreParamsWarm, nil
+}
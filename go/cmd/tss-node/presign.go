@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/common"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/signing"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+var (
+	presigMax = flag.Int("presig-max", 32, "presignatures kept in memory (oldest dropped first)")
+	presigTTL = flag.Duration("presig-ttl", time.Hour, "drop presignatures older than this")
+)
+
+// Presignatures split signing into an offline and an online phase. In
+// tss-lib's GG18 signing only rounds 5-9 use the message: rounds 1-4 (the
+// MtA exchanges, nearly all of the CPU and bytes) depend on the key and the
+// signing set alone. A "presign" session runs rounds 1-4 with a placeholder
+// message and parks the party once its own round 4 broadcast is out and its
+// peers' round 4 messages are in; those are held back, so the party cannot
+// enter round 5. A "sign" naming the presignature then writes the digest into
+// the party's message and hands it the held messages.
+//
+// This relies on signing.NewLocalParty keeping the *big.Int it is given
+// (tss-lib v2.0.2 stores it as temp.m and reads it only in rounds 1, 5 and 7).
+//
+// Presignatures live in memory only and are removed when taken, whatever the
+// outcome: signing two digests with the same k reveals the key.
+const presignHeldRound = 4
+
+// presig is a signing party parked after round 4.
+type presig struct {
+	id        string // job id of the presign session
+	keyID     string
+	parties   []string
+	threshold int
+	created   time.Time
+
+	m     *big.Int // the party's message: zero until the online phase
+	party tss.Party
+	idMap map[string]*tss.PartyID
+	outCh chan tss.Message
+	endCh chan *common.SignatureData
+
+	mu      sync.Mutex
+	held    []tss.ParsedMessage // peers' round 4 messages
+	ownSent bool                // this party's round 4 broadcast went out
+	done    chan struct{}       // closed when ownSent and every peer's round 4 is held
+}
+
+// hold keeps a peer's round 4 message for the online phase.
+func (p *presig) hold(msg tss.ParsedMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.held {
+		if h.GetFrom().Id == msg.GetFrom().Id {
+			return
+		}
+	}
+	p.held = append(p.held, msg)
+	p.checkLocked()
+}
+
+func (p *presig) sent() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ownSent = true
+	p.checkLocked()
+}
+
+func (p *presig) checkLocked() {
+	if p.ownSent && len(p.held) == len(p.parties)-1 {
+		select {
+		case <-p.done:
+		default:
+			close(p.done)
+		}
+	}
+}
+
+// runPresign runs the offline phase of a signing session for keyID and
+// parks the result under the session's job id.
+func runPresign(rt *runtime, c *tssnet.Conn, keyID string, parties []string, threshold int) error {
+	if len(parties) == 0 {
+		return errors.New("empty parties")
+	}
+	thisID := strings.TrimSpace(*partyStr)
+	sf, err := loadShare(*dataDir, keyID)
+	if err != nil {
+		return err
+	}
+	keys, err := sf.partyKeys(parties)
+	if err != nil {
+		return err
+	}
+	partyIDs, idMap, thisParty, err := makeParties(parties, keys, thisID)
+	if err != nil {
+		return err
+	}
+	params := tss.NewParameters(tss.S256(), tss.NewPeerContext(partyIDs), thisParty, len(partyIDs), threshold)
+
+	pre := &presig{keyID: keyID, parties: parties, threshold: threshold, m: new(big.Int), idMap: idMap, outCh: make(chan tss.Message, 1024), endCh: make(chan *common.SignatureData, 1), done: make(chan struct{})}
+	pre.party = signing.NewLocalParty(pre.m, params, sf.Share, pre.outCh, pre.endCh)
+
+	rt.mu.Lock()
+	rt.party = pre.party
+	rt.idMap = idMap
+	rt.parties = parties
+	rt.threshold = threshold
+	rt.presign = pre
+	aborted, job, trace := rt.abort, rt.job, rt.trace
+	rt.mu.Unlock()
+	pre.id = job
+
+	go func() {
+		if err := pre.party.Start(); err != nil {
+			rt.tssFailed(c, err)
+			return
+		}
+		rt.partyReady(c, job, "")
+	}()
+
+	forward := func(msg tss.Message) {
+		wire, routing, err := msg.WireBytes()
+		if err != nil {
+			return
+		}
+		to := routeToStrings(parties, routing, thisID)
+		trace.sent(msg.Type(), len(wire), len(to))
+		rt.sendWire(c, to, routing.From.Id, routing.IsBroadcast, msgRound(msg.Type()), wire)
+		if msgRound(msg.Type()) == presignHeldRound {
+			pre.sent()
+		}
+	}
+	for {
+		select {
+		case msg := <-pre.outCh:
+			forward(msg)
+		case <-pre.done:
+			pre.created = time.Now()
+			rt.parkPresig(pre)
+			rt.sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: thisID, Parties: []string{*gatewayParty}, Cmd: "presign_result", KeyID: keyID, Ok: true})
+			return nil
+		case err := <-aborted:
+			return err
+		}
+	}
+}
+
+// parkPresig adds pre to the pool, dropping expired and, over -presig-max,
+// the oldest presignatures.
+func (rt *runtime) parkPresig(pre *presig) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.expirePresigsLocked()
+	rt.presigs = append(rt.presigs, pre)
+	if n := len(rt.presigs) - *presigMax; n > 0 {
+		log.Printf("presig pool full: dropping %d oldest", n)
+		rt.presigs = rt.presigs[n:]
+	}
+}
+
+// expirePresigsLocked drops presignatures older than -presig-ttl; rt.mu must
+// be held.
+func (rt *runtime) expirePresigsLocked() {
+	kept := rt.presigs[:0]
+	for _, p := range rt.presigs {
+		if time.Since(p.created) <= *presigTTL {
+			kept = append(kept, p)
+		}
+	}
+	rt.presigs = kept
+}
+
+// takePresig removes the presignature id from the pool; it must be for keyID
+// with exactly this signing set.
+func (rt *runtime) takePresig(id, keyID string, parties []string, threshold int) (*presig, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.expirePresigsLocked()
+	for i, p := range rt.presigs {
+		if p.id != id {
+			continue
+		}
+		rt.presigs = append(rt.presigs[:i], rt.presigs[i+1:]...)
+		if p.keyID != keyID || p.threshold != threshold || strings.Join(p.parties, ",") != strings.Join(parties, ",") {
+			return nil, fmt.Errorf("presignature %s is for key %s, parties %v, t=%d", id, p.keyID, p.parties, p.threshold)
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("%s %s", tssnet.ErrNoPresig, id)
+}
+
+// dropPresigs forgets every presignature for keyID (its shares changed).
+func (rt *runtime) dropPresigs(keyID string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	kept := rt.presigs[:0]
+	for _, p := range rt.presigs {
+		if p.keyID != keyID {
+			kept = append(kept, p)
+		}
+	}
+	if n := len(rt.presigs) - len(kept); n > 0 {
+		log.Printf("key %s: dropped %d presignatures", keyID, n)
+	}
+	rt.presigs = kept
+}
+
+// presigCount is the number of presignatures per key (admin status).
+func (rt *runtime) presigCount() map[string]int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.expirePresigsLocked()
+	out := map[string]int{}
+	for _, p := range rt.presigs {
+		out[p.keyID]++
+	}
+	return out
+}
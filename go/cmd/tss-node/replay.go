@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+var (
+	replayFile = flag.String("replay", "", "coordinator trace recorded with -trace-payload: replay one job's inbound messages to this party offline, then exit")
+	replayJob  = flag.String("replay-job", "", "job to replay (default: the last sign or presign job of -party in the trace)")
+	replayStep = flag.Bool("replay-step", false, "wait for Enter before each replayed message")
+	replayWait = flag.Duration("replay-wait", 10*time.Second, "how long a replay waits for the party to start, and for the session to end after the last message")
+)
+
+// replayCmds are the jobs a replay may run: keygen and reshare would write
+// shares into -data.
+var replayCmds = map[string]bool{"sign": true, "presign": true}
+
+// runReplay feeds the messages the coordinator delivered to -party for one
+// job, in the recorded order, through the node's own receive/deliver path
+// with the local share, and reports after each one what the inbox did and
+// what the tss-lib party is waiting for. Nothing is sent anywhere: outgoing
+// messages are only counted.
+func runReplay() error {
+	recs, err := readTrace(*replayFile)
+	if err != nil {
+		return err
+	}
+	job := *replayJob
+	if job == "" {
+		for _, r := range recs {
+			if r.To == *partyStr && r.Msg != nil && replayCmds[r.Cmd] {
+				job = r.Job
+			}
+		}
+		if job == "" {
+			return fmt.Errorf("no sign or presign job for %s in %s (was the coordinator run with -trace-payload?)", *partyStr, *replayFile)
+		}
+	}
+
+	var in []tssnet.TraceRecord
+	recorded := map[string]bool{} // msg_ids this party sent in the live run
+	for _, r := range recs {
+		if r.Job != job || r.Msg == nil {
+			continue
+		}
+		if r.To == *partyStr {
+			in = append(in, r)
+		}
+		if r.From == *partyStr && r.Type == "send" {
+			recorded[r.MsgID] = true
+		}
+	}
+	// delivery order; a mailbox copy went out on reconnect, which the trace
+	// does not time, so it takes its place by arrival
+	at := func(r tssnet.TraceRecord) int64 {
+		if r.FwdUs == 0 {
+			return r.RecvUs
+		}
+		return r.FwdUs
+	}
+	sort.SliceStable(in, func(i, j int) bool { return at(in[i]) < at(in[j]) })
+	if len(in) == 0 || in[0].Type != "cmd" || !replayCmds[in[0].Cmd] {
+		return fmt.Errorf("job %s: trace has no sign or presign cmd delivered to %s", job, *partyStr)
+	}
+	fmt.Printf("replaying job %s (%s) for %s: %d inbound messages\n", job, in[0].Cmd, *partyStr, len(in))
+
+	var (
+		outMu   sync.Mutex
+		sent    = map[string]bool{}
+		results []tssnet.WSMessage
+	)
+	c := tssnet.NewLocalConn(func(m tssnet.WSMessage) {
+		outMu.Lock()
+		defer outMu.Unlock()
+		switch {
+		case m.Type == "send":
+			sent[m.MsgID] = true
+		case m.Type == "cmd" && strings.HasSuffix(m.Cmd, "_result"):
+			results = append(results, m)
+		}
+	})
+	rt := &runtime{}
+	stdin := bufio.NewReader(os.Stdin)
+	t0 := at(in[0])
+	for i, r := range in {
+		m := *r.Msg
+		note := ""
+		if r.Queued {
+			note = " (mailbox)"
+		}
+		fmt.Printf("#%d +%.1fms %s\n", i+1, float64(at(r)-t0)/1000, describe(m)+note)
+		if *replayStep {
+			fmt.Print("  [enter] ")
+			if _, err := stdin.ReadString('\n'); err != nil {
+				return err
+			}
+		}
+		before := rt.totals()
+		switch m.Type {
+		case "cmd":
+			if i > 0 {
+				handleCmd(rt, c, m)
+				break
+			}
+			// deliver the rest once the party has started: the live run may
+			// have buffered some as early, which is timing, not order
+			m.Deadline = 0 // long past; the node default applies
+			handleCmd(rt, c, m)
+			if !rt.waitUntil(*replayWait, func() bool { return rt.ready[""] || slices.Contains(rt.ended, job) }) {
+				return fmt.Errorf("party did not start within %s", *replayWait)
+			}
+		case "send":
+			rt.receive(c, m)
+		case "resume":
+			rt.peerResumed(c, m)
+		}
+		fmt.Printf("   inbox: %s  party: %s\n", wireDiff(before, rt.totals()), rt.partyState())
+	}
+
+	ended := rt.waitUntil(*replayWait, func() bool { return slices.Contains(rt.ended, job) })
+	outMu.Lock()
+	defer outMu.Unlock()
+	fmt.Printf("sent %d wire messages (live run: %d)\n", len(sent), len(recorded))
+	fmt.Printf("inbox totals: %s\n", rt.totals())
+	for _, res := range results {
+		if res.Ok {
+			fmt.Printf("result: %s ok\n", res.Cmd)
+		} else {
+			fmt.Printf("result: %s failed (round %d, culprits %v): %s\n", res.Cmd, res.Round, res.Culprits, res.Err)
+			// tss-lib draws this party's secrets afresh, so peers' answers to
+			// its live-run messages (GG18 signing: MtA in round 3) no longer fit
+			if res.Round > 1 {
+				fmt.Println("  (a replayed party's randomness differs from the live run: a proof failure from the first round that answers its own earlier messages is expected)")
+			}
+		}
+	}
+	if !ended {
+		fmt.Printf("stuck after the last message: %s\n", rt.partyState())
+	}
+	return nil
+}
+
+func readTrace(path string) ([]tssnet.TraceRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []tssnet.TraceRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1<<20), 64<<20)
+	for n := 1; sc.Scan(); n++ {
+		var r tssnet.TraceRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		out = append(out, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New(path + ": empty trace")
+	}
+	return out, nil
+}
+
+func describe(m tssnet.WSMessage) string {
+	switch m.Type {
+	case "send":
+		kind := "p2p"
+		if m.Bcast {
+			kind = "bcast"
+		}
+		s := fmt.Sprintf("%s round %d %s %s", m.Party, m.Round, kind, m.MsgID)
+		if m.Committee != "" {
+			s += " committee=" + m.Committee
+		}
+		return s
+	case "cmd":
+		return fmt.Sprintf("%s cmd %s", m.Party, m.Cmd)
+	}
+	return fmt.Sprintf("%s %s", m.Party, m.Type)
+}
+
+// totals is the node's wire counters including the running session's.
+func (rt *runtime) totals() wireStats {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	s := rt.stats
+	if rt.busy {
+		s.add(rt.wire)
+	}
+	return s
+}
+
+// partyState describes the running session's local party: its tss-lib round
+// and the peers it still waits for in that round.
+func (rt *runtime) partyState() string {
+	rt.mu.Lock()
+	busy, p := rt.busy, rt.party
+	rt.mu.Unlock()
+	if !busy {
+		return "session ended"
+	}
+	if p == nil {
+		return "not started"
+	}
+	var waiting []string
+	for _, id := range p.WaitingFor() {
+		waiting = append(waiting, id.Id)
+	}
+	return fmt.Sprintf("%s, waiting for %v", p, waiting)
+}
+
+// wireDiff names the counters that moved between two snapshots.
+func wireDiff(a, b wireStats) string {
+	var out []string
+	for _, f := range []struct {
+		name string
+		d    uint64
+	}{
+		{"delivered", b.Delivered - a.Delivered},
+		{"early", b.Early - a.Early},
+		{"duplicate", b.Duplicate - a.Duplicate},
+		{"late", b.Late - a.Late},
+		{"dropped", b.Dropped - a.Dropped},
+		{"rejected", b.Rejected - a.Rejected},
+	} {
+		if f.d > 0 {
+			out = append(out, fmt.Sprintf("%s+%d", f.name, f.d))
+		}
+	}
+	if len(out) == 0 {
+		return "-"
+	}
+	return strings.Join(out, " ")
+}
+
+// waitUntil polls cond, called with rt.mu held, until it holds or d has
+// passed.
+func (rt *runtime) waitUntil(d time.Duration, cond func() bool) bool {
+	check := func() bool {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		return cond()
+	}
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if check() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return check()
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/resharing"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// Resharing runs up to two tss-lib parties on a node: one in the old
+// committee (holding the current share) and one in the new committee. Their
+// party IDs differ (new ones get newIDSuffix) and every wire message carries
+// the committee of the receiving instance.
+//
+// The new share is staged as keys/<id>.json.next and only installed on
+// "reshare_commit", after the gateway has checked every new party; the old
+// share moves to keys/<id>.json.prev. Once every new party has committed,
+// old-only parties delete their share on "reshare_retire" and new parties
+// drop .prev on "reshare_finalize". Until then "reshare_abort" rolls the node
+// back. Each step is recorded in keys/<id>.json.reshare and may be repeated.
+const (
+	committeeOld = "old"
+	committeeNew = "new"
+	newIDSuffix  = "/new"
+)
+
+func nextSharePath(dir, keyID string) string    { return sharePath(dir, keyID) + ".next" }
+func prevSharePath(dir, keyID string) string    { return sharePath(dir, keyID) + ".prev" }
+func reshareStatePath(dir, keyID string) string { return sharePath(dir, keyID) + ".reshare" }
+
+func loadReshareState(dir, keyID string) (*tssnet.ReshareState, error) {
+	b, err := os.ReadFile(reshareStatePath(dir, keyID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st tssnet.ReshareState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("reshare state of %s: %w", keyID, err)
+	}
+	return &st, nil
+}
+
+func saveReshareState(dir, keyID string, st tssnet.ReshareState) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(reshareStatePath(dir, keyID), b)
+}
+
+// pendingReshare returns the recorded state of job for keyID, nil if none is
+// recorded, or an error if a different reshare is pending.
+func pendingReshare(keyID, job string) (*tssnet.ReshareState, error) {
+	st, err := loadReshareState(*dataDir, keyID)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	if st.Job != job {
+		return nil, fmt.Errorf("key %s has reshare %s pending, not %s", keyID, st.Job, job)
+	}
+	return st, nil
+}
+
+// isNewShare reports whether f is the share a reshare produced.
+func isNewShare(f shareFile, spec tssnet.ReshareSpec) bool {
+	return strings.Join(f.Parties, ",") == strings.Join(spec.NewParties, ",") &&
+		strings.Join(f.PartyKeys, ",") == strings.Join(spec.NewKeys, ",")
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func parseKeys(keys []string, n int) ([]*big.Int, error) {
+	if len(keys) != n {
+		return nil, fmt.Errorf("got %d party keys for %d parties", len(keys), n)
+	}
+	out := make([]*big.Int, n)
+	for i, k := range keys {
+		v, ok := new(big.Int).SetString(k, 10)
+		if !ok || v.Sign() <= 0 {
+			return nil, fmt.Errorf("bad party key %q", k)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func withSuffix(parties []string, suffix string) []string {
+	out := make([]string, len(parties))
+	for i, p := range parties {
+		out[i] = p + suffix
+	}
+	return out
+}
+
+func runReshare(rt *runtime, c *tssnet.Conn, keyID string, spec tssnet.ReshareSpec, preMode string) error {
+	if err := tssnet.ValidKeyID(keyID); err != nil {
+		return err
+	}
+	// a staged reshare that was never committed here (its abort got lost) is
+	// replaced; a committed one must be finished or rolled back first
+	if st, err := loadReshareState(*dataDir, keyID); err != nil {
+		return err
+	} else if st != nil && st.Phase == tssnet.ResharePhaseCommitted {
+		return fmt.Errorf("reshare %s of key %s is not finished; finish or roll it back first", st.Job, keyID)
+	} else if st != nil {
+		log.Printf("reshare: key %s: dropping uncommitted reshare %s", keyID, st.Job)
+		if err := abortReshare(keyID, st.Job); err != nil {
+			return err
+		}
+	}
+	thisID := strings.TrimSpace(*partyStr)
+	inOld, inNew := slices.Contains(spec.OldParties, thisID), slices.Contains(spec.NewParties, thisID)
+	if !inOld && !inNew {
+		return errors.New("not in old or new committee")
+	}
+	if spec.NewThreshold < 1 || spec.NewThreshold >= len(spec.NewParties) {
+		return fmt.Errorf("bad new threshold=%d for n=%d", spec.NewThreshold, len(spec.NewParties))
+	}
+	oldKeys, err := parseKeys(spec.OldKeys, len(spec.OldParties))
+	if err != nil {
+		return fmt.Errorf("old committee: %w", err)
+	}
+	newKeys, err := parseKeys(spec.NewKeys, len(spec.NewParties))
+	if err != nil {
+		return fmt.Errorf("new committee: %w", err)
+	}
+	oldIDs, oldMap, oldSelf, err := makeParties(spec.OldParties, oldKeys, thisID)
+	if err != nil && inOld {
+		return err
+	}
+	newIDs, newMap, newSelf, err := makeParties(withSuffix(spec.NewParties, newIDSuffix), newKeys, thisID+newIDSuffix)
+	if err != nil && inNew {
+		return err
+	}
+	oldCtx, newCtx := tss.NewPeerContext(oldIDs), tss.NewPeerContext(newIDs)
+	idMap := map[string]*tss.PartyID{}
+	for id, p := range oldMap {
+		idMap[id] = p
+	}
+	for id, p := range newMap {
+		if _, dup := idMap[id]; dup {
+			return fmt.Errorf("party id %s in both committees", id)
+		}
+		idMap[id] = p
+	}
+	isNewID := map[string]bool{}
+	for id := range newMap {
+		isNewID[id] = true
+	}
+
+	outCh := make(chan tss.Message, 1024)
+	oldEnd := make(chan *keygen.LocalPartySaveData, 1)
+	newEnd := make(chan *keygen.LocalPartySaveData, 1)
+	committees := map[string]tss.Party{}
+
+	if inOld {
+		sf, err := loadShare(*dataDir, keyID)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sf.PubKeyHex, spec.PubKeyHex) {
+			return fmt.Errorf("local share is for %s, not %s", sf.PubKeyHex, spec.PubKeyHex)
+		}
+		if sf.Share.ShareID == nil || sf.Share.ShareID.Cmp(oldSelf.KeyInt()) != 0 {
+			return errors.New("old party keys do not match the local share")
+		}
+		params := tss.NewReSharingParameters(tss.S256(), oldCtx, newCtx, oldSelf, len(oldIDs), spec.OldThreshold, len(newIDs), spec.NewThreshold)
+		committees[committeeOld] = resharing.NewLocalParty(params, sf.Share, outCh, oldEnd)
+	}
+	if inNew {
+		pre, _, err := pool.preParamsFor(preMode)
+		if err != nil {
+			return err
+		}
+		save := keygen.NewLocalPartySaveData(len(newIDs))
+		if pre != nil {
+			save.LocalPreParams = *pre
+		}
+		params := tss.NewReSharingParameters(tss.S256(), oldCtx, newCtx, newSelf, len(oldIDs), spec.OldThreshold, len(newIDs), spec.NewThreshold)
+		committees[committeeNew] = resharing.NewLocalParty(params, save, outCh, newEnd)
+	}
+
+	rt.mu.Lock()
+	rt.committees = committees
+	rt.idMap = idMap
+	aborted, job, trace := rt.abort, rt.job, rt.trace
+	rt.mu.Unlock()
+
+	// new parties first: they wait for the old committee's round 1
+	for _, name := range []string{committeeNew, committeeOld} {
+		if p := committees[name]; p != nil {
+			go func(name string, p tss.Party) {
+				if err := p.Start(); err != nil {
+					rt.tssFailed(c, err)
+					return
+				}
+				rt.partyReady(c, job, name)
+			}(name, p)
+		}
+	}
+
+	var newSave *keygen.LocalPartySaveData
+	pending := len(committees)
+	forward := func(msg tss.Message) {
+		wire, routing, err := msg.WireBytes()
+		if err != nil {
+			return
+		}
+		trace.sent(msg.Type(), len(wire), len(routing.To))
+		rt.sendReshareWire(c, routing, isNewID, msgRound(msg.Type()), wire)
+	}
+	for pending > 0 {
+		select {
+		case msg := <-outCh:
+			forward(msg)
+		case <-oldEnd:
+			drain(outCh, forward)
+			pending--
+		case save := <-newEnd:
+			drain(outCh, forward)
+			if save == nil {
+				return errors.New("nil reshare result")
+			}
+			newSave = save
+			pending--
+		case err := <-aborted:
+			return err
+		}
+	}
+
+	state := tssnet.ReshareState{Job: job, Phase: tssnet.ResharePhaseStaged, Spec: spec}
+	if !inNew {
+		// recorded so that retire (and a rollback) can check the job
+		if err := saveReshareState(*dataDir, keyID, state); err != nil {
+			return err
+		}
+		rt.sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: thisID, Parties: []string{*gatewayParty}, Cmd: "reshare_result", KeyID: keyID, Ok: true})
+		return nil
+	}
+	report, err := checkNewShare(*newSave, spec)
+	if err != nil {
+		return err
+	}
+	pub, addr, _ := pubAndAddr(*newSave)
+	sf := shareFile{KeyID: keyID, Parties: spec.NewParties, Threshold: spec.NewThreshold, PartyKeys: spec.NewKeys, CreatedAt: time.Now().UTC(), PubKeyHex: pub, Address: addr, Share: *newSave}
+	b, err := encodeShare(sf, shareKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(*dataDir, "keys"), 0o700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(nextSharePath(*dataDir, keyID), b); err != nil {
+		return fmt.Errorf("stage key %s: %w", keyID, err)
+	}
+	if err := saveReshareState(*dataDir, keyID, state); err != nil {
+		return err
+	}
+	rt.sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: thisID, Parties: []string{*gatewayParty}, Cmd: "reshare_result", KeyID: keyID, Ok: true, PubKeyHex: pub, AddrHex: addr, Payload: tssnet.MustJSON(report)})
+	return nil
+}
+
+// sendReshareWire splits a message's recipients by committee: one relay
+// message per committee, addressed to node names.
+func (rt *runtime) sendReshareWire(c *tssnet.Conn, routing *tss.MessageRouting, isNewID map[string]bool, round int, wire []byte) {
+	byCommittee := map[string][]string{}
+	for _, p := range routing.To {
+		if p == nil {
+			continue
+		}
+		if isNewID[p.Id] {
+			byCommittee[committeeNew] = append(byCommittee[committeeNew], strings.TrimSuffix(p.Id, newIDSuffix))
+		} else {
+			byCommittee[committeeOld] = append(byCommittee[committeeOld], p.Id)
+		}
+	}
+	for committee, to := range byCommittee {
+		rt.relay(c, tssnet.WSMessage{Type: "send", Session: *clusterSession, Party: *partyStr, From: routing.From.Id, To: to, Bcast: routing.IsBroadcast, Committee: committee, Round: round, PayloadB64: base64.StdEncoding.EncodeToString(wire)})
+	}
+}
+
+// checkNewShare verifies a new-committee share: same public key, own public
+// share matches Xi, and any t+1 public shares interpolate to the public key.
+func checkNewShare(save keygen.LocalPartySaveData, spec tssnet.ReshareSpec) (tssnet.ReshareReport, error) {
+	pub, _, err := pubAndAddr(save)
+	if err != nil {
+		return tssnet.ReshareReport{}, err
+	}
+	if !strings.EqualFold(pub, spec.PubKeyHex) {
+		return tssnet.ReshareReport{}, fmt.Errorf("public key changed: %s", pub)
+	}
+	i, err := save.OriginalIndex()
+	if err != nil {
+		return tssnet.ReshareReport{}, err
+	}
+	if !crypto.ScalarBaseMult(tss.S256(), save.Xi).Equals(save.BigXj[i]) {
+		return tssnet.ReshareReport{}, errors.New("Xi does not match BigXj")
+	}
+	if len(save.Ks) != len(save.BigXj) || len(save.BigXj) < spec.NewThreshold+1 {
+		return tssnet.ReshareReport{}, errors.New("incomplete share data")
+	}
+	y, err := interpolateAtZero(save.Ks[:spec.NewThreshold+1], save.BigXj[:spec.NewThreshold+1])
+	if err != nil {
+		return tssnet.ReshareReport{}, err
+	}
+	if !y.Equals(save.ECDSAPub) {
+		return tssnet.ReshareReport{}, errors.New("public shares do not interpolate to the public key")
+	}
+	h := sha256.New()
+	for _, X := range save.BigXj {
+		h.Write(X.X().FillBytes(make([]byte, 32)))
+		h.Write(X.Y().FillBytes(make([]byte, 32)))
+	}
+	return tssnet.ReshareReport{PubKeyHex: pub, BigXjHash: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// interpolateAtZero computes sum(lambda_j * X_j) with Lagrange coefficients at 0.
+func interpolateAtZero(ks []*big.Int, xs []*crypto.ECPoint) (*crypto.ECPoint, error) {
+	n := tss.S256().Params().N
+	var sum *crypto.ECPoint
+	for j := range ks {
+		num, den := big.NewInt(1), big.NewInt(1)
+		for m := range ks {
+			if m == j {
+				continue
+			}
+			num.Mul(num, ks[m]).Mod(num, n)
+			d := new(big.Int).Sub(ks[m], ks[j])
+			den.Mul(den, d.Mod(d, n)).Mod(den, n)
+		}
+		if den.Sign() == 0 {
+			return nil, errors.New("duplicate party keys")
+		}
+		lambda := num.Mul(num, new(big.Int).ModInverse(den, n)).Mod(num, n)
+		term := xs[j].ScalarMult(lambda)
+		if sum == nil {
+			sum = term
+			continue
+		}
+		var err error
+		if sum, err = sum.Add(term); err != nil {
+			return nil, err
+		}
+	}
+	return sum, nil
+}
+
+// commitReshare installs the staged share and keeps the old one as .prev. A
+// repeated commit (the gateway retrying, or a crash halfway) is a no-op or
+// finishes the moves left undone.
+func commitReshare(keyID, job string) error {
+	st, err := pendingReshare(keyID, job)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return fmt.Errorf("no reshare %s pending for key %s", job, keyID)
+	}
+	if st.Phase == tssnet.ResharePhaseCommitted {
+		return nil
+	}
+	if !slices.Contains(st.Spec.NewParties, *partyStr) {
+		return errors.New("not in the new committee")
+	}
+	cur, prev, next := sharePath(*dataDir, keyID), prevSharePath(*dataDir, keyID), nextSharePath(*dataDir, keyID)
+	b, err := os.ReadFile(next)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// crashed after installing it
+		if f, err := loadShare(*dataDir, keyID); err != nil || !isNewShare(f, st.Spec) {
+			return fmt.Errorf("no staged share for %s", keyID)
+		}
+	case err != nil:
+		return err
+	default:
+		if _, err := decodeShare(b, shareKey); err != nil {
+			return fmt.Errorf("staged share for %s: %w", keyID, err)
+		}
+		// a legacy keygen.json needs no move: keys/<id>.json shadows it
+		if _, err := os.Stat(cur); err == nil {
+			if _, err := os.Stat(prev); errors.Is(err, os.ErrNotExist) {
+				if err := os.Rename(cur, prev); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(next, cur); err != nil {
+			return err
+		}
+	}
+	st.Phase = tssnet.ResharePhaseCommitted
+	if err := saveReshareState(*dataDir, keyID, *st); err != nil {
+		return err
+	}
+	log.Printf("reshare: key %s committed (job %s); old share kept until finalize", keyID, job)
+	return nil
+}
+
+// finalizeReshare drops the old share of a new-committee party once every new
+// party has committed.
+func finalizeReshare(keyID, job string) error {
+	st, err := pendingReshare(keyID, job)
+	if err != nil || st == nil {
+		return err // nil: already finalized
+	}
+	if st.Phase != tssnet.ResharePhaseCommitted {
+		return fmt.Errorf("reshare %s of key %s is not committed on this node", job, keyID)
+	}
+	if err := removeIfExists(prevSharePath(*dataDir, keyID)); err != nil {
+		return err
+	}
+	if keyID == tssnet.DefaultKeyID {
+		if err := removeIfExists(filepath.Join(*dataDir, legacyShareFile)); err != nil {
+			return err
+		}
+	}
+	if err := removeIfExists(reshareStatePath(*dataDir, keyID)); err != nil {
+		return err
+	}
+	log.Printf("reshare: key %s finalized (job %s)", keyID, job)
+	return nil
+}
+
+// retireShare deletes the share of a party that left the committee: with the
+// new shares committed, the old one must not stay usable.
+func retireShare(keyID, job string) error {
+	st, err := pendingReshare(keyID, job)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		if shareExists(*dataDir, keyID) {
+			return fmt.Errorf("no reshare %s pending for key %s; share not deleted", job, keyID)
+		}
+		return nil // already retired
+	}
+	if slices.Contains(st.Spec.NewParties, *partyStr) {
+		return errors.New("party is in the new committee")
+	}
+	paths := []string{sharePath(*dataDir, keyID), prevSharePath(*dataDir, keyID), nextSharePath(*dataDir, keyID)}
+	if keyID == tssnet.DefaultKeyID {
+		paths = append(paths, filepath.Join(*dataDir, legacyShareFile))
+	}
+	// the state goes last so that a failed delete can be retried
+	for _, p := range append(paths, reshareStatePath(*dataDir, keyID)) {
+		if err := removeIfExists(p); err != nil {
+			return err
+		}
+	}
+	log.Printf("reshare: key %s retired on this node (job %s)", keyID, job)
+	return nil
+}
+
+// abortReshare rolls job back: a staged share is dropped and a committed one
+// is replaced by the old share again. A party with no old share (new-only, or
+// a legacy keygen.json shadowed by keys/<id>.json) deletes the new one.
+func abortReshare(keyID, job string) error {
+	st, err := pendingReshare(keyID, job)
+	if err != nil {
+		return err
+	}
+	if st != nil && st.Phase == tssnet.ResharePhaseCommitted {
+		cur, prev := sharePath(*dataDir, keyID), prevSharePath(*dataDir, keyID)
+		if _, err := os.Stat(prev); err == nil {
+			if err := os.Rename(prev, cur); err != nil {
+				return err
+			}
+		} else if f, err := loadShare(*dataDir, keyID); err == nil && isNewShare(f, st.Spec) {
+			if err := os.Remove(cur); err != nil {
+				return err
+			}
+		}
+		log.Printf("reshare: key %s rolled back to the old share (job %s)", keyID, job)
+	}
+	if err := removeIfExists(nextSharePath(*dataDir, keyID)); err != nil {
+		return err
+	}
+	if st == nil {
+		return nil
+	}
+	return removeIfExists(reshareStatePath(*dataDir, keyID))
+}
+
+func handleReshareCmd(rt *runtime, c *tssnet.Conn, m tssnet.WSMessage, keyID string) {
+	var err error
+	switch m.Cmd {
+	case "reshare":
+		var spec tssnet.ReshareSpec
+		if err = json.Unmarshal(m.Payload, &spec); err == nil {
+			err = runReshare(rt, c, keyID, spec, m.PreParams)
+		}
+		if err != nil {
+			// leaves a different pending reshare alone
+			_ = abortReshare(keyID, m.Job)
+		}
+	case "reshare_commit":
+		err = commitReshare(keyID, m.Job)
+		rt.dropPresigs(keyID)
+	case "reshare_finalize":
+		err = finalizeReshare(keyID, m.Job)
+	case "reshare_retire":
+		err = retireShare(keyID, m.Job)
+		rt.dropPresigs(keyID)
+	case "reshare_abort":
+		err = abortReshare(keyID, m.Job)
+		rt.dropPresigs(keyID)
+	}
+	switch {
+	case err != nil:
+		rt.sendResult(c, failResult(m.Cmd+"_result", keyID, err))
+	case m.Cmd != "reshare":
+		rt.sendResult(c, tssnet.WSMessage{Type: "cmd", Session: *clusterSession, Party: *partyStr, Parties: []string{*gatewayParty}, Cmd: m.Cmd + "_result", KeyID: keyID, Ok: true})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// stageReshare sets up P1's data dir as runReshare leaves it: the old share
+// in place, the new one staged and the state recorded.
+func stageReshare(t *testing.T) (old, next shareFile, spec tssnet.ReshareSpec) {
+	t.Helper()
+	dir := t.TempDir()
+	prevDir, prevParty, prevKey := *dataDir, *partyStr, shareKey
+	*dataDir, *partyStr, shareKey = dir, "P1", nil
+	t.Cleanup(func() { *dataDir, *partyStr, shareKey = prevDir, prevParty, prevKey })
+
+	old = testShare(t)
+	spec = tssnet.ReshareSpec{PubKeyHex: old.PubKeyHex, OldParties: old.Parties, OldKeys: old.PartyKeys, OldThreshold: 1,
+		NewParties: []string{"P1", "P2", "P4"}, NewKeys: []string{"7", "8", "9"}, NewThreshold: 1}
+	next = old
+	next.Parties, next.PartyKeys = spec.NewParties, spec.NewKeys
+	if err := os.MkdirAll(filepath.Join(dir, "keys"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for path, f := range map[string]shareFile{sharePath(dir, old.KeyID): old, nextSharePath(dir, old.KeyID): next} {
+		b, err := encodeShare(f, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileAtomic(path, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := saveReshareState(dir, old.KeyID, tssnet.ReshareState{Job: "j1", Phase: tssnet.ResharePhaseStaged, Spec: spec}); err != nil {
+		t.Fatal(err)
+	}
+	return old, next, spec
+}
+
+func installedKeys(t *testing.T, keyID string) string {
+	t.Helper()
+	f, err := loadShare(*dataDir, keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.PartyKeys[0]
+}
+
+func TestReshareCommitFinalize(t *testing.T) {
+	old, _, _ := stageReshare(t)
+	if err := commitReshare(old.KeyID, "other"); err == nil {
+		t.Fatal("commit of another job succeeded")
+	}
+	for i := 0; i < 2; i++ { // a repeated commit is a no-op
+		if err := commitReshare(old.KeyID, "j1"); err != nil {
+			t.Fatalf("commit #%d: %v", i+1, err)
+		}
+	}
+	if got := installedKeys(t, old.KeyID); got != "7" {
+		t.Fatalf("installed share has key %s, want the new one", got)
+	}
+	if _, err := os.Stat(prevSharePath(*dataDir, old.KeyID)); err != nil {
+		t.Fatalf("old share not kept as .prev: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := finalizeReshare(old.KeyID, "j1"); err != nil {
+			t.Fatalf("finalize #%d: %v", i+1, err)
+		}
+	}
+	for _, p := range []string{prevSharePath(*dataDir, old.KeyID), reshareStatePath(*dataDir, old.KeyID)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s left after finalize", filepath.Base(p))
+		}
+	}
+}
+
+func TestReshareRollback(t *testing.T) {
+	cases := []struct {
+		name   string
+		commit bool
+	}{
+		{"staged", false},
+		{"committed", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			old, _, _ := stageReshare(t)
+			if tc.commit {
+				if err := commitReshare(old.KeyID, "j1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				if err := abortReshare(old.KeyID, "j1"); err != nil {
+					t.Fatalf("abort #%d: %v", i+1, err)
+				}
+			}
+			if got := installedKeys(t, old.KeyID); got != old.PartyKeys[0] {
+				t.Fatalf("share after rollback has key %s, want the old one", got)
+			}
+			for _, p := range []string{nextSharePath(*dataDir, old.KeyID), prevSharePath(*dataDir, old.KeyID), reshareStatePath(*dataDir, old.KeyID)} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Fatalf("%s left after rollback", filepath.Base(p))
+				}
+			}
+		})
+	}
+}
+
+func TestRetireShare(t *testing.T) {
+	old, _, _ := stageReshare(t)
+	if err := retireShare(old.KeyID, "j1"); err == nil {
+		t.Fatal("P1 (in the new committee) retired its share")
+	}
+	*partyStr = "P3" // leaves the committee
+	for i := 0; i < 2; i++ {
+		if err := retireShare(old.KeyID, "j1"); err != nil {
+			t.Fatalf("retire #%d: %v", i+1, err)
+		}
+	}
+	if shareExists(*dataDir, old.KeyID) {
+		t.Fatal("share left after retire")
+	}
+}
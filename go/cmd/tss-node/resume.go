@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// outboxMax bounds the wire messages kept for resending per session; a
+// 5-party sign sends a few dozen, keygen and reshare a few hundred at most.
+const outboxMax = 1024
+
+// seenMax is how many job ids a node remembers, to skip cmds the coordinator
+// replays after a reconnect and to tell late wire messages from early ones.
+const seenMax = 64
+
+// relay sends a wire message of the running session and keeps a copy, so it
+// can be sent again to a peer that lost its connection (see peerResumed). The
+// job and msg_id let receivers buffer early and drop repeated messages. With
+// -e2e unicast messages are sealed first (see e2e.go).
+func (rt *runtime) relay(c *tssnet.Conn, m tssnet.WSMessage) {
+	rt.mu.Lock()
+	rt.seq++
+	m.Job, m.MsgID = rt.job, fmt.Sprintf("%s#%d", m.From, rt.seq)
+	rt.mu.Unlock()
+	out, err := sealWire(m)
+	if err != nil {
+		rt.fail(fmt.Errorf("e2e: %w", err))
+		return
+	}
+	rt.mu.Lock()
+	if rt.busy && len(rt.outbox)+len(out) <= outboxMax {
+		rt.outbox = append(rt.outbox, out...)
+	}
+	rt.mu.Unlock()
+	for _, o := range out {
+		writeWS(c, o)
+	}
+}
+
+// seenJob records that the session-starting cmd of job ran (or runs) here and
+// reports whether it had already been seen.
+func (rt *runtime) seenJob(job string) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if slices.Contains(rt.seen, job) {
+		return true
+	}
+	rt.seen = rememberJob(rt.seen, job)
+	return false
+}
+
+// rememberJob appends job to one of the runtime's recent job lists, keeping
+// the last seenMax.
+func rememberJob(list []string, job string) []string {
+	if len(list) == seenMax {
+		list = list[1:]
+	}
+	return append(list, job)
+}
+
+// resumed handles the coordinator's "welcome" after a reconnect: messages this
+// node sent while the link was breaking may be lost, so the running session's
+// outbox goes again to every peer that is connected now. Peers still offline
+// get it when they resume themselves.
+func (rt *runtime) resumed(c *tssnet.Conn, m tssnet.WSMessage) {
+	if !m.Resume {
+		return
+	}
+	rt.mu.Lock()
+	job, peers := rt.job, rt.peers
+	rt.mu.Unlock()
+	if job == "" {
+		log.Printf("reconnected; no session running")
+		return
+	}
+	var online, offline []string
+	for _, p := range peers {
+		switch {
+		case p == *partyStr:
+		case slices.Contains(m.Parties, p):
+			online = append(online, p)
+		default:
+			offline = append(offline, p)
+		}
+	}
+	log.Printf("reconnected during job %s; peers online %v, offline %v", job, online, offline)
+	rt.resend(c, job, online)
+}
+
+// peerResumed handles a "resume" notice: a peer of the running session
+// reconnected and may have missed what this node sent meanwhile.
+func (rt *runtime) peerResumed(c *tssnet.Conn, m tssnet.WSMessage) {
+	rt.mu.Lock()
+	match := rt.busy && m.Job != "" && rt.job == m.Job && m.Party != *partyStr && slices.Contains(rt.peers, m.Party)
+	rt.mu.Unlock()
+	if match {
+		rt.resend(c, m.Job, []string{m.Party})
+	}
+}
+
+// resend sends the outbox of job again, each message only to those of its
+// recipients listed in to. tss-lib stores a repeated message in the same slot,
+// so a copy the peer already had does no harm.
+func (rt *runtime) resend(c *tssnet.Conn, job string, to []string) {
+	rt.mu.Lock()
+	if rt.job != job {
+		rt.mu.Unlock()
+		return
+	}
+	out := append([]tssnet.WSMessage(nil), rt.outbox...)
+	rt.mu.Unlock()
+	n := 0
+	for _, m := range out {
+		var dst []string
+		for _, p := range m.To {
+			if slices.Contains(to, p) {
+				dst = append(dst, p)
+			}
+		}
+		if len(dst) == 0 {
+			continue
+		}
+		m.To = dst
+		writeWS(c, m)
+		n++
+	}
+	if n > 0 {
+		log.Printf("job %s: resent %d messages to %v", job, n, to)
+	}
+}
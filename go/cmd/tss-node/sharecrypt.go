@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// Sealed shares: AES-256-GCM with a key read from -share-key-file or derived
+// from a passphrase with scrypt. Metadata stays in clear (the gateway's "keys"
+// query needs no unlock) but is bound to the ciphertext as AAD.
+const (
+	kdfScrypt  = "scrypt"
+	kdfKeyFile = "keyfile"
+	cipherGCM  = "aes-256-gcm"
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// sealedShare is the "sealed" part of a v2 share file.
+type sealedShare struct {
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt,omitempty"`
+	N          int    `json:"n,omitempty"`
+	R          int    `json:"r,omitempty"`
+	P          int    `json:"p,omitempty"`
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// shareCipher holds the unlocked share key. A nil *shareCipher means shares
+// are stored in plaintext.
+type shareCipher struct {
+	kdf  string
+	pass []byte
+	salt []byte // used for new shares; key is derived once at startup
+	key  []byte
+
+	mu   sync.Mutex
+	keys map[string][]byte // hex(salt) -> derived key, for shares sealed under another salt
+}
+
+// newShareCipher reads the key file or passphrase file; both empty => nil.
+func newShareCipher(keyFile, passFile string) (*shareCipher, error) {
+	switch {
+	case keyFile != "" && passFile != "":
+		return nil, errors.New("use either -share-key-file or -share-pass-file, not both")
+	case keyFile != "":
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseRawKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", keyFile, err)
+		}
+		return &shareCipher{kdf: kdfKeyFile, key: key}, nil
+	case passFile != "":
+		b, err := os.ReadFile(passFile)
+		if err != nil {
+			return nil, err
+		}
+		pass := bytes.TrimRight(b, "\r\n")
+		if len(pass) == 0 {
+			return nil, fmt.Errorf("%s: empty passphrase", passFile)
+		}
+		sc := &shareCipher{kdf: kdfScrypt, pass: pass, salt: make([]byte, 16), keys: map[string][]byte{}}
+		if _, err := rand.Read(sc.salt); err != nil {
+			return nil, err
+		}
+		if sc.key, err = sc.derive(sc.salt, scryptN, scryptR, scryptP); err != nil {
+			return nil, err
+		}
+		return sc, nil
+	}
+	return nil, nil
+}
+
+// parseRawKey accepts 32 raw bytes or 64 hex characters.
+func parseRawKey(b []byte) ([]byte, error) {
+	if len(b) == 32 {
+		return b, nil
+	}
+	s := strings.TrimPrefix(strings.TrimSpace(string(b)), "0x")
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("key file must hold 32 raw bytes or 64 hex characters")
+	}
+	return key, nil
+}
+
+func (sc *shareCipher) derive(salt []byte, n, r, p int) ([]byte, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if k, ok := sc.keys[hex.EncodeToString(salt)]; ok {
+		return k, nil
+	}
+	k, err := scrypt.Key(sc.pass, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	sc.keys[hex.EncodeToString(salt)] = k
+	return k, nil
+}
+
+func (sc *shareCipher) seal(plain, aad []byte) (*sealedShare, error) {
+	aead, err := newGCM(sc.key)
+	if err != nil {
+		return nil, err
+	}
+	s := &sealedShare{KDF: sc.kdf, Cipher: cipherGCM, Nonce: make([]byte, aead.NonceSize())}
+	if sc.kdf == kdfScrypt {
+		s.Salt, s.N, s.R, s.P = sc.salt, scryptN, scryptR, scryptP
+	}
+	if _, err := rand.Read(s.Nonce); err != nil {
+		return nil, err
+	}
+	s.Ciphertext = aead.Seal(nil, s.Nonce, plain, aad)
+	return s, nil
+}
+
+func (sc *shareCipher) open(s *sealedShare, aad []byte) ([]byte, error) {
+	if sc == nil {
+		return nil, errors.New("share is encrypted; start the node with -share-pass-file or -share-key-file")
+	}
+	if s.Cipher != cipherGCM {
+		return nil, fmt.Errorf("unsupported cipher %q", s.Cipher)
+	}
+	if s.KDF != sc.kdf {
+		return nil, fmt.Errorf("share sealed with kdf %q but node unlocked with %q", s.KDF, sc.kdf)
+	}
+	key := sc.key
+	if s.KDF == kdfScrypt {
+		var err error
+		if key, err = sc.derive(s.Salt, s.N, s.R, s.P); err != nil {
+			return nil, err
+		}
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, s.Nonce, s.Ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("cannot decrypt share (wrong passphrase/key or tampered file)")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// checkShares unlocks every stored share at startup so a wrong passphrase or
+// a leftover plaintext share fails fast instead of at the first signing.
+func checkShares(dir string, sc *shareCipher) error {
+	shares, err := listShares(dir)
+	if err != nil {
+		return err
+	}
+	var plain []string
+	for _, f := range shares {
+		if f.Sealed == nil {
+			plain = append(plain, f.KeyID)
+		}
+	}
+	if len(plain) > 0 {
+		if sc != nil {
+			return fmt.Errorf("plaintext shares %v found; run once with -migrate-shares", plain)
+		}
+		log.Printf("WARNING: key shares %v are stored unencrypted; set -share-pass-file or -share-key-file and run -migrate-shares", plain)
+	}
+	log.Printf("unlocked %d key shares (encrypted=%v)", len(shares), sc != nil)
+	return nil
+}
+
+// migrateShares seals every plaintext share (keys/*.json and the legacy
+// keygen.json) in place. Each file is re-read and checked before the
+// plaintext is replaced.
+func migrateShares(dir string, sc *shareCipher) error {
+	if sc == nil {
+		return errors.New("-migrate-shares needs -share-pass-file or -share-key-file")
+	}
+	legacy := filepath.Join(dir, legacyShareFile)
+	_, errLegacy := os.Stat(legacy)
+	_, errDefault := os.Stat(sharePath(dir, tssnet.DefaultKeyID))
+	if errLegacy == nil && errDefault == nil {
+		return fmt.Errorf("both %s and keys/%s.json exist; remove the stale one first", legacyShareFile, tssnet.DefaultKeyID)
+	}
+
+	shares, err := listShares(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "keys"), 0o700); err != nil {
+		return err
+	}
+	n := 0
+	for _, f := range shares {
+		if f.Sealed != nil {
+			continue
+		}
+		b, err := encodeShare(f, sc)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", f.KeyID, err)
+		}
+		path := sharePath(dir, f.KeyID)
+		if err := writeFileAtomic(path, b); err != nil {
+			return fmt.Errorf("key %s: %w", f.KeyID, err)
+		}
+		back, err := os.ReadFile(path)
+		if err == nil {
+			_, err = decodeShare(back, sc)
+		}
+		if err != nil {
+			return fmt.Errorf("key %s: sealed share does not read back: %w", f.KeyID, err)
+		}
+		if f.legacy {
+			if err := os.Remove(legacy); err != nil {
+				return err
+			}
+		}
+		log.Printf("migrate: key %s sealed (%s)", f.KeyID, path)
+		n++
+	}
+	log.Printf("migrate: %d of %d shares sealed", n, len(shares))
+	return nil
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	stdecdsa "crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// shareFormatVersion is bumped whenever the on-disk share layout changes.
+// v1: plaintext "share". v2: "share" or, with a share key, "sealed".
+const shareFormatVersion = 2
+
+// shareKey seals shares written by this node; nil = plaintext (see sharecrypt.go).
+var shareKey *shareCipher
+
+// shareFile is one key share on disk (<data>/keys/<key_id>.json): metadata
+// plus the tss-lib save data, in clear or sealed.
+type shareFile struct {
+	Version   int       `json:"version"`
+	KeyID     string    `json:"key_id"`
+	Parties   []string  `json:"parties"`
+	Threshold int       `json:"threshold"`
+	PartyKeys []string  `json:"party_keys,omitempty"` // tss-lib key per party (decimal); empty = 1..n (keygen)
+	CreatedAt time.Time `json:"created_at"`
+	PubKeyHex string    `json:"pubkey_hex"`
+	Address   string    `json:"address"`
+
+	Plain  json.RawMessage `json:"share,omitempty"`
+	Sealed *sealedShare    `json:"sealed,omitempty"`
+
+	Share  keygen.LocalPartySaveData `json:"-"`
+	legacy bool                      // read from keygen.json
+}
+
+// partyKeys returns the tss-lib keys for a signing set. Keys created by keygen
+// use 1..n in party order; reshared keys record theirs in PartyKeys.
+func (f *shareFile) partyKeys(parties []string) ([]*big.Int, error) {
+	if len(f.PartyKeys) == 0 {
+		return nil, nil
+	}
+	byName := map[string]string{}
+	for i, p := range f.Parties {
+		if i < len(f.PartyKeys) {
+			byName[p] = f.PartyKeys[i]
+		}
+	}
+	out := make([]*big.Int, len(parties))
+	for i, p := range parties {
+		k, ok := new(big.Int).SetString(byName[p], 10)
+		if !ok {
+			return nil, fmt.Errorf("party %s does not hold key %s", p, f.KeyID)
+		}
+		out[i] = k
+	}
+	return out, nil
+}
+
+// aad binds the clear metadata to the sealed share: every field that decides
+// which key this is and how signers map to tss-lib keys. CreatedAt is
+// informational and left out.
+func (f *shareFile) aad() []byte {
+	meta, _ := json.Marshal(struct {
+		KeyID     string   `json:"key_id"`
+		Parties   []string `json:"parties"`
+		Threshold int      `json:"threshold"`
+		PartyKeys []string `json:"party_keys"`
+		PubKeyHex string   `json:"pubkey_hex"`
+		Address   string   `json:"address"`
+	}{f.KeyID, f.Parties, f.Threshold, f.PartyKeys, f.PubKeyHex, f.Address})
+	return append([]byte(fmt.Sprintf("tss-share|v%d|", f.Version)), meta...)
+}
+
+// encodeShare serializes f, sealing the share when sc is set.
+func encodeShare(f shareFile, sc *shareCipher) ([]byte, error) {
+	f.Version = shareFormatVersion
+	plain, err := json.Marshal(f.Share)
+	if err != nil {
+		return nil, err
+	}
+	f.Plain, f.Sealed = nil, nil
+	if sc == nil {
+		f.Plain = plain
+	} else if f.Sealed, err = sc.seal(plain, f.aad()); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// decodeShare parses a share file and opens it with sc if it is sealed.
+func decodeShare(b []byte, sc *shareCipher) (shareFile, error) {
+	var f shareFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return shareFile{}, err
+	}
+	if f.Version < 1 || f.Version > shareFormatVersion {
+		return shareFile{}, fmt.Errorf("unsupported share format version %d", f.Version)
+	}
+	plain := []byte(f.Plain)
+	if f.Sealed != nil {
+		var err error
+		if plain, err = sc.open(f.Sealed, f.aad()); err != nil {
+			return shareFile{}, err
+		}
+	}
+	if len(plain) == 0 {
+		return shareFile{}, errors.New("share file has no share")
+	}
+	if err := json.Unmarshal(plain, &f.Share); err != nil {
+		return shareFile{}, err
+	}
+	f.Plain = nil
+	if pub, _, err := pubAndAddr(f.Share); err != nil || !strings.EqualFold(pub, f.PubKeyHex) {
+		return shareFile{}, errors.New("share does not match its pubkey_hex")
+	}
+	return f, nil
+}
+
+// legacyShareFile is the single-key file written before named keys; it is
+// served as DefaultKeyID when keys/default.json does not exist.
+const legacyShareFile = "keygen.json"
+
+func sharePath(dir, keyID string) string {
+	return filepath.Join(dir, "keys", keyID+".json")
+}
+
+// pubAndAddr returns the uncompressed public key (0x04…) and address of a share.
+func pubAndAddr(save keygen.LocalPartySaveData) (string, string, error) {
+	if save.ECDSAPub == nil {
+		return "", "", errors.New("nil ECDSAPub in key share")
+	}
+	pk := &stdecdsa.PublicKey{Curve: save.ECDSAPub.Curve(), X: save.ECDSAPub.X(), Y: save.ECDSAPub.Y()}
+	return "0x" + hex.EncodeToString(crypto.FromECDSAPub(pk)), crypto.PubkeyToAddress(*pk).Hex(), nil
+}
+
+// saveShare writes a new share file; an existing key is never overwritten.
+func saveShare(dir string, f shareFile) error {
+	if err := tssnet.ValidKeyID(f.KeyID); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "keys"), 0o700); err != nil {
+		return err
+	}
+	b, err := encodeShare(f, shareKey)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(sharePath(dir, f.KeyID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("key %s already exists on this node", f.KeyID)
+		}
+		return err
+	}
+	if _, err := out.Write(b); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// shareExists reports whether keyID is already stored (including legacy).
+func shareExists(dir, keyID string) bool {
+	if _, err := os.Stat(sharePath(dir, keyID)); err == nil {
+		return true
+	}
+	if keyID == tssnet.DefaultKeyID {
+		if _, err := os.Stat(filepath.Join(dir, legacyShareFile)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func loadShare(dir, keyID string) (shareFile, error) {
+	if err := tssnet.ValidKeyID(keyID); err != nil {
+		return shareFile{}, err
+	}
+	b, err := os.ReadFile(sharePath(dir, keyID))
+	if errors.Is(err, os.ErrNotExist) && keyID == tssnet.DefaultKeyID {
+		return loadLegacyShare(dir)
+	}
+	if err != nil {
+		return shareFile{}, err
+	}
+	f, err := decodeShare(b, shareKey)
+	if err != nil {
+		return shareFile{}, err
+	}
+	if f.KeyID != keyID {
+		return shareFile{}, fmt.Errorf("file holds key %q", f.KeyID)
+	}
+	return f, nil
+}
+
+// loadLegacyShare wraps <data>/keygen.json; parties/threshold are unknown.
+func loadLegacyShare(dir string) (shareFile, error) {
+	path := filepath.Join(dir, legacyShareFile)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return shareFile{}, err
+	}
+	var save keygen.LocalPartySaveData
+	if err := json.Unmarshal(b, &save); err != nil {
+		return shareFile{}, err
+	}
+	pub, addr, err := pubAndAddr(save)
+	if err != nil {
+		return shareFile{}, err
+	}
+	f := shareFile{Version: 1, KeyID: tssnet.DefaultKeyID, PubKeyHex: pub, Address: addr, Share: save, legacy: true}
+	if st, err := os.Stat(path); err == nil {
+		f.CreatedAt = st.ModTime().UTC()
+	}
+	return f, nil
+}
+
+// listShares returns every stored key, sorted by key ID.
+func listShares(dir string) ([]shareFile, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "keys"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ids := []string{}
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), ".json"); ok && !e.IsDir() && tssnet.ValidKeyID(id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	if !slices.Contains(ids, tssnet.DefaultKeyID) && shareExists(dir, tssnet.DefaultKeyID) {
+		ids = append(ids, tssnet.DefaultKeyID)
+	}
+	sort.Strings(ids)
+	out := make([]shareFile, 0, len(ids))
+	for _, id := range ids {
+		f, err := loadShare(dir, id)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/ecdsa/keygen"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// testShare builds a synthetic share of P1 in a 3-party key: real curve
+// points for the public key, no Paillier or ring-Pedersen material. Enough
+// for the file format, not for signing.
+func testShare(t *testing.T) shareFile {
+	t.Helper()
+	save := keygen.NewLocalPartySaveData(3)
+	secret := big.NewInt(0)
+	for i := range save.Ks {
+		save.Ks[i] = big.NewInt(int64(i + 1))
+		xi := big.NewInt(int64(1000 + i))
+		save.BigXj[i] = crypto.ScalarBaseMult(tss.S256(), xi)
+		secret.Add(secret, xi)
+	}
+	save.ShareID, save.Xi = save.Ks[0], big.NewInt(1000)
+	save.ECDSAPub = crypto.ScalarBaseMult(tss.S256(), secret)
+	pub, addr, err := pubAndAddr(save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return shareFile{
+		KeyID: "exp", Parties: []string{"P1", "P2", "P3"}, Threshold: 1, PartyKeys: []string{"4", "5", "6"},
+		CreatedAt: time.Date(2026, 1, 12, 0, 0, 0, 0, time.UTC), PubKeyHex: pub, Address: addr, Share: save,
+	}
+}
+
+func testCipher(t *testing.T, fill byte) *shareCipher {
+	t.Helper()
+	sc, err := newShareCipher(writeTemp(t, "key", bytes.Repeat([]byte{fill}, 32)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
+
+func writeTemp(t *testing.T, name string, b []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// tamper rewrites one clear field of an encoded share file.
+func tamper(t *testing.T, b []byte, field string, v any) []byte {
+	t.Helper()
+	var m map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber() // keep the plaintext share's big integers intact
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	m[field] = v
+	out, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestShareSealOpen(t *testing.T) {
+	f := testShare(t)
+	sc := testCipher(t, 1)
+	sealed, err := encodeShare(f, sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("PaillierSK")) {
+		t.Fatal("sealed file contains the plaintext share")
+	}
+	plain, err := encodeShare(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		b       []byte
+		sc      *shareCipher
+		wantErr string
+	}{
+		{"sealed", sealed, sc, ""},
+		{"plaintext", plain, nil, ""},
+		{"plaintext with cipher", plain, sc, ""},
+		{"created_at is not bound", tamper(t, sealed, "created_at", "2030-01-01T00:00:00Z"), sc, ""},
+		{"no cipher", sealed, nil, "share is encrypted"},
+		{"wrong key", sealed, testCipher(t, 2), "cannot decrypt"},
+		{"tampered key_id", tamper(t, sealed, "key_id", "other"), sc, "cannot decrypt"},
+		{"tampered parties", tamper(t, sealed, "parties", []string{"P1", "P3", "P2"}), sc, "cannot decrypt"},
+		{"tampered threshold", tamper(t, sealed, "threshold", 2), sc, "cannot decrypt"},
+		{"tampered party_keys", tamper(t, sealed, "party_keys", []string{"5", "4", "6"}), sc, "cannot decrypt"},
+		{"dropped party_keys", tamper(t, sealed, "party_keys", nil), sc, "cannot decrypt"},
+		{"tampered address", tamper(t, sealed, "address", "0x0000000000000000000000000000000000000001"), sc, "cannot decrypt"},
+		{"tampered pubkey", tamper(t, sealed, "pubkey_hex", "0x04"), sc, "cannot decrypt"},
+		{"plaintext pubkey mismatch", tamper(t, plain, "pubkey_hex", "0x04"), nil, "does not match"},
+		{"future version", tamper(t, sealed, "version", shareFormatVersion+1), sc, "unsupported share format"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeShare(tc.b, tc.sc)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got.KeyID != f.KeyID || got.PubKeyHex != f.PubKeyHex || got.Share.Xi.Cmp(f.Share.Xi) != 0 {
+					t.Fatalf("decoded share differs from the original")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// Plaintext shares, including the legacy keygen.json, stop a node that has
+// a share key until -migrate-shares seals them.
+func TestMigrateShares(t *testing.T) {
+	dir := t.TempDir()
+	sc := testCipher(t, 1)
+	prev := shareKey
+	shareKey = sc
+	t.Cleanup(func() { shareKey = prev })
+
+	f := testShare(t)
+	b, err := encodeShare(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "keys"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sharePath(dir, f.KeyID), b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := json.Marshal(f.Share)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, legacyShareFile), legacy, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkShares(dir, sc); err == nil || !strings.Contains(err.Error(), "-migrate-shares") {
+		t.Fatalf("checkShares on plaintext = %v, want migrate hint", err)
+	}
+	if err := migrateShares(dir, sc); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkShares(dir, sc); err != nil {
+		t.Fatalf("checkShares after migrate: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyShareFile)); !os.IsNotExist(err) {
+		t.Fatalf("%s left after migrate", legacyShareFile)
+	}
+	for _, id := range []string{f.KeyID, tssnet.DefaultKeyID} {
+		got, err := loadShare(dir, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Version != shareFormatVersion || got.Sealed == nil || got.Share.Xi.Cmp(f.Share.Xi) != 0 {
+			t.Fatalf("key %s after migrate: version=%d sealed=%v", id, got.Version, got.Sealed != nil)
+		}
+	}
+}
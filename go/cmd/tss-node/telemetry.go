@@ -0,0 +1,137 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"mp-htlc-lgp/experiment/internal/tssnet"
+)
+
+// roundRe finds the protocol round in a tss-lib message type, e.g.
+// "binance.tsslib.ecdsa.signing.SignRound3Message", "...KGRound2Message1",
+// "...resharing.DGRound4Message2".
+var roundRe = regexp.MustCompile(`Round(\d+)Message`)
+
+func msgRound(typ string) int {
+	m := roundRe.FindStringSubmatch(typ)
+	if m == nil {
+		return 0
+	}
+	r, _ := strconv.Atoi(m[1])
+	return r
+}
+
+// sessionTrace records the per-round timeline of the running session: a
+// round starts locally with its first outgoing message (round 1 with the
+// session) and ends when the next one starts.
+type sessionTrace struct {
+	mu     sync.Mutex
+	start  time.Time
+	cpu0   time.Duration
+	rounds map[int]*tssnet.RoundStats
+	cur    int           // latest round started locally
+	curCPU time.Duration // process CPU when cur started
+	msgs   int
+}
+
+func newTrace() *sessionTrace {
+	now, cpu := time.Now(), processCPU()
+	return &sessionTrace{
+		start:  now,
+		cpu0:   cpu,
+		rounds: map[int]*tssnet.RoundStats{1: {Round: 1, StartMs: now.UnixMilli()}},
+		cur:    1,
+		curCPU: cpu,
+	}
+}
+
+// roundLocked returns the stats of round r; t.mu must be held.
+func (t *sessionTrace) roundLocked(r int) *tssnet.RoundStats {
+	rs := t.rounds[r]
+	if rs == nil {
+		rs = &tssnet.RoundStats{Round: r}
+		t.rounds[r] = rs
+	}
+	return rs
+}
+
+// closeLocked ends the current round; t.mu must be held.
+func (t *sessionTrace) closeLocked(now time.Time, cpu time.Duration) {
+	rs := t.roundLocked(t.cur)
+	rs.EndMs = now.UnixMilli()
+	rs.CPUMs = (cpu - t.curCPU).Milliseconds()
+}
+
+// skipTo restarts the trace at round r: a presigned sign begins there.
+func (t *sessionTrace) skipTo(r int) {
+	if t == nil {
+		return
+	}
+	now, cpu := time.Now(), processCPU()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rounds = map[int]*tssnet.RoundStats{r: {Round: r, StartMs: now.UnixMilli()}}
+	t.cur, t.curCPU = r, cpu
+}
+
+// sent records a message of type typ (wire size n bytes) sent to to parties.
+func (t *sessionTrace) sent(typ string, n, to int) {
+	r := msgRound(typ)
+	if t == nil || r == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if r > t.cur {
+		now, cpu := time.Now(), processCPU()
+		t.closeLocked(now, cpu)
+		t.cur, t.curCPU = r, cpu
+		t.roundLocked(r).StartMs = now.UnixMilli()
+	}
+	rs := t.roundLocked(r)
+	rs.MsgsSent += to
+	rs.BytesSent += n * to
+	t.msgs++
+}
+
+// received records an incoming message of type typ (wire size n bytes).
+func (t *sessionTrace) received(typ string, n int) {
+	r := msgRound(typ)
+	if t == nil || r == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rs := t.roundLocked(r)
+	rs.MsgsRecv++
+	rs.BytesRecv += n
+	rs.LastRecvMs = time.Now().UnixMilli()
+	t.msgs++
+}
+
+// telemetry closes the trace for the session's *_result; nil when the
+// session exchanged no messages (e.g. reshare_commit).
+func (t *sessionTrace) telemetry(c *tssnet.Conn) *tssnet.Telemetry {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.msgs == 0 {
+		return nil
+	}
+	now, cpu := time.Now(), processCPU()
+	t.closeLocked(now, cpu)
+	out := &tssnet.Telemetry{StartMs: t.start.UnixMilli(), EndMs: now.UnixMilli(), CPUMs: (cpu - t.cpu0).Milliseconds()}
+	if off, rtt, ok := c.ClockOffset(); ok {
+		out.ClockOffsetMs, out.ClockRTTMs, out.ClockSynced = off.Milliseconds(), rtt.Milliseconds(), true
+	}
+	for _, rs := range t.rounds {
+		out.Rounds = append(out.Rounds, *rs)
+	}
+	sort.Slice(out.Rounds, func(a, b int) bool { return out.Rounds[a].Round < out.Rounds[b].Round })
+	return out
+}
@@ -6,6 +6,7 @@ require (
 	github.com/bnb-chain/tss-lib/v2 v2.0.2
 	github.com/ethereum/go-ethereum v1.14.13
 	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.22.0
 )
 
 require (
@@ -44,7 +45,6 @@ require (
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/zap v1.16.0 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
 	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
@@ -0,0 +1,249 @@
+// Package apiauth authenticates calls to the signer HTTP APIs (tss-gateway,
+// the mock cmd/signer and the tss-node admin listener) with API keys, HMAC
+// request signatures or mutual TLS, and enforces per-key scopes.
+package apiauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeKeygen = "keygen"
+	ScopeSign   = "sign"
+	ScopeAdmin  = "admin" // implies every other scope
+	ScopeAny    = ""      // any authenticated caller
+
+	HeaderAPIKey    = "X-API-Key"
+	HeaderKeyID     = "X-Key-Id"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+
+	// MaxSkew bounds |now - X-Timestamp| for HMAC requests.
+	MaxSkew = 5 * time.Minute
+	// MaxHMACBody bounds the body read to check an HMAC signature, before the
+	// caller is known.
+	MaxHMACBody = 1 << 20
+)
+
+// Key is one credential from the keys file:
+//
+//	{"keys": [
+//	  {"id": "runner", "secret": "…", "scopes": ["sign"]},
+//	  {"id": "ops", "secret": "…", "cert_cn": "ops", "scopes": ["admin"]}
+//	]}
+//
+// secret is used both as API key and as HMAC secret. cert_cn maps a verified
+// client certificate (mutual TLS) to this key.
+type Key struct {
+	ID     string   `json:"id"`
+	Secret string   `json:"secret,omitempty"`
+	CertCN string   `json:"cert_cn,omitempty"`
+	Scopes []string `json:"scopes"`
+}
+
+func (k *Key) allows(scope string) bool {
+	if scope == ScopeAny {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies requests against a set of keys. A nil *Authenticator
+// lets every request through.
+type Authenticator struct {
+	keys   map[string]*Key // id -> key
+	byCN   map[string]*Key
+	mu     sync.Mutex
+	nonces map[string]time.Time // seen HMAC nonces -> expiry
+}
+
+type keysFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// Load reads a keys file.
+func Load(path string) (*Authenticator, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keysFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	a := &Authenticator{keys: map[string]*Key{}, byCN: map[string]*Key{}, nonces: map[string]time.Time{}}
+	for i := range f.Keys {
+		k := &f.Keys[i]
+		if k.ID == "" {
+			return nil, fmt.Errorf("%s: key #%d has no id", path, i)
+		}
+		if k.Secret == "" && k.CertCN == "" {
+			return nil, fmt.Errorf("%s: key %s needs secret or cert_cn", path, k.ID)
+		}
+		if _, dup := a.keys[k.ID]; dup {
+			return nil, fmt.Errorf("%s: duplicate key id %s", path, k.ID)
+		}
+		a.keys[k.ID] = k
+		if k.CertCN != "" {
+			a.byCN[k.CertCN] = k
+		}
+	}
+	if len(a.keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return a, nil
+}
+
+type ctxKey struct{}
+
+// Caller returns the key ID that authenticated r, or "" if auth is disabled.
+func Caller(r *http.Request) string {
+	if k, ok := r.Context().Value(ctxKey{}).(*Key); ok {
+		return k.ID
+	}
+	return ""
+}
+
+// Allowed reports whether the caller of r holds scope; always true when auth
+// is disabled.
+func Allowed(r *http.Request, scope string) bool {
+	k, ok := r.Context().Value(ctxKey{}).(*Key)
+	return !ok || k.allows(scope)
+}
+
+// Require wraps h so that only callers holding scope reach it.
+func (a *Authenticator) Require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		k, err := a.authenticate(w, r)
+		if err != nil {
+			log.Printf("auth: %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			status := http.StatusUnauthorized
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeDenied(w, status, err.Error())
+			return
+		}
+		if !k.allows(scope) {
+			log.Printf("auth: key %s lacks scope %q for %s", k.ID, scope, r.URL.Path)
+			writeDenied(w, http.StatusForbidden, fmt.Sprintf("key %s lacks scope %q", k.ID, scope))
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, k)))
+	}
+}
+
+func writeDenied(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "err": msg})
+}
+
+// authenticate tries, in order: HMAC signature, API key, client certificate.
+func (a *Authenticator) authenticate(w http.ResponseWriter, r *http.Request) (*Key, error) {
+	if sig := r.Header.Get(HeaderSignature); sig != "" {
+		return a.verifyHMAC(w, r, sig)
+	}
+	if apiKey := r.Header.Get(HeaderAPIKey); apiKey != "" {
+		for _, k := range a.keys {
+			if k.Secret != "" && subtle.ConstantTimeCompare([]byte(k.Secret), []byte(apiKey)) == 1 {
+				return k, nil
+			}
+		}
+		return nil, errors.New("unknown api key")
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.PeerCertificates[0].Subject.CommonName
+		if k := a.byCN[cn]; k != nil {
+			return k, nil
+		}
+		return nil, fmt.Errorf("client certificate %q not registered", cn)
+	}
+	return nil, errors.New("missing credentials")
+}
+
+func (a *Authenticator) verifyHMAC(w http.ResponseWriter, r *http.Request, sigHex string) (*Key, error) {
+	k := a.keys[r.Header.Get(HeaderKeyID)]
+	if k == nil || k.Secret == "" {
+		return nil, errors.New("unknown key id")
+	}
+	tsStr := r.Header.Get(HeaderTimestamp)
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return nil, errors.New("bad timestamp")
+	}
+	now := time.Now()
+	if d := now.Sub(time.Unix(ts, 0)); d > MaxSkew || d < -MaxSkew {
+		return nil, errors.New("timestamp outside allowed skew")
+	}
+	nonce := r.Header.Get(HeaderNonce)
+	if nonce == "" {
+		return nil, errors.New("missing nonce")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, MaxHMACBody)); err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	want := Signature(k.Secret, r.Method, r.URL.RequestURI(), tsStr, nonce, body)
+	got, err := hex.DecodeString(sigHex)
+	if err != nil || !hmac.Equal(got, want) {
+		return nil, errors.New("bad signature")
+	}
+	if !a.useNonce(k.ID+"/"+nonce, now) {
+		return nil, errors.New("replayed nonce")
+	}
+	return k, nil
+}
+
+// useNonce records a nonce for the skew window; false if already seen.
+func (a *Authenticator) useNonce(n string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, exp := range a.nonces {
+		if now.After(exp) {
+			delete(a.nonces, k)
+		}
+	}
+	if _, seen := a.nonces[n]; seen {
+		return false
+	}
+	a.nonces[n] = now.Add(2 * MaxSkew)
+	return true
+}
+
+// Signature is HMAC-SHA256(secret, method \n requestURI \n timestamp \n nonce \n hex(sha256(body))).
+func Signature(secret, method, requestURI, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, requestURI, timestamp, nonce, hex.EncodeToString(bodyHash[:]))
+	return mac.Sum(nil)
+}
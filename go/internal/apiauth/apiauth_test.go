@@ -0,0 +1,170 @@
+package apiauth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAuth() *Authenticator {
+	a := &Authenticator{keys: map[string]*Key{}, byCN: map[string]*Key{}, nonces: map[string]time.Time{}}
+	for _, k := range []*Key{
+		{ID: "runner", Secret: "s3cret", Scopes: []string{ScopeSign}},
+		{ID: "ops", Secret: "0psk3y", Scopes: []string{ScopeAdmin}},
+		{ID: "tls-only", CertCN: "ops", Scopes: []string{ScopeAdmin}},
+	} {
+		a.keys[k.ID] = k
+	}
+	return a
+}
+
+type hmacReq struct {
+	keyID, secret string
+	ts            time.Time
+	nonce         string
+	signedBody    string // body the signature covers
+	sentBody      string // body actually sent
+	sig           string // overrides the computed signature if set
+}
+
+func (h hmacReq) build() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/signHash?key_id=default", strings.NewReader(h.sentBody))
+	ts := strconv.FormatInt(h.ts.Unix(), 10)
+	sig := h.sig
+	if sig == "" {
+		sig = hex.EncodeToString(Signature(h.secret, http.MethodPost, "/signHash?key_id=default", ts, h.nonce, []byte(h.signedBody)))
+	}
+	r.Header.Set(HeaderKeyID, h.keyID)
+	r.Header.Set(HeaderTimestamp, ts)
+	r.Header.Set(HeaderNonce, h.nonce)
+	r.Header.Set(HeaderSignature, sig)
+	return r
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	now := time.Now()
+	body := `{"hash":"0x01"}`
+	ok := hmacReq{keyID: "runner", secret: "s3cret", ts: now, nonce: "n1", signedBody: body, sentBody: body}
+	with := func(f func(*hmacReq)) hmacReq { h := ok; f(&h); return h }
+
+	cases := []struct {
+		name    string
+		req     hmacReq
+		wantErr string
+	}{
+		{"valid", ok, ""},
+		{"unknown key id", with(func(h *hmacReq) { h.keyID = "nobody" }), "unknown key id"},
+		{"cert-only key", with(func(h *hmacReq) { h.keyID = "tls-only" }), "unknown key id"},
+		{"wrong secret", with(func(h *hmacReq) { h.secret = "guess" }), "bad signature"},
+		{"tampered body", with(func(h *hmacReq) { h.sentBody = `{"hash":"0x02"}` }), "bad signature"},
+		{"non-hex signature", with(func(h *hmacReq) { h.sig = "zz" }), "bad signature"},
+		{"old timestamp", with(func(h *hmacReq) { h.ts = now.Add(-MaxSkew - time.Minute) }), "skew"},
+		{"future timestamp", with(func(h *hmacReq) { h.ts = now.Add(MaxSkew + time.Minute) }), "skew"},
+		{"missing nonce", with(func(h *hmacReq) { h.nonce = "" }), "missing nonce"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := testAuth()
+			r := tc.req.build()
+			k, err := a.authenticate(httptest.NewRecorder(), r)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if k.ID != tc.req.keyID {
+					t.Fatalf("key = %s, want %s", k.ID, tc.req.keyID)
+				}
+				// the handler must still see the full body
+				if b, _ := io.ReadAll(r.Body); string(b) != tc.req.sentBody {
+					t.Fatalf("body after auth = %q, want %q", b, tc.req.sentBody)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyHMACReplay(t *testing.T) {
+	a := testAuth()
+	req := hmacReq{keyID: "runner", secret: "s3cret", ts: time.Now(), nonce: "once", signedBody: "{}", sentBody: "{}"}
+	if _, err := a.authenticate(httptest.NewRecorder(), req.build()); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := a.authenticate(httptest.NewRecorder(), req.build()); err == nil || !strings.Contains(err.Error(), "replayed nonce") {
+		t.Fatalf("second use: err = %v, want replayed nonce", err)
+	}
+	// the same nonce under another key is a different credential
+	req.keyID, req.secret = "ops", "0psk3y"
+	if _, err := a.authenticate(httptest.NewRecorder(), req.build()); err != nil {
+		t.Fatalf("other key: %v", err)
+	}
+}
+
+func TestRequireHMACBodyLimit(t *testing.T) {
+	a := testAuth()
+	big := string(bytes.Repeat([]byte("a"), MaxHMACBody+1))
+	req := hmacReq{keyID: "runner", secret: "s3cret", ts: time.Now(), nonce: "n", signedBody: big, sentBody: big}
+	called := false
+	h := a.Require(ScopeSign, func(http.ResponseWriter, *http.Request) { called = true })
+	w := httptest.NewRecorder()
+	h(w, req.build())
+	if called || w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d called = %v, want 413 and not called", w.Code, called)
+	}
+}
+
+func TestRequireScopes(t *testing.T) {
+	a := testAuth()
+	cases := []struct {
+		name   string
+		apiKey string
+		scope  string
+		want   int
+	}{
+		{"sign key for sign", "s3cret", ScopeSign, http.StatusOK},
+		{"sign key for keygen", "s3cret", ScopeKeygen, http.StatusForbidden},
+		{"admin implies keygen", "0psk3y", ScopeKeygen, http.StatusOK},
+		{"unknown key", "nope", ScopeAny, http.StatusUnauthorized},
+		{"no credentials", "", ScopeAny, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/keys", nil)
+			if tc.apiKey != "" {
+				r.Header.Set(HeaderAPIKey, tc.apiKey)
+			}
+			w := httptest.NewRecorder()
+			a.Require(tc.scope, func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })(w, r)
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckTLSFiles(t *testing.T) {
+	cases := []struct {
+		cert, key, ca string
+		ok            bool
+	}{
+		{"", "", "", true},
+		{"c.pem", "k.pem", "", true},
+		{"c.pem", "k.pem", "ca.pem", true},
+		{"", "", "ca.pem", false},
+		{"", "k.pem", "", false},
+	}
+	for _, tc := range cases {
+		if err := CheckTLSFiles(tc.cert, tc.key, tc.ca); (err == nil) != tc.ok {
+			t.Errorf("CheckTLSFiles(%q, %q, %q) = %v, want ok=%v", tc.cert, tc.key, tc.ca, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package apiauth
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Credentials are what a client presents. With KeyID set requests are HMAC
+// signed with Secret; otherwise a non-empty Secret is sent as X-API-Key.
+type Credentials struct {
+	KeyID  string
+	Secret string
+}
+
+// Apply adds auth headers to req; body must be the exact request body.
+func (c Credentials) Apply(req *http.Request, body []byte) {
+	switch {
+	case c.KeyID != "" && c.Secret != "":
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		var nb [16]byte
+		_, _ = rand.Read(nb[:])
+		nonce := hex.EncodeToString(nb[:])
+		req.Header.Set(HeaderKeyID, c.KeyID)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderNonce, nonce)
+		req.Header.Set(HeaderSignature, hex.EncodeToString(Signature(c.Secret, req.Method, req.URL.RequestURI(), ts, nonce, body)))
+	case c.Secret != "":
+		req.Header.Set(HeaderAPIKey, c.Secret)
+	}
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig requires and verifies client certificates signed by
+// clientCAFile when it is set (mutual TLS).
+func ServerTLSConfig(clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+	pool, err := loadPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+// ClientTLSConfig trusts caFile (if set) and presents certFile/keyFile (if set).
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// CheckTLSFiles rejects TLS settings that ListenAndServe would not honor.
+func CheckTLSFiles(certFile, keyFile, clientCAFile string) error {
+	if certFile == "" && keyFile != "" {
+		return errors.New("TLS key set without a certificate")
+	}
+	if certFile == "" && clientCAFile != "" {
+		return errors.New("client CA set without a TLS certificate: mutual TLS needs HTTPS")
+	}
+	return nil
+}
+
+// ListenAndServe serves h over HTTPS when certFile is set, plain HTTP otherwise.
+func ListenAndServe(addr string, h http.Handler, certFile, keyFile, clientCAFile string) error {
+	if err := CheckTLSFiles(certFile, keyFile, clientCAFile); err != nil {
+		return err
+	}
+	if certFile == "" {
+		return http.ListenAndServe(addr, h)
+	}
+	cfg, err := ServerTLSConfig(clientCAFile)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: addr, Handler: h, TLSConfig: cfg}
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}
@@ -21,6 +21,13 @@ type Env struct {
 	DeployerPK          string
 	ReceiverPK          string
 	SignerURL           string
+	SigningKeyID        string // cluster key on the gateway (empty = default)
+	SigningPath         string // child key of SigningKeyID to sign with, e.g. "m/0/7" (empty = the key itself)
+	SignerKeyID         string // HMAC key id (with SignerSecret)
+	SignerSecret        string // API key, or HMAC secret if SignerKeyID is set
+	SignerTLSCert       string // client certificate for mutual TLS
+	SignerTLSKey        string
+	SignerTLSCA         string // CA that signed the signer's server certificate
 	AmountToken         string // uint256 as decimal
 	TimelockSec         int64
 	PenaltyWindowSec    int64
@@ -70,6 +77,13 @@ func Load() Env {
 		DeployerPK:         mustGet("DEPLOYER_PK"),
 		ReceiverPK:         mustGet("RECEIVER_PK"),
 		SignerURL:          getDefault("TSS_SIGNER_URL", "http://127.0.0.1:8080"),
+		SigningKeyID:       getDefault("TSS_SIGNING_KEY_ID", ""),
+		SigningPath:        getDefault("TSS_SIGNING_PATH", ""),
+		SignerKeyID:        getDefault("TSS_KEY_ID", ""),
+		SignerSecret:       getDefault("TSS_API_KEY", ""),
+		SignerTLSCert:      getDefault("TSS_TLS_CERT", ""),
+		SignerTLSKey:       getDefault("TSS_TLS_KEY", ""),
+		SignerTLSCA:        getDefault("TSS_TLS_CA", ""),
 		AmountToken:        getDefault("AMOUNT_TOKEN", "100000000000000000000"),
 		TimelockSec:        parseI64("TIMELOCK_SEC", 600),
 		PenaltyWindowSec:   parseI64("PENALTY_WINDOW_SEC", 180),
@@ -2,6 +2,7 @@ package eth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
 
@@ -46,3 +47,56 @@ func CallBalanceOf(ctx context.Context, rpc *ethclient.Client, token common.Addr
 	}
 	return out[0].(*big.Int), nil
 }
+
+// Lock mirrors MPHTLC_LGP.Lock as returned by the public locks(bytes32) getter.
+type Lock struct {
+	Token            common.Address
+	Sender           common.Address
+	Receiver         common.Address
+	Signer           common.Address
+	Amount           *big.Int
+	Hashlock         [32]byte
+	Timelock         *big.Int
+	PenaltyWindow    *big.Int
+	DepositRequired  *big.Int
+	DepositWindow    *big.Int
+	CreatedAt        *big.Int
+	DepositConfirmed bool
+	Claimed          bool
+	Refunded         bool
+}
+
+func CallLock(ctx context.Context, rpc *ethclient.Client, htlc common.Address, lockId [32]byte) (Lock, error) {
+	data, err := PackMPHTLC("locks", lockId)
+	if err != nil {
+		return Lock{}, err
+	}
+	res, err := rpc.CallContract(ctx, ethereum.CallMsg{To: &htlc, Data: data}, nil)
+	if err != nil {
+		return Lock{}, err
+	}
+	var l Lock
+	if err := MPHTLCABI().UnpackIntoInterface(&l, "locks", res); err != nil {
+		return Lock{}, err
+	}
+	return l, nil
+}
+
+// CheckClaimable returns why the TSS key must not authorize a claim for l at
+// block time now, or nil if the lock exists, is unfinalized, has its deposit
+// confirmed, is before the timelock and names signer.
+func CheckClaimable(l Lock, now uint64, signer common.Address) error {
+	switch {
+	case l.CreatedAt == nil || l.CreatedAt.Sign() == 0:
+		return errors.New("lock not found")
+	case l.Claimed || l.Refunded:
+		return errors.New("lock already finalized")
+	case !l.DepositConfirmed:
+		return errors.New("deposit not confirmed")
+	case new(big.Int).SetUint64(now).Cmp(l.Timelock) >= 0:
+		return fmt.Errorf("timelock passed (now=%d timelock=%s)", now, l.Timelock)
+	case l.Signer != signer:
+		return fmt.Errorf("lock signer %s is not this key %s", l.Signer.Hex(), signer.Hex())
+	}
+	return nil
+}
@@ -1,16 +1,24 @@
 package eth
 
 import (
+	"errors"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
+// ClaimDomainName is the EIP-712 domain name of the MPHTLC_LGP contract.
+const ClaimDomainName = "MPHTLC_LGP"
+
 var (
 	domainTypeHash = crypto.Keccak256Hash([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
 	claimTypeHash  = crypto.Keccak256Hash([]byte("Claim(bytes32 lockId,address receiver)"))
-	nameHash       = crypto.Keccak256Hash([]byte("MPHTLC_LGP"))
+	nameHash       = crypto.Keccak256Hash([]byte(ClaimDomainName))
 	versionHash    = crypto.Keccak256Hash([]byte("1"))
 )
 
@@ -57,3 +65,59 @@ func ClaimDigest(chainID *big.Int, verifyingContract common.Address, lockId [32]
 	buf = append(buf, structHash.Bytes()...)
 	return crypto.Keccak256Hash(buf)
 }
+
+// ClaimTypedData is the EIP-712 JSON form of Claim(lockId, receiver) for the
+// MPHTLC_LGP domain; its hash equals ClaimDigest.
+func ClaimTypedData(chainID *big.Int, verifyingContract common.Address, lockId [32]byte, receiver common.Address) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"Claim": {
+				{Name: "lockId", Type: "bytes32"},
+				{Name: "receiver", Type: "address"},
+			},
+		},
+		PrimaryType: "Claim",
+		Domain: apitypes.TypedDataDomain{
+			Name:              ClaimDomainName,
+			Version:           "1",
+			ChainId:           (*math.HexOrDecimal256)(chainID),
+			VerifyingContract: verifyingContract.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"lockId":   hexutil.Encode(lockId[:]),
+			"receiver": receiver.Hex(),
+		},
+	}
+}
+
+// TypedDataHash computes the EIP-712 digest. If primaryType is empty it is
+// inferred when types declares exactly one struct besides EIP712Domain.
+func TypedDataHash(td apitypes.TypedData) (common.Hash, error) {
+	if td.PrimaryType == "" {
+		for name := range td.Types {
+			if name == "EIP712Domain" {
+				continue
+			}
+			if td.PrimaryType != "" {
+				return common.Hash{}, errors.New("primaryType is required when types has several structs")
+			}
+			td.PrimaryType = name
+		}
+	}
+	h, _, err := apitypes.TypedDataAndHash(td)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(h), nil
+}
+
+// PersonalHash is the EIP-191 (version 0x45) digest used by personal_sign.
+func PersonalHash(msg []byte) common.Hash {
+	return common.BytesToHash(accounts.TextHash(msg))
+}
@@ -0,0 +1,48 @@
+package eth
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	secp256k1N     = crypto.S256().Params().N
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
+// NormalizeSignature checks (r,s) over hash32 against the uncompressed public
+// key pub (0x04…), flips s to the lower half of the curve order if needed and
+// returns r||s||v with v = 0/1. highS reports whether s had to be flipped.
+func NormalizeSignature(hash32, r32, s32, pub []byte) (sig []byte, highS bool, err error) {
+	if len(hash32) != 32 {
+		return nil, false, errors.New("hash must be 32 bytes")
+	}
+	if _, err := crypto.UnmarshalPubkey(pub); err != nil {
+		return nil, false, err
+	}
+	r := new(big.Int).SetBytes(r32)
+	s := new(big.Int).SetBytes(s32)
+	if r.Sign() <= 0 || r.Cmp(secp256k1N) >= 0 || s.Sign() <= 0 || s.Cmp(secp256k1N) >= 0 {
+		return nil, false, errors.New("r or s out of range")
+	}
+	if s.Cmp(secp256k1HalfN) > 0 {
+		s.Sub(secp256k1N, s)
+		highS = true
+	}
+	sig = make([]byte, 65)
+	r.FillBytes(sig[0:32])
+	s.FillBytes(sig[32:64])
+	if !crypto.VerifySignature(pub, hash32, sig[:64]) {
+		return nil, highS, errors.New("signature does not verify against the public key")
+	}
+	for v := byte(0); v <= 1; v++ {
+		sig[64] = v
+		if got, err := crypto.Ecrecover(hash32, sig); err == nil && bytes.Equal(got, pub) {
+			return sig, highS, nil
+		}
+	}
+	return nil, highS, errors.New("cannot determine recovery id")
+}
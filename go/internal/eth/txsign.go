@@ -2,7 +2,6 @@ package eth
 
 import (
   "context"
-  "crypto/ecdsa"
   "encoding/hex"
   "errors"
   "fmt"
@@ -88,7 +87,7 @@ func SendTxWithExternalSig(
   maxFee := new(big.Int).Mul(fee, big.NewInt(2))
 
   // Estimate gas
-  msg := ethereumCallMsg(from, to, value, data, tip, maxFee)
+  msg := newCallMsg(from, to, value, data, tip, maxFee)
   gasLimit, err := ec.EstimateGas(ctx, msg)
   if err != nil { return common.Hash{}, 0, nil, fmt.Errorf("estimate gas: %w", err) }
 
@@ -132,23 +131,3 @@ func SendTxWithExternalSig(
 
   return signedTx.Hash(), receipt.GasUsed, eff, nil
 }
-
-// --- helpers (avoid importing bind) ---
-
-type callMsg struct {
-  From common.Address
-  To *common.Address
-  Gas uint64
-  GasPrice *big.Int
-  GasFeeCap *big.Int
-  GasTipCap *big.Int
-  Value *big.Int
-  Data []byte
-}
-
-// We re-declare a struct compatible with ethclient.EstimateGas signature.
-func ethereumCallMsg(from common.Address, to *common.Address, value *big.Int, data []byte, tip, fee *big.Int) interface{} {
-  // ethclient expects ethereum.CallMsg, but importing "github.com/ethereum/go-ethereum" root is awkward.
-  // We'll create it via reflection by importing the package in a small wrapper file.
-  return newCallMsg(from, to, value, data, tip, fee)
-}
@@ -0,0 +1,129 @@
+package eth
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// UnsignedTx is the JSON form of an unsigned transaction, using the same field
+// names as eth_signTransaction. Type 0 = legacy, 1 = EIP-2930, 2 = EIP-1559.
+type UnsignedTx struct {
+	Type                 hexutil.Uint64   `json:"type"`
+	ChainID              *hexutil.Big     `json:"chainId,omitempty"`
+	Nonce                hexutil.Uint64   `json:"nonce"`
+	To                   *common.Address  `json:"to"`
+	Value                *hexutil.Big     `json:"value"`
+	Gas                  hexutil.Uint64   `json:"gas"`
+	GasPrice             *hexutil.Big     `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big     `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big     `json:"maxPriorityFeePerGas,omitempty"`
+	Input                hexutil.Bytes    `json:"input,omitempty"`
+	Data                 hexutil.Bytes    `json:"data,omitempty"` // alias of input
+	AccessList           types.AccessList `json:"accessList,omitempty"`
+}
+
+func hexBig(b *hexutil.Big) *big.Int {
+	if b == nil {
+		return new(big.Int)
+	}
+	return (*big.Int)(b)
+}
+
+// Transaction builds the typed transaction. chainID is used when the JSON
+// does not carry one; if both are set they must agree.
+func (u UnsignedTx) Transaction(chainID *big.Int) (*types.Transaction, error) {
+	if u.ChainID != nil {
+		if chainID != nil && chainID.Cmp(u.ChainID.ToInt()) != 0 {
+			return nil, fmt.Errorf("chainId mismatch: tx=%s request=%s", u.ChainID.ToInt(), chainID)
+		}
+		chainID = u.ChainID.ToInt()
+	}
+	if chainID == nil || chainID.Sign() <= 0 {
+		return nil, errors.New("missing chain id")
+	}
+	data := []byte(u.Input)
+	if len(data) == 0 {
+		data = u.Data
+	}
+	switch u.Type {
+	case types.LegacyTxType:
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    uint64(u.Nonce),
+			GasPrice: hexBig(u.GasPrice),
+			Gas:      uint64(u.Gas),
+			To:       u.To,
+			Value:    hexBig(u.Value),
+			Data:     data,
+		}), nil
+	case types.AccessListTxType:
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    chainID,
+			Nonce:      uint64(u.Nonce),
+			GasPrice:   hexBig(u.GasPrice),
+			Gas:        uint64(u.Gas),
+			To:         u.To,
+			Value:      hexBig(u.Value),
+			Data:       data,
+			AccessList: u.AccessList,
+		}), nil
+	case types.DynamicFeeTxType:
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    chainID,
+			Nonce:      uint64(u.Nonce),
+			GasTipCap:  hexBig(u.MaxPriorityFeePerGas),
+			GasFeeCap:  hexBig(u.MaxFeePerGas),
+			Gas:        uint64(u.Gas),
+			To:         u.To,
+			Value:      hexBig(u.Value),
+			Data:       data,
+			AccessList: u.AccessList,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported tx type %d", u.Type)
+	}
+}
+
+// DecodeUnsignedTx decodes the binary (RLP / typed envelope) encoding of a
+// transaction whose signature values are still zero. An unsigned legacy tx
+// may follow EIP-155 and carry v=chainID with r=s=0; chainID is the chain
+// the caller asked to sign for and v must be 0 or equal to it.
+func DecodeUnsignedTx(raw []byte, chainID *big.Int) (*types.Transaction, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+	v, r, s := tx.RawSignatureValues()
+	if r.Sign() != 0 || s.Sign() != 0 {
+		return nil, errors.New("transaction is already signed")
+	}
+	if v.Sign() == 0 {
+		return tx, nil
+	}
+	if tx.Type() != types.LegacyTxType {
+		return nil, fmt.Errorf("unsigned typed tx must have v=0, got %s", v)
+	}
+	if chainID == nil || v.Cmp(chainID) != 0 {
+		return nil, fmt.Errorf("unsigned legacy tx has v=%s, want 0 or chain_id %v", v, chainID)
+	}
+	return tx, nil
+}
+
+// SigningChainID picks the chain ID for the sighash: typed txs carry their
+// own (which must match requested if given), legacy txs use requested (EIP-155).
+func SigningChainID(tx *types.Transaction, requested *big.Int) (*big.Int, error) {
+	if tx.Type() == types.LegacyTxType {
+		if requested == nil || requested.Sign() <= 0 {
+			return nil, errors.New("legacy tx requires chain_id")
+		}
+		return requested, nil
+	}
+	if requested != nil && requested.Sign() > 0 && requested.Cmp(tx.ChainId()) != 0 {
+		return nil, fmt.Errorf("chainId mismatch: tx=%s request=%s", tx.ChainId(), requested)
+	}
+	return tx.ChainId(), nil
+}
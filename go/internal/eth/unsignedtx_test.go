@@ -0,0 +1,81 @@
+package eth
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func mustMarshal(t *testing.T, tx *types.Transaction) []byte {
+	t.Helper()
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecodeUnsignedTx(t *testing.T) {
+	chain := big.NewInt(11155111)
+	to := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	legacy := func(v, r, s int64) []byte {
+		return mustMarshal(t, types.NewTx(&types.LegacyTx{
+			Nonce: 1, GasPrice: big.NewInt(1e9), Gas: 21000, To: &to, Value: big.NewInt(1),
+			V: big.NewInt(v), R: big.NewInt(r), S: big.NewInt(s),
+		}))
+	}
+	dyn := func(v int64) []byte {
+		return mustMarshal(t, types.NewTx(&types.DynamicFeeTx{
+			ChainID: chain, Nonce: 1, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2), Gas: 21000, To: &to,
+			V: big.NewInt(v), R: new(big.Int), S: new(big.Int),
+		}))
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := types.SignTx(types.NewTx(&types.DynamicFeeTx{ChainID: chain, Gas: 21000, To: &to}), types.LatestSignerForChainID(chain), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		raw     []byte
+		chainID *big.Int
+		wantErr string
+	}{
+		{"legacy v=r=s=0", legacy(0, 0, 0), chain, ""},
+		{"legacy v=0 without chain id", legacy(0, 0, 0), nil, ""},
+		{"legacy eip155 v=chainId", legacy(11155111, 0, 0), chain, ""},
+		{"legacy eip155 wrong chain", legacy(1, 0, 0), chain, "want 0 or chain_id"},
+		{"legacy eip155 no chain id", legacy(11155111, 0, 0), nil, "want 0 or chain_id"},
+		{"legacy r set", legacy(11155111, 1, 0), chain, "already signed"},
+		{"legacy s set", legacy(0, 0, 1), chain, "already signed"},
+		{"dynamic unsigned", dyn(0), chain, ""},
+		{"dynamic v set", dyn(1), chain, "must have v=0"},
+		{"dynamic signed", mustMarshal(t, signed), chain, "already signed"},
+		{"garbage", []byte{0x02, 0x01}, chain, "rlp"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx, err := DecodeUnsignedTx(tc.raw, tc.chainID)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if tx.To() == nil || *tx.To() != to {
+					t.Fatalf("to = %v, want %v", tx.To(), to)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Package hdkey derives BIP32-style child keys from a TSS public key, so one
+// keygen can serve many addresses. Only non-hardened derivation is possible:
+// nobody holds the private key. The child key is parent + delta, where delta
+// follows from the parent public key, the chain code and the path; the nodes
+// sign for it by adding delta to their shares (tss-lib's key derivation delta).
+//
+// A TSS key has no chain code of its own, so ChainCode fixes one from the
+// public key. Anyone who knows the parent public key can therefore link its
+// child addresses; they are fresh addresses, not private ones.
+package hdkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/bnb-chain/tss-lib/v2/crypto/ckd"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+// MaxDepth bounds the number of path components.
+const MaxDepth = 16
+
+// chainCodeTag separates the chain code hash from other uses of the key.
+const chainCodeTag = "mp-htlc-lgp/tss-hd-chaincode/v1"
+
+// Path is a parsed derivation path; nil or empty is the key itself.
+type Path []uint32
+
+// ParsePath reads "m/0/7", "0/7" or "" (the key itself). Components are
+// decimal and below 2^31; hardened ones ("0'", "0h") are rejected.
+func ParsePath(s string) (Path, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "m"), "/")
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) > MaxDepth {
+		return nil, fmt.Errorf("path deeper than %d", MaxDepth)
+	}
+	p := make(Path, 0, len(parts))
+	for _, part := range parts {
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") || strings.HasSuffix(part, "H") {
+			return nil, errors.New("hardened derivation needs the private key; use non-hardened indexes")
+		}
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || n >= ckd.HardenedKeyStart {
+			return nil, fmt.Errorf("bad path component %q", part)
+		}
+		p = append(p, uint32(n))
+	}
+	return p, nil
+}
+
+// String is the canonical form, "m/0/7"; "" for the key itself.
+func (p Path) String() string {
+	if len(p) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("m")
+	for _, n := range p {
+		b.WriteString("/")
+		b.WriteString(strconv.FormatUint(uint64(n), 10))
+	}
+	return b.String()
+}
+
+// ChainCode is the chain code used for pub: SHA-256 over a fixed tag and the
+// compressed public key.
+func ChainCode(pub *ecdsa.PublicKey) []byte {
+	h := sha256.New()
+	h.Write([]byte(chainCodeTag))
+	h.Write(elliptic.MarshalCompressed(tss.S256(), pub.X, pub.Y))
+	return h.Sum(nil)
+}
+
+// Derive returns the child of the secp256k1 key pub at p and delta, the
+// scalar that turns the parent's private key (and each party's share) into
+// the child's. An empty path returns pub and a nil delta.
+func Derive(pub *ecdsa.PublicKey, p Path) (*big.Int, *ecdsa.PublicKey, error) {
+	if len(p) == 0 {
+		return nil, pub, nil
+	}
+	parent := &ckd.ExtendedKey{
+		PublicKey: ecdsa.PublicKey{Curve: tss.S256(), X: pub.X, Y: pub.Y},
+		ChainCode: ChainCode(pub),
+		ParentFP:  []byte{0, 0, 0, 0},
+	}
+	delta, child, err := deriveExtended(parent, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return delta, &child.PublicKey, nil
+}
+
+// deriveExtended is BIP32 public derivation (CKDpub) of parent along p; delta
+// is the sum of the tweaks.
+func deriveExtended(parent *ckd.ExtendedKey, p Path) (*big.Int, *ckd.ExtendedKey, error) {
+	ec := tss.S256()
+	delta, child, err := ckd.DeriveChildKeyFromHierarchy(p, parent, ec.Params().N, ec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("derive %s: %w", p, err)
+	}
+	return delta, child, nil
+}
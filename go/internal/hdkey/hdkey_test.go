@@ -0,0 +1,184 @@
+package hdkey
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/bnb-chain/tss-lib/v2/crypto"
+	"github.com/bnb-chain/tss-lib/v2/crypto/ckd"
+	"github.com/bnb-chain/tss-lib/v2/tss"
+)
+
+// Public derivation steps from the BIP32 test vectors 1 and 2: each starts at
+// the deepest extended public key before a run of non-hardened indexes. The
+// last case is the all-public chain of vector 2's master key (as in btcutil's
+// hdkeychain tests).
+func TestDeriveBIP32Vectors(t *testing.T) {
+	cases := []struct {
+		name   string
+		parent string
+		path   Path
+		want   string
+	}{
+		{
+			name:   "vector 1 m/0H -> m/0H/1",
+			parent: "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+			path:   Path{1},
+			want:   "xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ",
+		},
+		{
+			name:   "vector 1 m/0H/1/2H -> m/0H/1/2H/2",
+			parent: "xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+			path:   Path{2},
+			want:   "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+		},
+		{
+			name:   "vector 1 m/0H/1/2H -> m/0H/1/2H/2/1000000000",
+			parent: "xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+			path:   Path{2, 1000000000},
+			want:   "xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+		},
+		{
+			name:   "vector 2 m -> m/0",
+			parent: "xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB",
+			path:   Path{0},
+			want:   "xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH",
+		},
+		{
+			name:   "vector 2 m/0/2147483647H -> m/0/2147483647H/1",
+			parent: "xpub6ASAVgeehLbnwdqV6UKMHVzgqAG8Gr6riv3Fxxpj8ksbH9ebxaEyBLZ85ySDhKiLDBrQSARLq1uNRts8RuJiHjaDMBU4Zn9h8LZNnBC5y4a",
+			path:   Path{1},
+			want:   "xpub6DF8uhdarytz3FWdA8TvFSvvAh8dP3283MY7p2V4SeE2wyWmG5mg5EwVvmdMVCQcoNJxGoWaU9DCWh89LojfZ537wTfunKau47EL2dhHKon",
+		},
+		{
+			name:   "vector 2 m/0/2147483647H/1/2147483646H -> .../2",
+			parent: "xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL",
+			path:   Path{2},
+			want:   "xpub6FnCn6nSzZAw5Tw7cgR9bi15UV96gLZhjDstkXXxvCLsUXBGXPdSnLFbdpq8p9HmGsApME5hQTZ3emM2rnY5agb9rXpVGyy3bdW6EEgAtqt",
+		},
+		{
+			name:   "vector 2 master -> m/0/2147483647/1/2147483646/2",
+			parent: "xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB",
+			path:   Path{0, 2147483647, 1, 2147483646, 2},
+			want:   "xpub6H7WkJf547AiSwAbX6xsm8Bmq9M9P1Gjequ5SipsjipWmtXSyp4C3uwzewedGEgAMsDy4jEvNTWtxLyqqHY9C12gaBmgUdk2CGmwachwnWK",
+		},
+	}
+	ec := tss.S256()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			parent, err := ckd.NewExtendedKeyFromString(tc.parent, ec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			delta, child, err := deriveExtended(parent, tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := child.String(); got != tc.want {
+				t.Fatalf("child = %s\n       want %s", got, tc.want)
+			}
+			// the nodes sign for the child with share + delta: parent + delta*G must be the child
+			checkDelta(t, &parent.PublicKey, delta, &child.PublicKey)
+		})
+	}
+}
+
+func checkDelta(t *testing.T, parent *ecdsa.PublicKey, delta *big.Int, child *ecdsa.PublicKey) {
+	t.Helper()
+	ec := tss.S256()
+	p, err := crypto.NewECPoint(ec, parent.X, parent.Y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := p.Add(crypto.ScalarBaseMult(ec, delta))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.X().Cmp(child.X) != 0 || sum.Y().Cmp(child.Y) != 0 {
+		t.Fatal("parent + delta*G != child")
+	}
+}
+
+func TestDerive(t *testing.T) {
+	parent, err := ckd.NewExtendedKeyFromString("xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB", tss.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &parent.PublicKey
+
+	delta, got, err := Derive(pub, nil)
+	if err != nil || delta != nil || got != pub {
+		t.Fatalf("empty path: delta=%v key changed=%v err=%v", delta, got != pub, err)
+	}
+
+	// m/0/7 in two steps equals m/0/7 at once, with the chain code fixed from the key
+	p07, _ := ParsePath("m/0/7")
+	delta, child, err := Derive(pub, p07)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDelta(t, pub, delta, child)
+	_, mid, err := deriveExtended(&ckd.ExtendedKey{PublicKey: *pub, ChainCode: ChainCode(pub), ParentFP: []byte{0, 0, 0, 0}}, Path{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, step, err := deriveExtended(mid, Path{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.X.Cmp(child.X) != 0 || step.Y.Cmp(child.Y) != 0 {
+		t.Fatal("m/0/7 differs from m/0 then 7")
+	}
+	// the standard chain code of the vector gives another child: ours is fixed from the key
+	_, std, _ := deriveExtended(parent, p07)
+	if std.X.Cmp(child.X) == 0 {
+		t.Fatal("Derive used the vector's chain code instead of ChainCode(pub)")
+	}
+
+	if _, _, err := Derive(pub, Path{ckd.HardenedKeyStart}); err == nil {
+		t.Fatal("hardened index derived")
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{"", "", ""},
+		{"m", "", ""},
+		{"m/", "", ""},
+		{" m/0/7 ", "m/0/7", ""},
+		{"0/7", "m/0/7", ""},
+		{"m/2147483647", "m/2147483647", ""},
+		{"m/2147483648", "", "bad path component"},
+		{"m/0'/1", "", "hardened"},
+		{"m/0h", "", "hardened"},
+		{"m/0H", "", "hardened"},
+		{"m/-1", "", "bad path component"},
+		{"m/x", "", "bad path component"},
+		{"m//1", "", "bad path component"},
+		{"m" + strings.Repeat("/1", MaxDepth), "m" + strings.Repeat("/1", MaxDepth), ""},
+		{"m" + strings.Repeat("/1", MaxDepth+1), "", "deeper"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			p, err := ParsePath(tc.in)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.String(); got != tc.want {
+				t.Fatalf("String() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
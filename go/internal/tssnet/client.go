@@ -6,14 +6,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+
+	"mp-htlc-lgp/experiment/internal/apiauth"
 )
 
 type Client struct {
 	BaseURL string
 	HTTP    *http.Client
+	Creds   apiauth.Credentials // optional API key / HMAC credentials
+	KeyID   string              // cluster key to use; empty = gateway default
+	Path    string              // derivation path of the child key to sign with ("m/0/7"); empty = the key itself
 }
 
 type addrResp struct {
@@ -23,6 +34,8 @@ type addrResp struct {
 
 type signReq struct {
 	HashHex string `json:"hash_hex"`
+	KeyID   string `json:"key_id,omitempty"`
+	Path    string `json:"path,omitempty"`
 }
 
 type signResp struct {
@@ -30,20 +43,91 @@ type signResp struct {
 	S string `json:"s"`
 }
 
+type signTxReq struct {
+	ChainID *big.Int `json:"chain_id,omitempty"`
+	TxRLP   string   `json:"tx_rlp"`
+	KeyID   string   `json:"key_id,omitempty"`
+	Path    string   `json:"path,omitempty"`
+}
+
+type signTxResp struct {
+	Raw  string `json:"raw"`
+	Hash string `json:"hash"`
+}
+
+type sig65Resp struct {
+	Signature string `json:"signature"`
+}
+
+type authorizeClaimReq struct {
+	HTLC   string `json:"htlc"`
+	LockID string `json:"lockId"`
+	KeyID  string `json:"key_id,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+type personalSignReq struct {
+	MessageHex string `json:"message_hex"`
+	KeyID      string `json:"key_id,omitempty"`
+	Path       string `json:"path,omitempty"`
+}
+
 func New(baseURL string) *Client {
 	baseURL = strings.TrimRight(baseURL, "/")
 	return &Client{BaseURL: baseURL, HTTP: &http.Client{Timeout: 30 * time.Second}}
 }
 
-func (c *Client) GetAddress() (addr string, pubkey string, err error) {
-	resp, err := c.HTTP.Get(c.BaseURL + "/address")
+// UseTLS switches the client to HTTPS trusting caFile, presenting
+// certFile/keyFile as client certificate when set (mutual TLS).
+func (c *Client) UseTLS(certFile, keyFile, caFile string) error {
+	cfg, err := apiauth.ClientTLSConfig(certFile, keyFile, caFile)
 	if err != nil {
-		return "", "", err
+		return err
+	}
+	c.HTTP.Transport = &http.Transport{TLSClientConfig: cfg}
+	return nil
+}
+
+func (c *Client) keyQuery() string {
+	q := url.Values{}
+	if c.KeyID != "" {
+		q.Set("key_id", c.KeyID)
+	}
+	if c.Path != "" {
+		q.Set("path", c.Path)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// do sends an authenticated request and returns the body of a 200 response.
+func (c *Client) do(method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.Creds.Apply(req, body)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 	b, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != 200 {
-		return "", "", fmt.Errorf("/address status %d: %s", resp.StatusCode, string(b))
+		return nil, fmt.Errorf("%s status %d: %s", path, resp.StatusCode, string(b))
+	}
+	return b, nil
+}
+
+func (c *Client) GetAddress() (addr string, pubkey string, err error) {
+	b, err := c.do(http.MethodGet, "/address"+c.keyQuery(), nil)
+	if err != nil {
+		return "", "", err
 	}
 	var out addrResp
 	if err := json.Unmarshal(b, &out); err != nil {
@@ -56,16 +140,11 @@ func (c *Client) SignHash(hash32 []byte) (r32 []byte, s32 []byte, err error) {
 	if len(hash32) != 32 {
 		return nil, nil, fmt.Errorf("hash must be 32 bytes")
 	}
-	reqBody, _ := json.Marshal(signReq{HashHex: "0x" + hex.EncodeToString(hash32)})
-	resp, err := c.HTTP.Post(c.BaseURL+"/signHash", "application/json", bytes.NewReader(reqBody))
+	reqBody, _ := json.Marshal(signReq{HashHex: "0x" + hex.EncodeToString(hash32), KeyID: c.KeyID, Path: c.Path})
+	b, err := c.do(http.MethodPost, "/signHash", reqBody)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer resp.Body.Close()
-	b, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		return nil, nil, fmt.Errorf("/signHash status %d: %s", resp.StatusCode, string(b))
-	}
 	var out signResp
 	if err := json.Unmarshal(b, &out); err != nil {
 		return nil, nil, err
@@ -81,6 +160,79 @@ func (c *Client) SignHash(hash32 []byte) (r32 []byte, s32 []byte, err error) {
 	return r, s, nil
 }
 
+// SignTx sends an unsigned typed tx to /signTx and returns the signed tx.
+// The signer computes the sighash and recovery id itself.
+func (c *Client) SignTx(chainID *big.Int, tx *types.Transaction) (*types.Transaction, error) {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	reqBody, _ := json.Marshal(signTxReq{ChainID: chainID, TxRLP: "0x" + hex.EncodeToString(raw), KeyID: c.KeyID, Path: c.Path})
+	b, err := c.do(http.MethodPost, "/signTx", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	var out signTxResp
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	signedRaw, err := hex.DecodeString(strings.TrimPrefix(out.Raw, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("bad raw: %w", err)
+	}
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(signedRaw); err != nil {
+		return nil, err
+	}
+	if out.Hash != "" && !strings.EqualFold(out.Hash, signed.Hash().Hex()) {
+		return nil, fmt.Errorf("tx hash mismatch: signer=%s decoded=%s", out.Hash, signed.Hash().Hex())
+	}
+	return signed, nil
+}
+
+// SignTypedData signs EIP-712 typed data via /signTypedData and returns the
+// 65-byte signature with v = 27/28.
+func (c *Client) SignTypedData(td apitypes.TypedData) ([]byte, error) {
+	reqBody, err := json.Marshal(td)
+	if err != nil {
+		return nil, err
+	}
+	return c.postSig65("/signTypedData"+c.keyQuery(), reqBody)
+}
+
+// PersonalSign signs msg as an EIP-191 personal message via /personalSign and
+// returns the 65-byte signature with v = 27/28.
+func (c *Client) PersonalSign(msg []byte) ([]byte, error) {
+	reqBody, _ := json.Marshal(personalSignReq{MessageHex: "0x" + hex.EncodeToString(msg), KeyID: c.KeyID, Path: c.Path})
+	return c.postSig65("/personalSign", reqBody)
+}
+
+// AuthorizeClaim asks the signer to check locks(lockId) on chain and, if the
+// lock is claimable, sign its ClaimDigest. Returns 65 bytes with v = 27/28.
+func (c *Client) AuthorizeClaim(htlc common.Address, lockId [32]byte) ([]byte, error) {
+	reqBody, _ := json.Marshal(authorizeClaimReq{HTLC: htlc.Hex(), LockID: "0x" + hex.EncodeToString(lockId[:]), KeyID: c.KeyID, Path: c.Path})
+	return c.postSig65("/authorizeClaim", reqBody)
+}
+
+func (c *Client) postSig65(path string, reqBody []byte) ([]byte, error) {
+	b, err := c.do(http.MethodPost, path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	var out sig65Resp
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(out.Signature, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("bad signature: %w", err)
+	}
+	if len(sig) != 65 {
+		return nil, fmt.Errorf("signature must be 65 bytes, got %d", len(sig))
+	}
+	return sig, nil
+}
+
 func decode32(h string) ([]byte, error) {
 	h = strings.TrimSpace(h)
 	h = strings.TrimPrefix(h, "0x")
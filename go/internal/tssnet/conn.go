@@ -0,0 +1,329 @@
+package tssnet
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tham số kết nối tới coordinator.
+const (
+	PingPeriod = 15 * time.Second // client gửi ping định kỳ
+	PongWait   = 45 * time.Second // không nhận được gì trong khoảng này => coi như mất kết nối
+	writeWait  = 10 * time.Second
+
+	backoffMin = 200 * time.Millisecond
+	backoffMax = 10 * time.Second
+
+	maxPending = 4096 // message giữ lại khi đang mất kết nối
+
+	clockSamples = 8 // số mẫu ping/pong giữ lại để ước lượng lệch đồng hồ
+)
+
+// ErrQueueFull: đang mất kết nối và hàng đợi gửi đã đầy.
+var ErrQueueFull = errors.New("coordinator connection down and send queue full")
+
+// Conn là kết nối WebSocket tới coordinator dùng chung cho node và gateway:
+// tự reconnect với exponential backoff, gửi lại hello (kèm Resume) sau mỗi
+// lần nối lại, giữ message gửi trong lúc mất kết nối rồi gửi bù theo thứ tự.
+type Conn struct {
+	url   string
+	opts  DialOptions
+	hello func(resume bool) WSMessage // hello cho mỗi lần (re)connect
+
+	mu         sync.Mutex // bảo vệ ws, pending, trạng thái và mọi lần ghi
+	ws         *websocket.Conn
+	welcome    *WSMessage // welcome của lần nối gần nhất, Run giao cho handle trước
+	pending    [][]byte
+	dropped    int
+	since      time.Time // lần đổi trạng thái (nối / mất kết nối) gần nhất
+	reconnects int
+	lastErr    string
+
+	clockMu sync.Mutex
+	clock   []clockSample
+
+	sink func(WSMessage) // NewLocalConn: nhận mọi message gửi đi thay cho coordinator
+}
+
+// ConnState là trạng thái kết nối tới coordinator (cho trang status của node).
+type ConnState struct {
+	URL           string    `json:"url"`
+	Connected     bool      `json:"connected"`
+	Since         time.Time `json:"since"`      // lúc nối được / mất kết nối
+	Reconnects    int       `json:"reconnects"` // số lần nối lại từ lúc process chạy
+	Pending       int       `json:"pending"`    // message chờ gửi khi đang mất kết nối
+	LastErr       string    `json:"last_err,omitempty"`
+	ClockOffsetMs int64     `json:"clock_offset_ms"`
+	ClockRTTMs    int64     `json:"clock_rtt_ms"`
+	ClockSynced   bool      `json:"clock_synced"`
+}
+
+// clockSample là một lần ping/pong với coordinator.
+type clockSample struct {
+	offset, rtt time.Duration
+}
+
+// DialOptions là tuỳ chọn kết nối tới coordinator.
+type DialOptions struct {
+	Identity ed25519.PrivateKey // ký challenge của coordinator (nil = gửi auth rỗng)
+	TLS      *tls.Config        // cho wss:// (nil = mặc định của hệ thống)
+}
+
+// Dial mở kết nối đầu tiên (lỗi => trả về luôn, để process báo sai URL sớm).
+func Dial(url string, opts DialOptions, hello func(resume bool) WSMessage) (*Conn, error) {
+	c := &Conn{url: url, opts: opts, hello: hello}
+	ws, welcome, err := c.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	c.ws, c.welcome, c.since = ws, welcome, time.Now()
+	go c.syncClock()
+	return c, nil
+}
+
+func (c *Conn) connect(resume bool) (*websocket.Conn, *WSMessage, error) {
+	d := *websocket.DefaultDialer
+	d.TLSClientConfig = c.opts.TLS
+	ws, _, err := d.Dial(c.url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	welcome, err := c.handshake(ws, c.hello(resume))
+	if err != nil {
+		ws.Close()
+		return nil, nil, err
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(PongWait))
+	ws.SetPongHandler(func(string) error { return ws.SetReadDeadline(time.Now().Add(PongWait)) })
+	go c.keepalive(ws)
+	return ws, welcome, nil
+}
+
+// handshake gửi hello, trả lời challenge của coordinator bằng chữ ký của
+// Identity (xem identity.go) rồi chờ welcome. Bị từ chối (coordinator đóng
+// kết nối kèm lý do) => lỗi, reconnect tiếp tục backoff.
+func (c *Conn) handshake(ws *websocket.Conn, hello WSMessage) (*WSMessage, error) {
+	_ = ws.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := ws.WriteMessage(websocket.TextMessage, MustJSON(hello)); err != nil {
+		return nil, err
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(PongWait))
+	var ch WSMessage
+	if err := ws.ReadJSON(&ch); err != nil {
+		return nil, fmt.Errorf("waiting for challenge: %w", err)
+	}
+	if ch.Type != "challenge" {
+		return nil, fmt.Errorf("expected challenge, got %q", ch.Type)
+	}
+	nonce, err := hex.DecodeString(ch.Nonce)
+	if err != nil || len(nonce) != NonceSize {
+		return nil, errors.New("bad challenge nonce")
+	}
+	auth := WSMessage{Type: "auth", Session: hello.Session, Party: hello.Party}
+	if c.opts.Identity != nil {
+		auth.Sig = hex.EncodeToString(ed25519.Sign(c.opts.Identity, HelloProof(hello.Session, hello.Party, nonce)))
+	}
+	_ = ws.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := ws.WriteMessage(websocket.TextMessage, MustJSON(auth)); err != nil {
+		return nil, err
+	}
+	var welcome WSMessage
+	if err := ws.ReadJSON(&welcome); err != nil {
+		return nil, fmt.Errorf("waiting for welcome: %w", err)
+	}
+	if welcome.Type != "welcome" {
+		return nil, fmt.Errorf("expected welcome, got %q", welcome.Type)
+	}
+	return &welcome, nil
+}
+
+// keepalive ping tới khi ws bị đóng.
+func (c *Conn) keepalive(ws *websocket.Conn) {
+	t := time.NewTicker(PingPeriod)
+	defer t.Stop()
+	for range t.C {
+		if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+			return
+		}
+	}
+}
+
+// NewLocalConn trả về Conn không nối tới coordinator: mọi message gửi đi được
+// giao cho sink (replay của node). Không dùng Run với Conn này.
+func NewLocalConn(sink func(WSMessage)) *Conn {
+	return &Conn{sink: sink, since: time.Now()}
+}
+
+// Send gửi m; khi đang mất kết nối, m được xếp hàng và gửi sau khi nối lại.
+func (c *Conn) Send(m WSMessage) error {
+	if c.sink != nil {
+		c.sink(m)
+		return nil
+	}
+	b := MustJSON(m)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ws != nil {
+		_ = c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+		err := c.ws.WriteMessage(websocket.TextMessage, b)
+		if err == nil {
+			return nil
+		}
+		// Run sẽ thấy lỗi đọc và reconnect; message này gửi lại sau
+		c.ws.Close()
+		c.downLocked(err)
+	}
+	if len(c.pending) >= maxPending {
+		c.dropped++
+		return ErrQueueFull
+	}
+	c.pending = append(c.pending, b)
+	return nil
+}
+
+// Run đọc message và gọi handle cho tới khi process dừng; lỗi đọc => nối lại
+// (backoff 200ms..10s, có jitter), gửi hello với Resume rồi gửi bù pending.
+func (c *Conn) Run(handle func(WSMessage)) {
+	for {
+		c.mu.Lock()
+		ws, welcome := c.ws, c.welcome
+		c.welcome = nil
+		c.mu.Unlock()
+		if ws != nil {
+			if welcome != nil {
+				handle(*welcome)
+			}
+			err := c.readAll(ws, handle)
+			c.mu.Lock()
+			if c.ws == ws {
+				c.downLocked(err)
+			}
+			c.mu.Unlock()
+			ws.Close()
+		}
+		c.reconnect()
+	}
+}
+
+func (c *Conn) readAll(ws *websocket.Conn, handle func(WSMessage)) error {
+	for {
+		_, b, err := ws.ReadMessage()
+		if err != nil {
+			log.Printf("ws: connection to %s lost: %v", c.url, err)
+			return err
+		}
+		_ = ws.SetReadDeadline(time.Now().Add(PongWait))
+		var m WSMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			continue
+		}
+		if m.Type == "pong" && m.EchoMs != 0 {
+			c.addClockSample(m)
+			continue
+		}
+		handle(m)
+	}
+}
+
+func (c *Conn) reconnect() {
+	wait := backoffMin
+	for attempt := 1; ; attempt++ {
+		time.Sleep(wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1)))
+		ws, welcome, err := c.connect(true)
+		if err == nil {
+			c.mu.Lock()
+			flushed, dropped := len(c.pending), c.dropped
+			for len(c.pending) > 0 {
+				_ = ws.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := ws.WriteMessage(websocket.TextMessage, c.pending[0]); err != nil {
+					break
+				}
+				c.pending = c.pending[1:]
+			}
+			c.dropped = 0
+			c.ws, c.welcome, c.since = ws, welcome, time.Now()
+			c.reconnects++
+			c.mu.Unlock()
+			log.Printf("ws: reconnected to %s after %d attempts (flushed %d queued, dropped %d)", c.url, attempt, flushed, dropped)
+			return
+		}
+		if attempt == 1 || attempt%10 == 0 {
+			log.Printf("ws: reconnect to %s: %v (attempt %d)", c.url, err, attempt)
+		}
+		if wait *= 2; wait > backoffMax {
+			wait = backoffMax
+		}
+	}
+}
+
+// downLocked ghi nhận mất kết nối; c.mu phải đang được giữ.
+func (c *Conn) downLocked(err error) {
+	c.ws, c.since = nil, time.Now()
+	if err != nil {
+		c.lastErr = err.Error()
+	}
+}
+
+// State trả về trạng thái kết nối hiện tại.
+func (c *Conn) State() ConnState {
+	c.mu.Lock()
+	st := ConnState{URL: c.url, Connected: c.ws != nil, Since: c.since, Reconnects: c.reconnects, Pending: len(c.pending), LastErr: c.lastErr}
+	c.mu.Unlock()
+	if off, rtt, ok := c.ClockOffset(); ok {
+		st.ClockOffsetMs, st.ClockRTTMs, st.ClockSynced = off.Milliseconds(), rtt.Milliseconds(), true
+	}
+	return st
+}
+
+// syncClock ping coordinator định kỳ (ping ứng dụng, mang giờ client) để ước
+// lượng lệch đồng hồ; bỏ qua khi đang mất kết nối.
+func (c *Conn) syncClock() {
+	for {
+		c.mu.Lock()
+		up := c.ws != nil
+		c.mu.Unlock()
+		if up {
+			_ = c.Send(WSMessage{Type: "ping", TsMs: NowMs()})
+		}
+		time.Sleep(PingPeriod)
+	}
+}
+
+func (c *Conn) addClockSample(m WSMessage) {
+	now := NowMs()
+	rtt := now - m.EchoMs
+	if rtt < 0 {
+		return
+	}
+	// giả định đường đi và về mất thời gian như nhau
+	off := m.TsMs - (m.EchoMs+now)/2
+	c.clockMu.Lock()
+	defer c.clockMu.Unlock()
+	if len(c.clock) == clockSamples {
+		c.clock = c.clock[1:]
+	}
+	c.clock = append(c.clock, clockSample{offset: time.Duration(off) * time.Millisecond, rtt: time.Duration(rtt) * time.Millisecond})
+}
+
+// ClockOffset trả về đồng hồ coordinator - đồng hồ local, lấy từ mẫu có RTT
+// nhỏ nhất trong các mẫu gần đây; ok = false khi chưa có mẫu nào.
+func (c *Conn) ClockOffset() (offset, rtt time.Duration, ok bool) {
+	c.clockMu.Lock()
+	defer c.clockMu.Unlock()
+	for i, s := range c.clock {
+		if i == 0 || s.rtt < rtt {
+			offset, rtt, ok = s.offset, s.rtt, true
+		}
+	}
+	return offset, rtt, ok
+}
@@ -0,0 +1,191 @@
+package tssnet
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"mp-htlc-lgp/experiment/internal/apiauth"
+)
+
+// Danh tính của party: mỗi node/gateway giữ một key ed25519 lâu dài. Khi nhận
+// hello, coordinator trả "challenge" kèm nonce ngẫu nhiên; party ký
+// HelloProof(session, party, nonce) và gửi lại trong "auth". Coordinator
+// chạy với -registry chỉ nhận party có trong registry và chữ ký đúng pubkey
+// đã đăng ký; không có registry thì auth không được kiểm tra.
+
+// helloTag tách chữ ký hello khỏi mọi chữ ký khác của cùng key.
+const helloTag = "mp-htlc-lgp/tss-hello/v1"
+
+// NonceSize là số byte của nonce trong challenge.
+const NonceSize = 32
+
+// HelloProof là dữ liệu party ký để trả lời challenge.
+func HelloProof(session, party string, nonce []byte) []byte {
+	b := make([]byte, 0, len(helloTag)+len(session)+len(party)+len(nonce)+3)
+	b = append(append(b, helloTag...), 0)
+	b = append(append(b, session...), 0)
+	b = append(append(b, party...), 0)
+	return append(b, nonce...)
+}
+
+// LoadIdentity đọc key danh tính: seed 32 byte (raw hoặc 64 ký tự hex).
+func LoadIdentity(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed := b
+	if len(b) != ed25519.SeedSize {
+		if seed, err = hex.DecodeString(strings.TrimSpace(string(b))); err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%s: identity key must hold 32 raw bytes or 64 hex characters", path)
+		}
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// EnsureIdentity trả về pubkey của key danh tính ở path (để đưa vào registry
+// của coordinator), tạo key mới nếu file chưa có.
+func EnsureIdentity(path string) (ed25519.PublicKey, error) {
+	if path == "" {
+		return nil, errors.New("identity key path is empty")
+	}
+	if priv, err := LoadIdentity(path); err == nil {
+		return priv.Public().(ed25519.PublicKey), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(hex.EncodeToString(priv.Seed()) + "\n"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return pub, f.Close()
+}
+
+// Vai trò của party trong registry và trong hello. Chỉ gateway được gửi cmd
+// điều khiển (keygen, sign, presign, reshare*, cancel, ...); node chỉ gửi
+// wire message, "*_result" và "abort" cho peer trong phiên.
+const (
+	RoleGateway = "gateway"
+	RoleNode    = "node"
+)
+
+// Registry là danh sách party được phép vào từng session, kèm pubkey ed25519
+// và vai trò.
+type Registry struct {
+	Keys  map[string]map[string]ed25519.PublicKey // session -> party -> pubkey
+	Roles map[string]map[string]string            // session -> party -> RoleGateway | RoleNode
+}
+
+// registryEntry là một party trong file registry: chuỗi pubkey hex (vai trò
+// node) hoặc {"pubkey": "<hex>", "role": "gateway"|"node"}.
+type registryEntry struct {
+	PubKey string `json:"pubkey"`
+	Role   string `json:"role"`
+}
+
+func (e *registryEntry) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*e = registryEntry{PubKey: s, Role: RoleNode}
+		return nil
+	}
+	type plain registryEntry
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*e = registryEntry(p)
+	return nil
+}
+
+// LoadRegistry đọc file JSON {"<session>": {"<party>": "<pubkey hex>" |
+// {"pubkey": "<hex>", "role": "gateway"}}}. Mỗi session cần đúng một gateway.
+func LoadRegistry(path string) (*Registry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]map[string]registryEntry
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	reg := &Registry{Keys: map[string]map[string]ed25519.PublicKey{}, Roles: map[string]map[string]string{}}
+	for session, parties := range raw {
+		reg.Keys[session] = map[string]ed25519.PublicKey{}
+		reg.Roles[session] = map[string]string{}
+		gateways := 0
+		for party, e := range parties {
+			pub, err := hex.DecodeString(strings.TrimPrefix(e.PubKey, "0x"))
+			if err != nil || len(pub) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("%s: bad public key for %s/%s", path, session, party)
+			}
+			switch e.Role {
+			case RoleGateway:
+				gateways++
+			case RoleNode, "":
+				e.Role = RoleNode
+			default:
+				return nil, fmt.Errorf("%s: bad role %q for %s/%s", path, e.Role, session, party)
+			}
+			reg.Keys[session][party] = pub
+			reg.Roles[session][party] = e.Role
+		}
+		if gateways != 1 {
+			return nil, fmt.Errorf(`%s: session %s needs exactly one party with "role": "gateway" (has %d)`, path, session, gateways)
+		}
+	}
+	return reg, nil
+}
+
+// Role trả về vai trò đã đăng ký của party ("" nếu không có trong registry).
+func (r *Registry) Role(session, party string) string {
+	return r.Roles[session][party]
+}
+
+// Verify kiểm tra auth của party trả lời challenge nonce.
+func (r *Registry) Verify(session, party string, nonce []byte, sigHex string) error {
+	pub, ok := r.Keys[session][party]
+	if !ok {
+		return fmt.Errorf("party %s not registered for session %s", party, session)
+	}
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil || !ed25519.Verify(pub, HelloProof(session, party, nonce), sig) {
+		return errors.New("bad identity signature")
+	}
+	return nil
+}
+
+// ClientOptions dựng DialOptions từ flag của node/gateway: file key danh tính
+// và CA tin cậy cho wss:// (rỗng = không dùng).
+func ClientOptions(identityPath, caFile string) (DialOptions, error) {
+	var opts DialOptions
+	if identityPath != "" {
+		id, err := LoadIdentity(identityPath)
+		if err != nil {
+			return opts, err
+		}
+		opts.Identity = id
+	}
+	if caFile != "" {
+		cfg, err := apiauth.ClientTLSConfig("", "", caFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.TLS = cfg
+	}
+	return opts, nil
+}
@@ -0,0 +1,51 @@
+package tssnet
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadRegistry(t *testing.T) {
+	const pub = "6c7a0b1e8d1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f80910203"
+	cases := []struct {
+		name    string
+		json    string
+		roles   map[string]string
+		wantErr string
+	}{
+		{"gateway object, node strings", `{"s":{"G":{"pubkey":"` + pub + `","role":"gateway"},"P1":"` + pub + `","P2":{"pubkey":"0x` + pub + `"}}}`,
+			map[string]string{"G": RoleGateway, "P1": RoleNode, "P2": RoleNode}, ""},
+		{"no gateway", `{"s":{"G":"` + pub + `","P1":"` + pub + `"}}`, nil, "exactly one"},
+		{"two gateways", `{"s":{"G":{"pubkey":"` + pub + `","role":"gateway"},"H":{"pubkey":"` + pub + `","role":"gateway"}}}`, nil, "exactly one"},
+		{"unknown role", `{"s":{"G":{"pubkey":"` + pub + `","role":"gateway"},"P1":{"pubkey":"` + pub + `","role":"admin"}}}`, nil, "bad role"},
+		{"short key", `{"s":{"G":{"pubkey":"abcd","role":"gateway"}}}`, nil, "bad public key"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "registry.json")
+			if err := os.WriteFile(path, []byte(tc.json), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			reg, err := LoadRegistry(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for party, role := range tc.roles {
+				if got := reg.Role("s", party); got != role {
+					t.Errorf("role of %s = %q, want %q", party, got, role)
+				}
+				if len(reg.Keys["s"][party]) != 32 {
+					t.Errorf("key of %s not loaded", party)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package tssnet
+
+// TraceRecord là một dòng trong trace JSONL của coordinator (-trace): một
+// message được relay tới một người nhận. Message gửi cho n party => n dòng
+// cùng msg_id. Thời điểm là unix micro giây theo đồng hồ coordinator.
+type TraceRecord struct {
+	Session   string `json:"session"`
+	Job       string `json:"job,omitempty"`
+	Type      string `json:"type"`          // send | cmd | resume
+	Cmd       string `json:"cmd,omitempty"` // với type=cmd: sign | sign_result | ...
+	MsgID     string `json:"msg_id,omitempty"`
+	Round     int    `json:"round,omitempty"` // send: round tss-lib của wire message (0 = không rõ)
+	From      string `json:"from"`            // party gửi (kết nối)
+	To        string `json:"to"`
+	Bcast     bool   `json:"bcast,omitempty"`
+	Committee string `json:"committee,omitempty"`
+	Size      int    `json:"size"`             // byte JSON coordinator ghi ra websocket
+	RecvUs    int64  `json:"recv_us"`          // coordinator nhận message
+	FwdUs     int64  `json:"fwd_us,omitempty"` // coordinator ghi xong cho người nhận (sau WAN giả lập); 0 = vào mailbox
+	Queued    bool   `json:"queued,omitempty"` // người nhận đang offline: message nằm trong mailbox
+
+	// -trace-payload: nguyên message đã relay (cả payload), để node replay
+	// phiên offline (tss-node -replay)
+	Msg *WSMessage `json:"msg,omitempty"`
+}
@@ -2,22 +2,49 @@ package tssnet
 
 import (
 	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
 	"time"
 )
 
+// DefaultKeyID là key dùng khi request không chỉ định key_id.
+const DefaultKeyID = "default"
+
+var keyIDRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,63}$`)
+
+// ValidKeyID kiểm tra key_id dùng được làm tên file share.
+func ValidKeyID(id string) error {
+	if !keyIDRe.MatchString(id) {
+		return fmt.Errorf("bad key_id %q (want [A-Za-z0-9_.-], max 64, not starting with '.')", id)
+	}
+	return nil
+}
+
 // WSMessage là schema dùng chung giữa gateway/coordinator/node qua WebSocket.
 type WSMessage struct {
 	// basic routing/session
-	Type    string `json:"type,omitempty"`    // hello | cmd | send | pong | ...
+	Type    string `json:"type,omitempty"`    // hello | challenge | auth | welcome | resume | cmd | send | ...
 	Session string `json:"session,omitempty"` // cluster session id
 	Party   string `json:"party,omitempty"`   // P1..Pn hoặc gateway party id
 	Role    string `json:"role,omitempty"`    // "gateway" | "node" | "coordinator"
+	Resume  bool   `json:"resume,omitempty"`  // hello/welcome/resume: party nối lại sau khi mất kết nối
+
+	// xác thực party (xem identity.go)
+	Nonce string `json:"nonce,omitempty"` // challenge: nonce hex do coordinator sinh cho kết nối này
+	Sig   string `json:"sig,omitempty"`   // auth: chữ ký ed25519 (hex) của party trên HelloProof
 
 	// command (gateway -> nodes/coordinator)
-	Cmd       string   `json:"cmd,omitempty"`       // keygen | sign | keygen_result | sign_result ...
-	Parties   []string `json:"parties,omitempty"`   // danh sách parties trong phiên / hoặc target list
-	Threshold int      `json:"threshold,omitempty"` // t trong (t,n) nếu có
-	HashHex   string   `json:"hash_hex,omitempty"`  // 0x... (nếu có)
+	Cmd       string   `json:"cmd,omitempty"`         // keygen | sign | presign | cancel | abort | keygen_result | sign_result ...
+	Parties   []string `json:"parties,omitempty"`     // danh sách parties trong phiên / hoặc target list
+	Threshold int      `json:"threshold,omitempty"`   // t trong (t,n) nếu có
+	HashHex   string   `json:"hash_hex,omitempty"`    // 0x... (nếu có)
+	KeyID     string   `json:"key_id,omitempty"`      // key được keygen/ký (mặc định DefaultKeyID)
+	PreParams string   `json:"preparams,omitempty"`   // keygen: "warm" | "cold" | "" (warm nếu pool còn); kết quả: mode đã dùng
+	Job       string   `json:"job,omitempty"`         // id phiên TSS do gateway đặt; node gửi lại trong *_result và abort
+	Deadline  int64    `json:"deadline_ms,omitempty"` // unix ms; node tự huỷ phiên khi quá hạn (0 = mặc định của node)
+	Presig    string   `json:"presig,omitempty"`      // sign: job id của phiên presign đã chạy round 1-4 (chỉ còn phase online)
+	Path      string   `json:"path,omitempty"`        // sign: đường dẫn key con không-hardened ("m/0/7"); rỗng = ký bằng chính key
 
 	// response/result (nodes/coordinator -> gateway)
 	Ok        bool   `json:"ok,omitempty"`
@@ -27,20 +54,112 @@ type WSMessage struct {
 	RHex      string `json:"r_hex,omitempty"`      // 0x...
 	SHex      string `json:"s_hex,omitempty"`      // 0x...
 
+	// identifiable abort: cmd "abort" (node -> node) và *_result lỗi
+	Round    int      `json:"round,omitempty"`    // round tss-lib báo lỗi (0 = lỗi ngoài protocol); send: round của wire message (trace của coordinator)
+	Culprits []string `json:"culprits,omitempty"` // party bị tss-lib xác định là gây lỗi (tên node)
+
 	// p2p relay (node <-> coordinator <-> node) hoặc routing chung
 	From       string   `json:"from,omitempty"`        // sender party
 	To         []string `json:"to,omitempty"`          // receivers
 	Bcast      bool     `json:"bcast,omitempty"`       // broadcast flag
 	PayloadB64 string   `json:"payload_b64,omitempty"` // wire message base64
+	Committee  string   `json:"committee,omitempty"`   // reshare: "old" | "new" (instance nhận trên node)
+	Sealed     bool     `json:"sealed,omitempty"`      // send: payload_b64 mã hoá đầu-cuối cho người nhận duy nhất trong To (-e2e của node)
 
 	// optional trace
-	MsgID string `json:"msg_id,omitempty"`
-	TsMs  int64  `json:"ts_ms,omitempty"`
+	MsgID  string `json:"msg_id,omitempty"`  // wire message: "<from>#<seq>", duy nhất trong job (node bỏ message trùng)
+	TsMs   int64  `json:"ts_ms,omitempty"`   // ping: giờ client; pong: giờ coordinator
+	EchoMs int64  `json:"echo_ms,omitempty"` // pong: ts_ms của ping (ước lượng lệch đồng hồ)
+
+	// *_result của phiên TSS: số liệu từng round của node
+	Telemetry *Telemetry `json:"telemetry,omitempty"`
 
 	// optional generic payload (để mở rộng về sau)
 	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
+// KeyReport mô tả một key share mà node đang lưu (payload của "keys_result").
+type KeyReport struct {
+	KeyID     string    `json:"key_id"`
+	PubKeyHex string    `json:"pubkey_hex"`
+	AddrHex   string    `json:"addr_hex"`
+	Parties   []string  `json:"parties,omitempty"`    // rỗng với share cũ (keygen.json)
+	Threshold int       `json:"threshold,omitempty"`  // 0 nếu không rõ
+	PartyKeys []string  `json:"party_keys,omitempty"` // key tss-lib của từng party (thập phân); rỗng = 1..n
+	CreatedAt time.Time `json:"created_at"`
+
+	// Reshare là reshare chưa xong của key trên node này. Node chưa có share
+	// (party mới chưa commit) vẫn báo, khi đó PubKeyHex rỗng.
+	Reshare *ReshareState `json:"reshare,omitempty"`
+}
+
+// ReshareState là trạng thái một reshare chưa xong mà node lưu ở
+// keys/<key_id>.json.reshare, để commit / retire / finalize / rollback làm lại
+// được sau khi node hoặc gateway khởi động lại.
+type ReshareState struct {
+	Job   string      `json:"job"`
+	Phase string      `json:"phase"` // ResharePhaseStaged | ResharePhaseCommitted
+	Spec  ReshareSpec `json:"spec"`
+}
+
+// Giá trị của ReshareState.Phase.
+const (
+	ResharePhaseStaged    = "staged"    // protocol xong; party mới giữ share mới ở .next
+	ResharePhaseCommitted = "committed" // share mới đã cài, share cũ giữ ở .prev tới finalize
+)
+
+// ReshareSpec là payload của cmd "reshare": chuyển key từ committee cũ sang
+// committee mới. Keys là key tss-lib (thập phân) theo đúng thứ tự Parties;
+// key của committee mới không được trùng committee cũ.
+type ReshareSpec struct {
+	PubKeyHex    string   `json:"pubkey_hex"` // pubkey phải giữ nguyên sau reshare
+	OldParties   []string `json:"old_parties"`
+	OldKeys      []string `json:"old_keys"`
+	OldThreshold int      `json:"old_threshold"`
+	NewParties   []string `json:"new_parties"`
+	NewKeys      []string `json:"new_keys"`
+	NewThreshold int      `json:"new_threshold"`
+}
+
+// AllParties trả về old ∪ new, giữ thứ tự (old trước).
+func (s ReshareSpec) AllParties() []string {
+	out := append([]string{}, s.OldParties...)
+	for _, p := range s.NewParties {
+		if !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ReshareReport là payload của "reshare_result" từ một node thuộc committee mới.
+type ReshareReport struct {
+	PubKeyHex string `json:"pubkey_hex"`
+	BigXjHash string `json:"bigxj_hash"` // sha256 của public share mọi party; phải giống nhau giữa các node
+}
+
+// ErrNoPresig mở đầu Err của sign_result khi node không còn presignature được
+// chỉ định (node khởi động lại, hết hạn, bị đẩy khỏi pool); gateway ký lại
+// bằng protocol đầy đủ.
+const ErrNoPresig = "no such presignature"
+
+// Giá trị của WSMessage.PreParams.
+const (
+	PreParamsWarm = "warm" // dùng LocalPreParams sinh sẵn trong pool của node
+	PreParamsCold = "cold" // sinh Paillier/safe primes ngay trong round 1
+)
+
+// PreParamsStatus là trạng thái pool pre-params của một node (payload của "preparams_result").
+type PreParamsStatus struct {
+	Ready       int    `json:"ready"`       // số bộ pre-params sẵn dùng
+	Target      int    `json:"target"`      // kích thước pool mong muốn (0 = tắt)
+	Generating  bool   `json:"generating"`  // đang sinh thêm
+	Generated   int    `json:"generated"`   // số bộ đã sinh từ lúc node chạy
+	Interrupted int    `json:"interrupted"` // số lần đang sinh thì bị huỷ vì có phiên TSS bắt đầu
+	LastGenMs   int64  `json:"last_gen_ms"` // thời gian sinh bộ gần nhất
+	LastErr     string `json:"last_err,omitempty"`
+}
+
 func MustJSON(v any) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -50,3 +169,30 @@ func MustJSON(v any) []byte {
 }
 
 func NowMs() int64 { return time.Now().UnixNano() / int64(time.Millisecond) }
+
+// Telemetry là số liệu một phiên TSS trên một node. Thời điểm là unix ms theo
+// đồng hồ node; cộng ClockOffsetMs để ra đồng hồ coordinator.
+type Telemetry struct {
+	StartMs       int64        `json:"start_ms"`
+	EndMs         int64        `json:"end_ms"`
+	CPUMs         int64        `json:"cpu_ms"`          // CPU của cả process node trong phiên
+	ClockOffsetMs int64        `json:"clock_offset_ms"` // đồng hồ coordinator - đồng hồ node
+	ClockRTTMs    int64        `json:"clock_rtt_ms"`    // RTT của mẫu ping dùng cho offset
+	ClockSynced   bool         `json:"clock_synced"`    // false: chưa có mẫu ping, offset = 0
+	Rounds        []RoundStats `json:"rounds"`
+}
+
+// RoundStats là một round tss-lib trên node: bắt đầu khi node gửi message đầu
+// tiên của round (round 1: lúc Start), kết thúc khi round sau bắt đầu hoặc
+// phiên kết thúc. Round node chỉ nhận mà không gửi có start_ms = 0.
+type RoundStats struct {
+	Round      int   `json:"round"`
+	StartMs    int64 `json:"start_ms"`
+	EndMs      int64 `json:"end_ms"`
+	CPUMs      int64 `json:"cpu_ms"`
+	MsgsSent   int   `json:"msgs_sent"` // mỗi người nhận tính một message
+	BytesSent  int   `json:"bytes_sent"`
+	MsgsRecv   int   `json:"msgs_recv"`
+	BytesRecv  int   `json:"bytes_recv"`
+	LastRecvMs int64 `json:"last_recv_ms,omitempty"` // message cuối của round này nhận được lúc nào
+}
@@ -5,6 +5,32 @@ N=${1:-3}
 T=${2:-1}
 SESSION=${SESSION:-cluster}
 OUT=${OUT:-tssnet/docker-compose.tssnet.yml}
+RPC=${SEPOLIA_RPC_URL:-}
+# passphrase file that encrypts key shares at rest (absolute path on the host)
+SHARE_PASS=${TSS_SHARE_PASS_FILE:-}
+# 1 = party dùng key danh tính, coordinator kiểm tra registry (chạy gen_identities.sh trước)
+IDENTITY=${TSS_IDENTITY:-0}
+# 1 = node mã hoá đầu-cuối message unicast giữa các node (cần TSS_IDENTITY=1)
+E2E=${TSS_E2E:-0}
+
+# file topology WAN cho coordinator, đường dẫn tương đối thư mục tssnet (vd wan.example.json);
+# admin API của coordinator (GET/PUT/DELETE /wan) mở ở 127.0.0.1:9001, PUT/DELETE cần key scope admin
+# trong ADMIN_KEYS (tự sinh nếu chưa có; signbench.sh đọc key từ file này)
+WAN=${TSS_WAN:-}
+ADMIN_KEYS=tssnet/data/coordinator/admin_keys.json
+# 1 = coordinator ghi trace message ra tssnet/data/trace/trace.jsonl (phân tích bằng tracestat)
+TRACE=${TSS_TRACE:-0}
+# 1 = trace giữ cả payload message (-trace-payload) để replay một job bằng tss-node -replay
+TRACE_PAYLOAD=${TSS_TRACE_PAYLOAD:-0}
+
+if [ "$E2E" = "1" ] && [ "$IDENTITY" != "1" ]; then
+  echo "TSS_E2E=1 needs TSS_IDENTITY=1" >&2
+  exit 1
+fi
+if [ "$TRACE_PAYLOAD" = "1" ] && [ "$TRACE" != "1" ]; then
+  echo "TSS_TRACE_PAYLOAD=1 needs TSS_TRACE=1" >&2
+  exit 1
+fi
 
 if [ "$T" -lt 1 ] || [ "$T" -ge "$N" ]; then
   echo "Threshold T must satisfy 1 <= T < N" >&2
@@ -13,6 +39,15 @@ fi
 
 PARTIES=$(seq -s, -f 'P%g' 1 $N)
 
+# request từ host qua port map không phải loopback trong container, nên coordinator cần key
+if [ ! -f "$ADMIN_KEYS" ]; then
+  mkdir -p "$(dirname "$ADMIN_KEYS")"
+  SECRET=$(head -c 32 /dev/urandom | od -An -tx1 | tr -d ' \n')
+  printf '{"keys":[{"id":"bench","secret":"%s","scopes":["admin"]}]}\n' "$SECRET" > "$ADMIN_KEYS"
+  chmod 600 "$ADMIN_KEYS"
+  echo "wrote $ADMIN_KEYS"
+fi
+
 cat > "$OUT" <<YAML
 services:
   tss-coordinator:
@@ -23,6 +58,42 @@ services:
         CMD: tss-coordinator
     ports:
       - "9000:9000"
+      # admin API (WAN emulation), host loopback only
+      - "127.0.0.1:9001:9001"
+YAML
+
+echo '    volumes:' >> "$OUT"
+echo '      - ./data/coordinator/admin_keys.json:/admin_keys.json:ro' >> "$OUT"
+if [ "$IDENTITY" = "1" ] || [ -n "$WAN" ] || [ "$TRACE" = "1" ]; then
+  if [ "$IDENTITY" = "1" ]; then
+    echo '      - ./data/registry.json:/registry.json:ro' >> "$OUT"
+  fi
+  if [ -n "$WAN" ]; then
+    echo "      - ./$WAN:/wan.json:ro" >> "$OUT"
+  fi
+  if [ "$TRACE" = "1" ]; then
+    echo '      - ./data/trace:/trace' >> "$OUT"
+  fi
+fi
+cat >> "$OUT" <<YAML
+    command:
+      - "-admin-listen=:9001"
+      - "-admin-auth-keys=/admin_keys.json"
+YAML
+if [ "$IDENTITY" = "1" ]; then
+  echo '      - "-registry=/registry.json"' >> "$OUT"
+fi
+if [ -n "$WAN" ]; then
+  echo '      - "-wan=/wan.json"' >> "$OUT"
+fi
+if [ "$TRACE" = "1" ]; then
+  echo '      - "-trace=/trace/trace.jsonl"' >> "$OUT"
+fi
+if [ "$TRACE_PAYLOAD" = "1" ]; then
+  echo '      - "-trace-payload"' >> "$OUT"
+fi
+
+cat >> "$OUT" <<YAML
 
   tss-gateway:
     build:
@@ -34,6 +105,8 @@ services:
       - tss-coordinator
     ports:
       - "9100:9100"
+    volumes:
+      - ./data/G:/data
     command:
       - "-coordinator=ws://tss-coordinator:9000/ws"
       - "-session=$SESSION"
@@ -41,8 +114,18 @@ services:
       - "-parties=$PARTIES"
       - "-threshold=$T"
       - "-listen=:9100"
+      - "-keys-file=/data/keys.json"
 YAML
 
+if [ "$IDENTITY" = "1" ]; then
+  echo '      - "-identity-key=/data/identity.key"' >> "$OUT"
+fi
+
+if [ -n "$RPC" ]; then
+  # on-chain checks for /authorizeClaim
+  echo "      - \"-rpc=$RPC\"" >> "$OUT"
+fi
+
 for i in $(seq 1 $N); do
   PARTY="P$i"          # party ID (giữ uppercase)
   SVC="p$i"            # service name (lowercase để Docker hợp lệ)
@@ -59,10 +142,13 @@ for i in $(seq 1 $N); do
       - tss-coordinator
     cap_add:
       - NET_ADMIN
+    ports:
+      # status/admin listener, host loopback only
+      - "127.0.0.1:$((9300 + i)):9300"
     entrypoint:
       - /usr/local/bin/node_entrypoint.sh
     volumes:
-      - ./data/$PARTY:/data
+      - ./data/$PARTY:/data$( [ -n "$SHARE_PASS" ] && printf '\n      - %s:/run/secrets/share_pass:ro' "$SHARE_PASS" )$( [ "$E2E" = "1" ] && printf '\n      - ./data/registry.json:/registry.json:ro' )
     environment:
       # set any of these to enable network emulation inside the container
       # LATENCY_MS: "80"
@@ -78,7 +164,19 @@ for i in $(seq 1 $N); do
       - "-party=$PARTY"
       - "-data=/data"
       - "-gateway=G"
+      - "-admin-listen=:9300"
 YAML
+
+  if [ -n "$SHARE_PASS" ]; then
+    echo '      - "-share-pass-file=/run/secrets/share_pass"' >> "$OUT"
+  fi
+  if [ "$IDENTITY" = "1" ]; then
+    echo '      - "-identity-key=/data/identity.key"' >> "$OUT"
+  fi
+  if [ "$E2E" = "1" ]; then
+    echo '      - "-e2e"' >> "$OUT"
+    echo '      - "-registry=/registry.json"' >> "$OUT"
+  fi
 done
 
 echo "wrote $OUT (N=$N, T=$T, parties=$PARTIES, session=$SESSION)"
@@ -0,0 +1,37 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Sinh key danh tính ed25519 cho gateway và N node (tssnet/data/<party>/identity.key,
+# key có sẵn được giữ nguyên) rồi ghi registry cho coordinator (tssnet/data/registry.json);
+# G có vai trò gateway, các node P1..PN vai trò node.
+# Dùng kèm TSS_IDENTITY=1 ./tssnet/scripts/up.sh N T
+
+N=${1:-3}
+SESSION=${SESSION:-cluster}
+DATA=${DATA:-tssnet/data}
+OUT=${OUT:-$DATA/registry.json}
+
+SCRIPT_DIR=$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)
+ROOT_DIR=$(cd "$SCRIPT_DIR/../.." && pwd)
+cd "$ROOT_DIR"
+
+BIN=$(mktemp -d)
+trap 'rm -rf "$BIN"' EXIT
+(cd go && go build -o "$BIN/tss-node" ./cmd/tss-node)
+mkdir -p "$(dirname "$OUT")"
+
+# pubkey của party; tss-node tạo key nếu chưa có
+pubkey() {
+  mkdir -p "$DATA/$1"
+  "$BIN/tss-node" -gen-identity -identity-key "$DATA/$1/identity.key"
+}
+
+{
+  printf '{"%s":{"G":{"pubkey":"%s","role":"gateway"}' "$SESSION" "$(pubkey G)"
+  for i in $(seq 1 $N); do
+    printf ',"P%s":"%s"' "$i" "$(pubkey "P$i")"
+  done
+  printf '}}\n'
+} > "$OUT"
+
+echo "wrote $OUT (session=$SESSION, parties=G,P1..P$N)"
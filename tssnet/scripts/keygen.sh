@@ -3,4 +3,20 @@ set -euo pipefail
 
 GATEWAY=${GATEWAY_URL:-http://localhost:9100}
 
-curl -sS -X POST "$GATEWAY/keygen" | tee /dev/stderr | jq -r '.address // .AddrHex // empty' || true
+AUTH=()
+if [ -n "${TSS_API_KEY:-}" ]; then
+  AUTH=(-H "X-API-Key: $TSS_API_KEY")
+fi
+
+# PREPARAMS=warm|cold: use pooled pre-params or generate them in round 1
+BODY=()
+if [ -n "${PREPARAMS:-}" ]; then
+  BODY=(-H 'Content-Type: application/json' -d "{\"preparams\":\"$PREPARAMS\"}")
+fi
+
+RESP=$(curl -sS -X POST "${AUTH[@]}" "${BODY[@]}" "$GATEWAY/keygen") || true
+echo "$RESP" >&2
+# t_keygen_ms depends on whether pooled pre-params were used, and without
+# PREPARAMS the nodes use them when ready: print the mode that ran
+echo "$RESP" | jq -r '"preparams=\(.preparams // "?") t_keygen_ms=\(.t_keygen_ms // "?")"' >&2 || true
+echo "$RESP" | jq -r '.address // .AddrHex // empty' || true
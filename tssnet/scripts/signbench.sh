@@ -4,16 +4,124 @@ set -euo pipefail
 GATEWAY=${GATEWAY_URL:-http://localhost:9100}
 HASH=${1:-}
 N=${2:-10}
+# PRESIG=0: ký đầy đủ 9 round, bỏ qua pool presignature của gateway
+# SLEEP: nghỉ giữa các lần ký (giây), để gateway kịp bù presignature
+# HD_PATH: ký bằng key con (vd m/0/7); {i} được thay bằng số thứ tự => mỗi lần một key con
+PRESIG=${PRESIG:-1}
+SLEEP=${SLEEP:-0}
+HD_PATH=${HD_PATH:-}
+# BENCH=1: với mỗi profile trong PROFILES, đặt WAN của coordinator qua admin API
+# rồi chạy N lần không pool (PRESIG=0) và N lần có pool; in bảng so sánh cuối cùng.
+# PROFILES: tên file topology (tương đối thư mục tssnet, vd wan.example.json) hoặc "off" (tắt WAN)
+# ADMIN_URL: admin API của coordinator; TSS_ADMIN_KEY: API key scope admin, mặc định đọc từ
+# data/coordinator/admin_keys.json (file gen_compose.sh sinh cho coordinator)
+# POOL_WAIT: chờ tối đa bao nhiêu giây cho pool đầy trước loạt có pool
+BENCH=${BENCH:-0}
+PROFILES=${PROFILES:-off wan.example.json}
+ADMIN_URL=${ADMIN_URL:-http://127.0.0.1:9001}
+POOL_WAIT=${POOL_WAIT:-120}
 
 if [ -z "$HASH" ]; then
   echo "Usage: $0 <hash_hex_32_bytes> [count]" >&2
+  echo "       BENCH=1 PROFILES='off wan.example.json' $0 <hash_hex_32_bytes> [count]" >&2
   exit 1
 fi
 
-for i in $(seq 1 $N); do
-  resp=$(curl -sS -X POST "$GATEWAY/signHash" -H 'Content-Type: application/json' -d "{\"hash_hex\":\"$HASH\"}")
-  t=$(echo "$resp" | jq -r '.t_sign_ms // empty')
-  r=$(echo "$resp" | jq -r '.r // empty')
-  s=$(echo "$resp" | jq -r '.s // empty')
-  echo "#${i} t_sign_ms=${t} r=${r} s=${s}"
+SCRIPT_DIR=$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)
+TSSNET_DIR=$(cd "$SCRIPT_DIR/.." && pwd)
+
+AUTH=()
+if [ -n "${TSS_API_KEY:-}" ]; then
+  AUTH=(-H "X-API-Key: $TSS_API_KEY")
+fi
+ADMIN_KEYS=$TSSNET_DIR/data/coordinator/admin_keys.json
+if [ -z "${TSS_ADMIN_KEY:-}" ] && [ -f "$ADMIN_KEYS" ]; then
+  TSS_ADMIN_KEY=$(jq -r '.keys[0].secret // empty' "$ADMIN_KEYS")
+fi
+ADMIN_AUTH=()
+if [ -n "${TSS_ADMIN_KEY:-}" ]; then
+  ADMIN_AUTH=(-H "X-API-Key: $TSS_ADMIN_KEY")
+fi
+
+# run_series <presig 0|1>: N lần ký, in từng lần ra stderr và dòng tổng kết ra stdout
+run_series() {
+  local presig=$1 query="" times=() hits=0
+  if [ "$presig" = "0" ]; then
+    query="?presig=0"
+  fi
+  for i in $(seq 1 $N); do
+    path=${HD_PATH//\{i\}/$i}
+    resp=$(curl -sS -X POST "${AUTH[@]}" "$GATEWAY/signHash$query" -H 'Content-Type: application/json' -d "{\"hash_hex\":\"$HASH\",\"path\":\"$path\"}")
+    t=$(echo "$resp" | jq -r '.t_sign_ms // empty')
+    p=$(echo "$resp" | jq -r '.presig // empty')
+    r=$(echo "$resp" | jq -r '.r // empty')
+    s=$(echo "$resp" | jq -r '.s // empty')
+    echo "#${i} t_sign_ms=${t} presig=${p:--} path=${path:--} r=${r} s=${s}" >&2
+    if [ -n "$t" ]; then
+      times+=("$t")
+    fi
+    if [ -n "$p" ]; then
+      hits=$((hits + 1))
+    fi
+    if [ "$i" -lt "$N" ]; then
+      sleep "$SLEEP"
+    fi
+  done
+  if [ ${#times[@]} -gt 0 ]; then
+    printf '%s\n' "${times[@]}" | sort -n | awk -v hits="$hits" '{ v[NR] = $1; sum += $1 } END { printf "n=%d presig_hits=%d mean_ms=%.0f p50_ms=%d min_ms=%d max_ms=%d\n", NR, hits, sum / NR, v[int((NR + 1) / 2)], v[1], v[NR] }'
+  else
+    echo "n=0 (mọi request đều lỗi)"
+  fi
+}
+
+# set_profile <off|file>: đổi WAN giả lập của coordinator lúc đang chạy
+set_profile() {
+  if [ "$1" = "off" ]; then
+    curl -sS -f -X DELETE "${ADMIN_AUTH[@]}" "$ADMIN_URL/wan" >/dev/null
+  else
+    curl -sS -f -X PUT "${ADMIN_AUTH[@]}" --data-binary "@$TSSNET_DIR/$1" "$ADMIN_URL/wan" >/dev/null
+  fi
+}
+
+# wait_pool: chờ pool của key mặc định đầy (target của gateway) hoặc hết POOL_WAIT
+wait_pool() {
+  local deadline=$((SECONDS + POOL_WAIT)) st have target
+  while [ "$SECONDS" -lt "$deadline" ]; do
+    st=$(curl -sS "${AUTH[@]}" "$GATEWAY/presigs")
+    target=$(echo "$st" | jq -r '.target // 0')
+    have=$(echo "$st" | jq -r '(.keys.default // []) | length')
+    if [ "$target" = "0" ]; then
+      echo "WARNING: gateway chạy không có -presig-pool, loạt có pool sẽ ký đầy đủ" >&2
+      return
+    fi
+    if [ "$have" -ge "$target" ]; then
+      return
+    fi
+    sleep 2
+  done
+  echo "WARNING: pool presignature chưa đầy sau ${POOL_WAIT}s" >&2
+}
+
+if [ "$BENCH" != "1" ]; then
+  run_series "$PRESIG"
+  exit 0
+fi
+
+if [ -n "$HD_PATH" ]; then
+  echo "BENCH bỏ qua HD_PATH: presignature chỉ có cho key gốc" >&2
+  HD_PATH=""
+fi
+RESULTS=()
+for prof in $PROFILES; do
+  echo "=== profile $prof ===" >&2
+  set_profile "$prof"
+  echo "--- no pool (PRESIG=0) ---" >&2
+  RESULTS+=("$prof presig=0 $(run_series 0)")
+  wait_pool
+  echo "--- pool ---" >&2
+  RESULTS+=("$prof presig=1 $(run_series 1)")
 done
+set_profile off
+
+echo "--- T_sign theo profile ---"
+printf '%s\n' "${RESULTS[@]}"